@@ -0,0 +1,397 @@
+package main
+
+import (
+	"crypto"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// JWT signing algorithms
+const (
+	HS256 = "HS256"
+	RS256 = "RS256"
+)
+
+const (
+	DefaultAPIKeyHeader = "X-Api-Key"
+	DefaultRoleClaim    = "role"
+	// jwtLeeway - clock skew tolerated for exp and nbf
+	jwtLeeway = 30 * time.Second
+)
+
+var (
+	// ErrNoCredentials - request carries no credentials for the authenticator, the next one is tried
+	ErrNoCredentials = errors.New("no credentials")
+	ErrUnauthorized  = errors.New("unauthorized")
+)
+
+// Authenticator - Resolve the session of the caller from the request
+type Authenticator interface {
+	Authenticate(r *http.Request) (*Session, error)
+}
+
+type AuthConfig struct {
+	JWT     *JWTConfig
+	APIKeys map[string]APIKey
+	// APIKeyHeader - header carrying the api key, X-Api-Key by default
+	APIKeyHeader string
+	// AllowAnonymous - requests without credentials get an anonymous session instead of 401
+	AllowAnonymous bool
+}
+
+type JWTConfig struct {
+	// Algorithm - HS256 or RS256, tokens signed with any other algorithm are rejected
+	Algorithm string
+	// Secret - shared secret for HS256
+	Secret string
+	// JWKSFile - path to a local JSON Web Key Set with the RSA public keys for RS256
+	JWKSFile string
+	Issuer   string
+	Audience string
+	// RoleClaim - dot separated path of the claim holding the role, role by default
+	RoleClaim   string
+	DefaultRole string
+	// Variables - session variable to dot separated claim path, eg "X-Merchant-Id": "merchant.id"
+	Variables map[string]string
+}
+
+// APIKey - session granted to the holder of a static api key
+type APIKey struct {
+	Role      string
+	Variables map[string]string
+}
+
+// NewAuthenticator - Authenticator trying JWT and api keys in order
+func NewAuthenticator(config AuthConfig) (Authenticator, error) {
+	var chain authenticatorChain
+
+	if config.JWT != nil {
+		authenticator, err := NewJWTAuthenticator(*config.JWT)
+		if err != nil {
+			return nil, err
+		}
+		chain.authenticators = append(chain.authenticators, authenticator)
+	}
+
+	if len(config.APIKeys) > 0 {
+		chain.authenticators = append(chain.authenticators, NewAPIKeyAuthenticator(config.APIKeyHeader, config.APIKeys))
+	}
+	chain.allowAnonymous = config.AllowAnonymous
+
+	return chain, nil
+}
+
+// AuthMiddleware - Authenticate the request and attach the session to its context
+func AuthMiddleware(authenticator Authenticator, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		session, err := authenticator.Authenticate(r)
+		if err != nil {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusUnauthorized)
+			_ = json.NewEncoder(w).Encode(map[string]interface{}{
+				"errors": []map[string]interface{}{{"message": err.Error()}},
+			})
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(WithSession(r.Context(), session)))
+	})
+}
+
+type authenticatorChain struct {
+	authenticators []Authenticator
+	allowAnonymous bool
+}
+
+func (c authenticatorChain) Authenticate(r *http.Request) (*Session, error) {
+	for _, authenticator := range c.authenticators {
+		session, err := authenticator.Authenticate(r)
+		if errors.Is(err, ErrNoCredentials) {
+			continue
+		}
+		return session, err
+	}
+
+	if c.allowAnonymous {
+		return NewSession(DefaultRole, nil), nil
+	}
+	return nil, fmt.Errorf("%w: %v", ErrUnauthorized, ErrNoCredentials)
+}
+
+type apiKeyAuthenticator struct {
+	header string
+	// keys - api keys by their sha256 digest so lookups do not compare secrets byte by byte
+	keys map[string]APIKey
+}
+
+func NewAPIKeyAuthenticator(header string, keys map[string]APIKey) Authenticator {
+	if header == "" {
+		header = DefaultAPIKeyHeader
+	}
+
+	authenticator := apiKeyAuthenticator{header: header, keys: map[string]APIKey{}}
+	for key, apiKey := range keys {
+		authenticator.keys[digest(key)] = apiKey
+	}
+
+	return authenticator
+}
+
+func (a apiKeyAuthenticator) Authenticate(r *http.Request) (*Session, error) {
+	key := r.Header.Get(a.header)
+	if key == "" {
+		return nil, ErrNoCredentials
+	}
+
+	apiKey, ok := a.keys[digest(key)]
+	if !ok {
+		return nil, fmt.Errorf("%w: invalid api key", ErrUnauthorized)
+	}
+
+	return NewSession(apiKey.Role, apiKey.Variables), nil
+}
+
+func digest(value string) string {
+	sum := sha256.Sum256([]byte(value))
+	return hex.EncodeToString(sum[:])
+}
+
+type jwtAuthenticator struct {
+	config JWTConfig
+	// keys - RSA public keys from the key set by key id
+	keys map[string]*rsa.PublicKey
+}
+
+func NewJWTAuthenticator(config JWTConfig) (Authenticator, error) {
+	if config.RoleClaim == "" {
+		config.RoleClaim = DefaultRoleClaim
+	}
+
+	authenticator := &jwtAuthenticator{config: config}
+	switch config.Algorithm {
+	case HS256:
+		if config.Secret == "" {
+			return nil, errors.New("jwt: HS256 requires a secret")
+		}
+	case RS256:
+		keys, err := loadJWKS(config.JWKSFile)
+		if err != nil {
+			return nil, err
+		}
+		authenticator.keys = keys
+	default:
+		return nil, fmt.Errorf("jwt: unsupported algorithm %q", config.Algorithm)
+	}
+
+	return authenticator, nil
+}
+
+type jwtHeader struct {
+	Algorithm string `json:"alg"`
+	KeyID     string `json:"kid"`
+}
+
+func (a *jwtAuthenticator) Authenticate(r *http.Request) (*Session, error) {
+	authorization := r.Header.Get("Authorization")
+	if !strings.HasPrefix(authorization, "Bearer ") {
+		return nil, ErrNoCredentials
+	}
+
+	claims, err := a.verify(strings.TrimPrefix(authorization, "Bearer "))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrUnauthorized, err)
+	}
+
+	role := a.config.DefaultRole
+	if value, ok := claim(claims, a.config.RoleClaim).(string); ok {
+		role = value
+	}
+
+	variables := map[string]string{}
+	for variable, path := range a.config.Variables {
+		if value := claim(claims, path); value != nil {
+			variables[variable] = fmt.Sprint(value)
+		}
+	}
+
+	return NewSession(role, variables), nil
+}
+
+// verify - Check signature and registered claims of the token, returns its claims
+func (a *jwtAuthenticator) verify(token string) (map[string]interface{}, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("malformed token")
+	}
+
+	var header jwtHeader
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, err
+	}
+	// The algorithm is pinned by configuration, trusting the header allows downgrading to none or
+	// verifying an RS256 public key as HS256 secret
+	if header.Algorithm != a.config.Algorithm {
+		return nil, fmt.Errorf("unexpected signing algorithm %q", header.Algorithm)
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, err
+	}
+
+	signed := []byte(parts[0] + "." + parts[1])
+	switch a.config.Algorithm {
+	case HS256:
+		mac := hmac.New(sha256.New, []byte(a.config.Secret))
+		mac.Write(signed)
+		if subtle.ConstantTimeCompare(mac.Sum(nil), signature) != 1 {
+			return nil, errors.New("invalid signature")
+		}
+	case RS256:
+		key, err := a.key(header.KeyID)
+		if err != nil {
+			return nil, err
+		}
+		hashed := sha256.Sum256(signed)
+		if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, hashed[:], signature); err != nil {
+			return nil, errors.New("invalid signature")
+		}
+	}
+
+	claims := map[string]interface{}{}
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, err
+	}
+
+	return claims, a.validate(claims)
+}
+
+func (a *jwtAuthenticator) key(keyID string) (*rsa.PublicKey, error) {
+	if key, ok := a.keys[keyID]; ok {
+		return key, nil
+	}
+	// Tokens without key id are accepted when the key set holds a single key
+	if keyID == "" && len(a.keys) == 1 {
+		for _, key := range a.keys {
+			return key, nil
+		}
+	}
+
+	return nil, fmt.Errorf("unknown key id %q", keyID)
+}
+
+func (a *jwtAuthenticator) validate(claims map[string]interface{}) error {
+	now := time.Now()
+
+	if exp, ok := claims["exp"].(float64); ok && now.After(time.Unix(int64(exp), 0).Add(jwtLeeway)) {
+		return errors.New("token expired")
+	}
+	if nbf, ok := claims["nbf"].(float64); ok && now.Add(jwtLeeway).Before(time.Unix(int64(nbf), 0)) {
+		return errors.New("token not valid yet")
+	}
+	if a.config.Issuer != "" && claims["iss"] != a.config.Issuer {
+		return errors.New("invalid issuer")
+	}
+
+	if a.config.Audience != "" {
+		switch aud := claims["aud"].(type) {
+		case string:
+			if aud == a.config.Audience {
+				return nil
+			}
+		case []interface{}:
+			for _, value := range aud {
+				if value == a.config.Audience {
+					return nil
+				}
+			}
+		}
+		return errors.New("invalid audience")
+	}
+
+	return nil
+}
+
+func decodeSegment(segment string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(data, v)
+}
+
+// claim - Value at the dot separated path in claims, nil if missing
+func claim(claims map[string]interface{}, path string) interface{} {
+	var value interface{} = claims
+	for _, key := range strings.Split(path, ".") {
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		value = object[key]
+	}
+
+	return value
+}
+
+type jsonWebKey struct {
+	KeyType string `json:"kty"`
+	KeyID   string `json:"kid"`
+	N       string `json:"n"`
+	E       string `json:"e"`
+}
+
+// loadJWKS - RSA public keys by key id from a JSON Web Key Set file
+func loadJWKS(path string) (map[string]*rsa.PublicKey, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("jwks: %w", err)
+	}
+
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, fmt.Errorf("jwks: %w", err)
+	}
+
+	keys := map[string]*rsa.PublicKey{}
+	for _, key := range set.Keys {
+		if key.KeyType != "RSA" {
+			continue
+		}
+
+		n, err := base64.RawURLEncoding.DecodeString(key.N)
+		if err != nil {
+			return nil, fmt.Errorf("jwks: key %q: %w", key.KeyID, err)
+		}
+		e, err := base64.RawURLEncoding.DecodeString(key.E)
+		if err != nil {
+			return nil, fmt.Errorf("jwks: key %q: %w", key.KeyID, err)
+		}
+
+		keys[key.KeyID] = &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}
+	}
+
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("jwks: no RSA keys in %s", path)
+	}
+
+	return keys, nil
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"crypto"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func signToken(t *testing.T, header, claims map[string]interface{}, sign func([]byte) []byte) string {
+	h, err := json.Marshal(header)
+	assert.Nil(t, err)
+	c, err := json.Marshal(claims)
+	assert.Nil(t, err)
+
+	signed := base64.RawURLEncoding.EncodeToString(h) + "." + base64.RawURLEncoding.EncodeToString(c)
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sign([]byte(signed)))
+}
+
+func bearerRequest(token string) *http.Request {
+	r := httptest.NewRequest(http.MethodPost, "/graphql", nil)
+	r.Header.Set("Authorization", "Bearer "+token)
+	return r
+}
+
+func TestJWTAuthenticator_HS256(t *testing.T) {
+	authenticator, err := NewJWTAuthenticator(JWTConfig{
+		Algorithm: HS256,
+		Secret:    "secret",
+		Audience:  "api",
+		Variables: map[string]string{"X-Merchant-Id": "merchant.id"},
+	})
+	assert.Nil(t, err)
+
+	hs256 := func(secret string) func([]byte) []byte {
+		return func(data []byte) []byte {
+			mac := hmac.New(sha256.New, []byte(secret))
+			mac.Write(data)
+			return mac.Sum(nil)
+		}
+	}
+	claims := map[string]interface{}{
+		"role":     "merchant",
+		"aud":      []string{"api"},
+		"exp":      time.Now().Add(time.Hour).Unix(),
+		"merchant": map[string]interface{}{"id": "m1"},
+	}
+
+	session, err := authenticator.Authenticate(bearerRequest(signToken(t, map[string]interface{}{"alg": HS256}, claims, hs256("secret"))))
+	assert.Nil(t, err)
+	assert.Equal(t, "merchant", session.Role)
+	value, _ := session.Get("X-Merchant-Id")
+	assert.Equal(t, "m1", value)
+
+	_, err = authenticator.Authenticate(bearerRequest(signToken(t, map[string]interface{}{"alg": HS256}, claims, hs256("other"))))
+	assert.NotNil(t, err)
+
+	_, err = authenticator.Authenticate(bearerRequest(signToken(t, map[string]interface{}{"alg": "none"}, claims, hs256("secret"))))
+	assert.NotNil(t, err)
+
+	claims["exp"] = time.Now().Add(-time.Hour).Unix()
+	_, err = authenticator.Authenticate(bearerRequest(signToken(t, map[string]interface{}{"alg": HS256}, claims, hs256("secret"))))
+	assert.NotNil(t, err)
+}
+
+func TestJWTAuthenticator_RS256(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.Nil(t, err)
+
+	jwks, _ := json.Marshal(map[string]interface{}{
+		"keys": []map[string]interface{}{{
+			"kty": "RSA",
+			"kid": "k1",
+			"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}},
+	})
+	dir, err := ioutil.TempDir("", "jwks")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "jwks.json")
+	assert.Nil(t, ioutil.WriteFile(path, jwks, 0600))
+
+	authenticator, err := NewJWTAuthenticator(JWTConfig{Algorithm: RS256, JWKSFile: path, DefaultRole: "user"})
+	assert.Nil(t, err)
+
+	token := signToken(t, map[string]interface{}{"alg": RS256, "kid": "k1"}, map[string]interface{}{"sub": "1"}, func(data []byte) []byte {
+		hashed := sha256.Sum256(data)
+		signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, hashed[:])
+		assert.Nil(t, err)
+		return signature
+	})
+
+	session, err := authenticator.Authenticate(bearerRequest(token))
+	assert.Nil(t, err)
+	assert.Equal(t, "user", session.Role)
+}
+
+func TestAuthenticatorChain(t *testing.T) {
+	authenticator, err := NewAuthenticator(AuthConfig{
+		APIKeys: map[string]APIKey{"key": {Role: "support"}},
+	})
+	assert.Nil(t, err)
+
+	r := httptest.NewRequest(http.MethodPost, "/graphql", nil)
+	_, err = authenticator.Authenticate(r)
+	assert.NotNil(t, err)
+
+	r.Header.Set(DefaultAPIKeyHeader, "key")
+	session, err := authenticator.Authenticate(r)
+	assert.Nil(t, err)
+	assert.Equal(t, "support", session.Role)
+
+	r.Header.Set(DefaultAPIKeyHeader, "wrong")
+	_, err = authenticator.Authenticate(r)
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,369 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	gomysql "github.com/go-mysql-org/go-mysql/mysql"
+	"github.com/go-mysql-org/go-mysql/replication"
+)
+
+// positionSaveInterval - time between two saves of the binlog position while events stream in
+const positionSaveInterval = time.Second
+
+// BinlogPosition - position in the binlog of the primary, Pos is the offset in the file Name
+type BinlogPosition struct {
+	Name string `json:"name"`
+	Pos  uint32 `json:"pos"`
+}
+
+// PositionStore - Storage of the binlog position a BinlogFeed resumes from after a restart
+type PositionStore interface {
+	// Load - Saved position, false if none was saved yet
+	Load() (BinlogPosition, bool, error)
+	Save(position BinlogPosition) error
+}
+
+// FilePositionStore - PositionStore keeping the position in a JSON file at Path
+type FilePositionStore struct {
+	Path string
+}
+
+func (s FilePositionStore) Load() (BinlogPosition, bool, error) {
+	content, err := ioutil.ReadFile(s.Path)
+	if os.IsNotExist(err) {
+		return BinlogPosition{}, false, nil
+	}
+	if err != nil {
+		return BinlogPosition{}, false, err
+	}
+
+	var position BinlogPosition
+	if err := json.Unmarshal(content, &position); err != nil {
+		return BinlogPosition{}, false, fmt.Errorf("invalid binlog position %s: %w", s.Path, err)
+	}
+
+	return position, true, nil
+}
+
+// Save - Replace the file atomically so that a crash never leaves a partial position behind
+func (s FilePositionStore) Save(position BinlogPosition) error {
+	content, err := json.Marshal(position)
+	if err != nil {
+		return err
+	}
+
+	tmp := s.Path + ".tmp"
+	if err := ioutil.WriteFile(tmp, content, 0600); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, s.Path)
+}
+
+// BinlogSource - Database a BinlogFeed replicates from
+type BinlogSource interface {
+	// GetColumnNames - Columns of table in the order of their position
+	GetColumnNames(ctx context.Context, table string) ([]string, error)
+	// BinlogPosition - Current end of the binlog, streaming starts there without saved position
+	BinlogPosition(ctx context.Context) (BinlogPosition, error)
+}
+
+// BinlogConfig - replication client options of a BinlogFeed
+type BinlogConfig struct {
+	// ServerID - id of the feed as replica, unique among the replicas of the primary
+	ServerID uint32
+	Host     string
+	Port     uint16
+	User     string
+	Password string
+	// Schema - database the tables of the entities are in, events of other databases are skipped
+	Schema string
+	// Source - database column names and the start position are read from
+	Source BinlogSource
+	// Positions - store the position is saved to, without one streaming starts at the end of the
+	// binlog after every restart
+	Positions PositionStore
+	// Invalidate - called with every entity a transaction changed once it committed, before its
+	// watchers are notified, eg Engine.InvalidateEntity so that no cached result outlives a write
+	Invalidate func(entity string)
+}
+
+// BinlogFeed - ChangeFeed decoding the row events of the binlog, it connects as replication client
+// and reports the changed rows of a transaction once it committed. Tables are matched to entities
+// by their table name.
+type BinlogFeed struct {
+	config BinlogConfig
+
+	mu       sync.Mutex
+	watchers map[string]map[int]func(Change)
+	nextID   int
+
+	// Only touched by the goroutine handling events
+	columns  map[string][]string
+	pending  map[string][]map[string]interface{}
+	position BinlogPosition
+	saved    time.Time
+}
+
+func NewBinlogFeed(config BinlogConfig) *BinlogFeed {
+	return &BinlogFeed{
+		config:   config,
+		watchers: map[string]map[int]func(Change){},
+		columns:  map[string][]string{},
+		pending:  map[string][]map[string]interface{}{},
+	}
+}
+
+func (f *BinlogFeed) Watch(entity string, notify func(Change)) (func(), error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.watchers[entity] == nil {
+		f.watchers[entity] = map[int]func(Change){}
+	}
+	f.nextID++
+	id := f.nextID
+	f.watchers[entity][id] = notify
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			f.mu.Lock()
+			defer f.mu.Unlock()
+
+			delete(f.watchers[entity], id)
+			if len(f.watchers[entity]) == 0 {
+				delete(f.watchers, entity)
+			}
+		})
+	}, nil
+}
+
+// Run - Stream the binlog from the saved position, or from its current end, until ctx is done
+func (f *BinlogFeed) Run(ctx context.Context) error {
+	position, ok, err := f.loadPosition()
+	if err != nil {
+		return err
+	}
+	if !ok {
+		if position, err = f.config.Source.BinlogPosition(ctx); err != nil {
+			return err
+		}
+	}
+	f.position = position
+
+	syncer := replication.NewBinlogSyncer(replication.BinlogSyncerConfig{
+		ServerID: f.config.ServerID,
+		Flavor:   gomysql.MySQLFlavor,
+		Host:     f.config.Host,
+		Port:     f.config.Port,
+		User:     f.config.User,
+		Password: f.config.Password,
+	})
+	defer syncer.Close()
+
+	streamer, err := syncer.StartSync(gomysql.Position{Name: position.Name, Pos: position.Pos})
+	if err != nil {
+		return err
+	}
+
+	defer f.savePosition(true)
+	for {
+		event, err := streamer.GetEvent(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+
+		f.handle(ctx, event)
+	}
+}
+
+// Replay - Handle the events of the binlog file at path from the saved position, or from its
+// start, eg to catch up from a copied binlog or to replay a recorded fixture
+func (f *BinlogFeed) Replay(ctx context.Context, path string) error {
+	position, ok, err := f.loadPosition()
+	if err != nil {
+		return err
+	}
+	if !ok || position.Name != filepath.Base(path) {
+		position = BinlogPosition{Name: filepath.Base(path), Pos: 4}
+	}
+	f.position = position
+
+	defer f.savePosition(true)
+	parser := replication.NewBinlogParser()
+	return parser.ParseFile(path, int64(position.Pos), func(event *replication.BinlogEvent) error {
+		// The format description is read again when starting past it, it is no new position
+		if event.Header.EventType != replication.FORMAT_DESCRIPTION_EVENT {
+			f.handle(ctx, event)
+		}
+		return ctx.Err()
+	})
+}
+
+func (f *BinlogFeed) handle(ctx context.Context, event *replication.BinlogEvent) {
+	switch e := event.Event.(type) {
+	case *replication.RotateEvent:
+		f.position = BinlogPosition{Name: string(e.NextLogName), Pos: uint32(e.Position)}
+		f.savePosition(true)
+	case *replication.RowsEvent:
+		f.rows(ctx, event.Header.EventType, e)
+	case *replication.XIDEvent:
+		f.commit(event.Header.LogPos)
+	case *replication.QueryEvent:
+		query := strings.TrimSpace(strings.ToUpper(string(e.Query)))
+		if query == "BEGIN" {
+			return
+		}
+		// DDL may change the columns of a table, COMMIT ends transactions without XID
+		if query != "COMMIT" {
+			f.columns = map[string][]string{}
+		}
+		f.commit(event.Header.LogPos)
+	}
+}
+
+// rows - Remember the rows of e until their transaction committed, updates have the rows before
+// and after the update so that subscribers of either see the change
+func (f *BinlogFeed) rows(ctx context.Context, eventType replication.EventType, e *replication.RowsEvent) {
+	if f.config.Schema != "" && string(e.Table.Schema) != f.config.Schema {
+		return
+	}
+
+	table := string(e.Table.Table)
+	entities := f.entities(table)
+	if len(entities) == 0 {
+		return
+	}
+
+	columns, err := f.columnNames(ctx, table, e.Table)
+	if err != nil {
+		log.Printf("failed to get columns of %s, error: %v", table, err)
+	}
+
+	for _, values := range e.Rows {
+		var row map[string]interface{}
+		// Without column names the row is unknown, which wakes every subscriber of its entity
+		if len(columns) == len(values) {
+			row = make(map[string]interface{}, len(values))
+			for idx, value := range values {
+				if value, ok := value.([]byte); ok {
+					row[columns[idx]] = string(value)
+					continue
+				}
+				row[columns[idx]] = value
+			}
+		}
+
+		for _, entity := range entities {
+			f.pending[entity] = append(f.pending[entity], row)
+		}
+	}
+}
+
+// entities - Watched entities of table
+func (f *BinlogFeed) entities(table string) []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var entities []string
+	for entity := range f.watchers {
+		if Entities.GetTableName(entity) == table {
+			entities = append(entities, entity)
+		}
+	}
+
+	return entities
+}
+
+// columnNames - Columns of table, from the table map event when the server logs them
+// (binlog_row_metadata=FULL) and from the source otherwise
+func (f *BinlogFeed) columnNames(ctx context.Context, table string, tableMap *replication.TableMapEvent) ([]string, error) {
+	if len(tableMap.ColumnName) > 0 {
+		columns := make([]string, len(tableMap.ColumnName))
+		for idx, name := range tableMap.ColumnName {
+			columns[idx] = string(name)
+		}
+		return columns, nil
+	}
+
+	if columns, ok := f.columns[table]; ok {
+		return columns, nil
+	}
+	if f.config.Source == nil {
+		return nil, fmt.Errorf("no column names of %s in the binlog and no source to read them from", table)
+	}
+
+	columns, err := f.config.Source.GetColumnNames(ctx, table)
+	if err != nil {
+		return nil, err
+	}
+	f.columns[table] = columns
+
+	return columns, nil
+}
+
+// commit - Report the rows of the committed transaction to the watchers of their entities, the
+// position is saved after so that no change is lost across restarts
+func (f *BinlogFeed) commit(logPos uint32) {
+	pending := f.pending
+	f.pending = map[string][]map[string]interface{}{}
+
+	for entity, rows := range pending {
+		if f.config.Invalidate != nil {
+			f.config.Invalidate(entity)
+		}
+
+		f.mu.Lock()
+		watchers := make([]func(Change), 0, len(f.watchers[entity]))
+		for _, notify := range f.watchers[entity] {
+			watchers = append(watchers, notify)
+		}
+		f.mu.Unlock()
+
+		for _, notify := range watchers {
+			notify(Change{Entity: entity, Rows: rows})
+		}
+	}
+
+	if logPos > 0 {
+		f.position.Pos = logPos
+	}
+	f.savePosition(false)
+}
+
+func (f *BinlogFeed) loadPosition() (BinlogPosition, bool, error) {
+	if f.config.Positions == nil {
+		return BinlogPosition{}, false, nil
+	}
+
+	return f.config.Positions.Load()
+}
+
+// savePosition - Save the position, at most every positionSaveInterval unless forced
+func (f *BinlogFeed) savePosition(force bool) {
+	if f.config.Positions == nil || f.position.Name == "" {
+		return
+	}
+	if !force && time.Since(f.saved) < positionSaveInterval {
+		return
+	}
+
+	if err := f.config.Positions.Save(f.position); err != nil {
+		log.Printf("failed to save binlog position, error: %v", err)
+		return
+	}
+	f.saved = time.Now()
+}
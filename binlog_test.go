@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// testdata/mysql-bin.000001 holds, for shop.payments (id int, merchant_id varchar(10),
+// status varchar(10)), one transaction each:
+//   - insert (1, m1, pending)
+//   - update of it to settled
+//   - an insert into shop.refunds
+//   - insert (2, m2, pending)
+//   - delete of it
+//
+// It ends by rotating to mysql-bin.000002.
+const binlogFixture = "testdata/mysql-bin.000001"
+
+func TestBinlogFeed_Replay(t *testing.T) {
+	Entities["binlog_payments"] = EntityConfig{TableName: "payments"}
+	defer delete(Entities, "binlog_payments")
+
+	dir, err := ioutil.TempDir("", "binlog")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	positions := FilePositionStore{Path: filepath.Join(dir, "binlog.position")}
+
+	var invalidated []string
+	invalidate := func(entity string) { invalidated = append(invalidated, entity) }
+	feed := NewBinlogFeed(BinlogConfig{Schema: "shop", Positions: positions, Invalidate: invalidate})
+	var changes []Change
+	stop, err := feed.Watch("binlog_payments", func(change Change) { changes = append(changes, change) })
+	assert.Nil(t, err)
+	defer stop()
+
+	assert.Nil(t, feed.Replay(context.Background(), binlogFixture))
+
+	pending := map[string]interface{}{"id": int32(1), "merchant_id": "m1", "status": "pending"}
+	settled := map[string]interface{}{"id": int32(1), "merchant_id": "m1", "status": "settled"}
+	other := map[string]interface{}{"id": int32(2), "merchant_id": "m2", "status": "pending"}
+	assert.Equal(t, []Change{
+		{Entity: "binlog_payments", Rows: []map[string]interface{}{pending}},
+		{Entity: "binlog_payments", Rows: []map[string]interface{}{pending, settled}},
+		{Entity: "binlog_payments", Rows: []map[string]interface{}{other}},
+		{Entity: "binlog_payments", Rows: []map[string]interface{}{other}},
+	}, changes)
+	// Cached results are dropped on every committed change
+	assert.Equal(t, []string{"binlog_payments", "binlog_payments", "binlog_payments", "binlog_payments"}, invalidated)
+
+	position, ok, err := positions.Load()
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, BinlogPosition{Name: "mysql-bin.000002", Pos: 4}, position)
+
+	// Resumes after the update, the last saved position
+	assert.Nil(t, positions.Save(BinlogPosition{Name: "mysql-bin.000001", Pos: 444}))
+	changes = nil
+	assert.Nil(t, feed.Replay(context.Background(), binlogFixture))
+	assert.Equal(t, []Change{
+		{Entity: "binlog_payments", Rows: []map[string]interface{}{other}},
+		{Entity: "binlog_payments", Rows: []map[string]interface{}{other}},
+	}, changes)
+
+	// Other databases are skipped
+	changes = nil
+	feed = NewBinlogFeed(BinlogConfig{Schema: "other"})
+	_, err = feed.Watch("binlog_payments", func(change Change) { changes = append(changes, change) })
+	assert.Nil(t, err)
+	assert.Nil(t, feed.Replay(context.Background(), binlogFixture))
+	assert.Empty(t, changes)
+}
+
+func TestMatchFilter(t *testing.T) {
+	row := map[string]interface{}{"id": int32(7), "merchant_id": "M1", "amount": "12.50", "refunded_at": nil}
+
+	for _, test := range []struct {
+		filter map[string]interface{}
+		match  bool
+	}{
+		{nil, true},
+		{map[string]interface{}{"merchant_id": "m1"}, true},
+		{map[string]interface{}{"merchant_id": map[string]interface{}{"_eq": "m2"}}, false},
+		{map[string]interface{}{"id": map[string]interface{}{"_gt": int64(5), "_lte": int64(7)}}, true},
+		{map[string]interface{}{"id": map[string]interface{}{"_in": []interface{}{int64(1), int64(7)}}}, true},
+		{map[string]interface{}{"id": map[string]interface{}{"_in": []interface{}{}}}, false},
+		{map[string]interface{}{"amount": map[string]interface{}{"_gte": 12.5}}, true},
+		{map[string]interface{}{"refunded_at": map[string]interface{}{"_ne": "x"}}, false},
+		{map[string]interface{}{"merchant_id": map[string]interface{}{"_lt": "m10"}}, true},
+		{map[string]interface{}{"unknown": "x"}, true},
+	} {
+		assert.Equal(t, test.match, MatchFilter(test.filter, row), "%v", test.filter)
+	}
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"bytes"
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/language/ast"
+	"github.com/graphql-go/graphql/language/printer"
+)
+
+// DefaultResultCacheSize - results kept by the in-memory cache when no capacity is given
+const DefaultResultCacheSize = 10000
+
+// ResultCache - Storage of serialized query results, entries are tagged with the entities they
+// were read from so that a write to an entity drops them. Implementations must be safe for
+// concurrent use.
+type ResultCache interface {
+	Get(key string) ([]byte, bool)
+	Set(key string, value []byte, ttl time.Duration, tags []string)
+	// Invalidate - Drop every entry tagged with tag
+	Invalidate(tag string)
+}
+
+// LRUCache - In-memory ResultCache evicting the least recently used entry once full
+type LRUCache struct {
+	capacity int
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	order   *list.List
+	tags    map[string]map[string]struct{}
+}
+
+type lruEntry struct {
+	key     string
+	value   []byte
+	expires time.Time
+	tags    []string
+}
+
+// NewLRUCache - LRUCache of capacity entries, DefaultResultCacheSize if capacity is not positive
+func NewLRUCache(capacity int) *LRUCache {
+	if capacity <= 0 {
+		capacity = DefaultResultCacheSize
+	}
+
+	return &LRUCache{
+		capacity: capacity,
+		entries:  map[string]*list.Element{},
+		order:    list.New(),
+		tags:     map[string]map[string]struct{}{},
+	}
+}
+
+func (c *LRUCache) Get(key string) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	element, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+
+	entry := element.Value.(*lruEntry)
+	if time.Now().After(entry.expires) {
+		c.remove(element)
+		return nil, false
+	}
+
+	c.order.MoveToFront(element)
+	return entry.value, true
+}
+
+func (c *LRUCache) Set(key string, value []byte, ttl time.Duration, tags []string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if element, ok := c.entries[key]; ok {
+		c.remove(element)
+	}
+
+	entry := &lruEntry{key: key, value: value, expires: time.Now().Add(ttl), tags: tags}
+	c.entries[key] = c.order.PushFront(entry)
+	for _, tag := range tags {
+		if c.tags[tag] == nil {
+			c.tags[tag] = map[string]struct{}{}
+		}
+		c.tags[tag][key] = struct{}{}
+	}
+
+	for c.order.Len() > c.capacity {
+		c.remove(c.order.Back())
+	}
+}
+
+func (c *LRUCache) Invalidate(tag string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for key := range c.tags[tag] {
+		if element, ok := c.entries[key]; ok {
+			c.remove(element)
+		}
+	}
+	delete(c.tags, tag)
+}
+
+// Len - Number of entries, including expired ones not dropped yet
+func (c *LRUCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.order.Len()
+}
+
+func (c *LRUCache) remove(element *list.Element) {
+	entry := c.order.Remove(element).(*lruEntry)
+	delete(c.entries, entry.key)
+	for _, tag := range entry.tags {
+		delete(c.tags[tag], entry.key)
+		if len(c.tags[tag]) == 0 {
+			delete(c.tags, tag)
+		}
+	}
+}
+
+// resultCacheKey - Key of the result of the operation of document for session, the query is printed in canonical
+// form so that formatting does not matter and the role and session variables are part of the key
+// as they decide which rows are visible
+func resultCacheKey(document *ast.Document, operationName string, variables map[string]interface{}, session *Session) (string, error) {
+	names := make([]string, 0, len(session.Variables))
+	for name := range session.Variables {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	sessionVariables := make([][2]string, len(names))
+	for idx, name := range names {
+		sessionVariables[idx] = [2]string{name, session.Variables[name]}
+	}
+
+	// Maps are encoded with sorted keys, which makes the encoding of variables canonical
+	key, err := json.Marshal([]interface{}{printer.Print(document), operationName, variables, session.Role, sessionVariables})
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256(key)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// cachePolicy - TTL of a result read from entities, a result is only cached if every
+// entity has a TTL and it is cached for the shortest of them
+func cachePolicy(entities []string) (time.Duration, bool) {
+	var ttl time.Duration
+	for _, entity := range entities {
+		entityTTL := Entities.GetCacheTTL(entity)
+		if entityTTL <= 0 {
+			return 0, false
+		}
+		if ttl == 0 || entityTTL < ttl {
+			ttl = entityTTL
+		}
+	}
+
+	return ttl, len(entities) > 0
+}
+
+// cachedResult - Result cached under key, numbers are kept as json.Number so they are served as
+// they were fetched
+func (e *Engine) cachedResult(key string) (*graphql.Result, bool) {
+	value, ok := e.Cache.Get(key)
+	if !ok {
+		return nil, false
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(value))
+	decoder.UseNumber()
+
+	var result graphql.Result
+	if err := decoder.Decode(&result); err != nil {
+		return nil, false
+	}
+
+	return &result, true
+}
+
+// cacheResult - Cache result under key unless it has errors
+func (e *Engine) cacheResult(key string, result *graphql.Result, ttl time.Duration, entities []string) {
+	if result.HasErrors() {
+		return
+	}
+
+	value, err := json.Marshal(result)
+	if err != nil {
+		return
+	}
+
+	e.Cache.Set(key, value, ttl, entities)
+}
+
+// Exec - Run statement writing to entity on its data source and drop the cached results read
+// from entity, also when the statement failed as it may have written some rows. Writes made
+// outside of the engine are only seen by the change feeds, see InvalidateEntity.
+func (e *Engine) Exec(ctx context.Context, entity string, statement *Statement) (sql.Result, error) {
+	dataSource, err := e.DataSource(entity)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := dataSource.Exec(ctx, statement)
+	e.InvalidateEntity(entity)
+
+	return result, err
+}
+
+// InvalidateEntity - Drop the cached results read from entity, eg after it was written to
+// outside of the engine. The binlog feed calls it for every committed change, the polling feed
+// for the changes of the entities it polls.
+func (e *Engine) InvalidateEntity(entity string) {
+	if e.Cache != nil {
+		e.Cache.Invalidate(entity)
+	}
+}
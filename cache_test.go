@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLRUCache(t *testing.T) {
+	cache := NewLRUCache(2)
+	cache.Set("a", []byte("1"), time.Minute, []string{"payments"})
+	cache.Set("b", []byte("2"), time.Minute, []string{"payments", "merchants"})
+
+	value, ok := cache.Get("a")
+	assert.True(t, ok)
+	assert.Equal(t, []byte("1"), value)
+
+	// b is least recently used
+	cache.Set("c", []byte("3"), time.Minute, []string{"merchants"})
+	_, ok = cache.Get("b")
+	assert.False(t, ok)
+	assert.Equal(t, 2, cache.Len())
+
+	cache.Invalidate("merchants")
+	_, ok = cache.Get("c")
+	assert.False(t, ok)
+	_, ok = cache.Get("a")
+	assert.True(t, ok)
+
+	cache.Set("d", []byte("4"), -time.Second, nil)
+	_, ok = cache.Get("d")
+	assert.False(t, ok)
+}
+
+func TestEngine_QueryCache(t *testing.T) {
+	Entities["cache_test"] = EntityConfig{
+		TableName: "cache_test",
+		CacheTTL:  time.Minute,
+	}
+	defer delete(Entities, "cache_test")
+
+	dataSource := &fakeDataSource{
+		schema: TableSchema{"id": "bigint unsigned"},
+		rows:   []map[string]interface{}{{"id": uint64(1 << 60)}},
+	}
+	engine := NewEngine(map[string]DataSource{DefaultDataSource: dataSource})
+	engine.Cache = NewLRUCache(0)
+
+	ctx := context.Background()
+	first, _ := json.Marshal(engine.Query(ctx, `{ cache_test { id } }`))
+	second, _ := json.Marshal(engine.Query(ctx, `{cache_test{   id }}`))
+	assert.Equal(t, `{"data":{"cache_test":[{"id":1152921504606846976}]}}`, string(first))
+	assert.Equal(t, string(first), string(second))
+	assert.Len(t, dataSource.queries, 1)
+
+	engine.Query(WithSession(ctx, NewSession("merchant", nil)), `{ cache_test { id } }`)
+	assert.Len(t, dataSource.queries, 2)
+
+	engine.Query(WithPrimaryRead(ctx), `{ cache_test { id } }`)
+	assert.Len(t, dataSource.queries, 3)
+
+	_, err := engine.Exec(ctx, "cache_test", &Statement{SQL: "DELETE FROM `cache_test`;"})
+	assert.Nil(t, err)
+	engine.Query(ctx, `{ cache_test { id } }`)
+	assert.Len(t, dataSource.queries, 5)
+}
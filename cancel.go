@@ -0,0 +1,295 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"io"
+	"log"
+	"reflect"
+	"strconv"
+	"sync"
+)
+
+// killConnector - Connector wrapping the driver so that statements are killed with KILL QUERY when
+// their context is done before they are. On cancellation the driver only closes its side of the
+// connection and the statement keeps running on the server.
+//
+// The id of every connection is looked up once when it is opened, which lets prepared statements
+// of the pool be killed as well as plain queries without pinning a connection per query.
+type killConnector struct {
+	driver.Connector
+	// db - pool KILL QUERY is sent through, set once the pool is opened
+	db *sql.DB
+}
+
+func newKillConnector(connector driver.Connector) *killConnector {
+	return &killConnector{Connector: connector}
+}
+
+func (k *killConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	conn, err := k.Connector.Connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	id, err := connectionID(ctx, conn)
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+
+	return &killConn{Conn: conn, id: id, connector: k}, nil
+}
+
+func (k *killConnector) kill(connectionID uint64) {
+	ctx, cancel := context.WithTimeout(context.Background(), killTimeout)
+	defer cancel()
+
+	if _, err := k.db.ExecContext(ctx, fmt.Sprintf("KILL QUERY %d", connectionID)); err != nil {
+		log.Printf("failed to kill query on connection %d, error: %v", connectionID, err)
+	}
+}
+
+func connectionID(ctx context.Context, conn driver.Conn) (uint64, error) {
+	queryer, ok := conn.(driver.QueryerContext)
+	if !ok {
+		return 0, fmt.Errorf("driver connection %T can not query", conn)
+	}
+
+	rows, err := queryer.QueryContext(ctx, "SELECT CONNECTION_ID()", nil)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	dest := make([]driver.Value, 1)
+	if err := rows.Next(dest); err != nil {
+		return 0, err
+	}
+
+	switch id := dest[0].(type) {
+	case int64:
+		return uint64(id), nil
+	case []byte:
+		return strconv.ParseUint(string(id), 10, 64)
+	}
+
+	return 0, fmt.Errorf("unexpected connection id %v", dest[0])
+}
+
+// killConn - Driver connection killing its statements on cancellation, see killConnector
+type killConn struct {
+	driver.Conn
+	id        uint64
+	connector *killConnector
+}
+
+// watch - Kill the statement running on c once ctx is done, until the returned stop is called
+func (c *killConn) watch(ctx context.Context) func() {
+	if ctx.Done() == nil {
+		return func() {}
+	}
+
+	var (
+		mu       sync.Mutex
+		finished bool
+		finish   = make(chan struct{})
+	)
+	go func() {
+		select {
+		case <-ctx.Done():
+			// Holding the lock keeps the connection from going back to the pool before the kill,
+			// otherwise the kill could hit the next statement run on it
+			mu.Lock()
+			defer mu.Unlock()
+			if !finished {
+				c.connector.kill(c.id)
+			}
+		case <-finish:
+		}
+	}()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			mu.Lock()
+			finished = true
+			mu.Unlock()
+			close(finish)
+		})
+	}
+}
+
+func (c *killConn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	var (
+		stmt driver.Stmt
+		err  error
+	)
+	if preparer, ok := c.Conn.(driver.ConnPrepareContext); ok {
+		stmt, err = preparer.PrepareContext(ctx, query)
+	} else {
+		stmt, err = c.Conn.Prepare(query)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &killStmt{Stmt: stmt, conn: c}, nil
+}
+
+func (c *killConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	queryer, ok := c.Conn.(driver.QueryerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+
+	stop := c.watch(ctx)
+	rows, err := queryer.QueryContext(ctx, query, args)
+	if err != nil {
+		stop()
+		return nil, err
+	}
+
+	return &killRows{Rows: rows, stop: stop}, nil
+}
+
+func (c *killConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	execer, ok := c.Conn.(driver.ExecerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+
+	stop := c.watch(ctx)
+	defer stop()
+
+	return execer.ExecContext(ctx, query, args)
+}
+
+func (c *killConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	if beginner, ok := c.Conn.(driver.ConnBeginTx); ok {
+		return beginner.BeginTx(ctx, opts)
+	}
+
+	return c.Conn.Begin()
+}
+
+func (c *killConn) Ping(ctx context.Context) error {
+	if pinger, ok := c.Conn.(driver.Pinger); ok {
+		return pinger.Ping(ctx)
+	}
+
+	return nil
+}
+
+func (c *killConn) ResetSession(ctx context.Context) error {
+	if resetter, ok := c.Conn.(driver.SessionResetter); ok {
+		return resetter.ResetSession(ctx)
+	}
+
+	return nil
+}
+
+func (c *killConn) CheckNamedValue(value *driver.NamedValue) error {
+	if checker, ok := c.Conn.(driver.NamedValueChecker); ok {
+		return checker.CheckNamedValue(value)
+	}
+
+	return driver.ErrSkip
+}
+
+// killStmt - Prepared statement killed on cancellation like the queries of its connection
+type killStmt struct {
+	driver.Stmt
+	conn *killConn
+}
+
+func (s *killStmt) QueryContext(ctx context.Context, args []driver.NamedValue) (driver.Rows, error) {
+	queryer, ok := s.Stmt.(driver.StmtQueryContext)
+	if !ok {
+		return nil, fmt.Errorf("driver statement %T can not query with context", s.Stmt)
+	}
+
+	stop := s.conn.watch(ctx)
+	rows, err := queryer.QueryContext(ctx, args)
+	if err != nil {
+		stop()
+		return nil, err
+	}
+
+	return &killRows{Rows: rows, stop: stop}, nil
+}
+
+func (s *killStmt) ExecContext(ctx context.Context, args []driver.NamedValue) (driver.Result, error) {
+	execer, ok := s.Stmt.(driver.StmtExecContext)
+	if !ok {
+		return nil, fmt.Errorf("driver statement %T can not exec with context", s.Stmt)
+	}
+
+	stop := s.conn.watch(ctx)
+	defer stop()
+
+	return execer.ExecContext(ctx, args)
+}
+
+// killRows - Rows of a statement, the statement is only done once they are closed
+type killRows struct {
+	driver.Rows
+	stop func()
+}
+
+func (r *killRows) Close() error {
+	err := r.Rows.Close()
+	r.stop()
+
+	return err
+}
+
+func (r *killRows) ColumnTypeDatabaseTypeName(index int) string {
+	if rows, ok := r.Rows.(driver.RowsColumnTypeDatabaseTypeName); ok {
+		return rows.ColumnTypeDatabaseTypeName(index)
+	}
+
+	return ""
+}
+
+func (r *killRows) ColumnTypeScanType(index int) reflect.Type {
+	if rows, ok := r.Rows.(driver.RowsColumnTypeScanType); ok {
+		return rows.ColumnTypeScanType(index)
+	}
+
+	return reflect.TypeOf(new(interface{})).Elem()
+}
+
+func (r *killRows) ColumnTypeNullable(index int) (nullable, ok bool) {
+	if rows, ok := r.Rows.(driver.RowsColumnTypeNullable); ok {
+		return rows.ColumnTypeNullable(index)
+	}
+
+	return false, false
+}
+
+func (r *killRows) ColumnTypePrecisionScale(index int) (precision, scale int64, ok bool) {
+	if rows, ok := r.Rows.(driver.RowsColumnTypePrecisionScale); ok {
+		return rows.ColumnTypePrecisionScale(index)
+	}
+
+	return 0, 0, false
+}
+
+func (r *killRows) HasNextResultSet() bool {
+	if rows, ok := r.Rows.(driver.RowsNextResultSet); ok {
+		return rows.HasNextResultSet()
+	}
+
+	return false
+}
+
+func (r *killRows) NextResultSet() error {
+	if rows, ok := r.Rows.(driver.RowsNextResultSet); ok {
+		return rows.NextResultSet()
+	}
+
+	return io.EOF
+}
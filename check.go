@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+)
+
+// CheckEntities - Problems of the entity configuration against the tables of the data sources:
+// entities without a table or data source, columns whose fields get the same name, and filters,
+// masks, row filters, change columns, default orders, renames and relations naming columns or
+// entities which do not exist, as well as computed fields reading unknown columns, calling unknown
+// resolvers or named like a column or its field, in alphabetical order
+func (e *Engine) CheckEntities(ctx context.Context) []error {
+	entities := make([]string, 0, len(Entities))
+	for entity := range Entities {
+		entities = append(entities, entity)
+	}
+	sort.Strings(entities)
+
+	schemas := map[string]TableSchema{}
+	var problems []error
+	for _, entity := range entities {
+		dataSource, err := e.DataSource(entity)
+		if err != nil {
+			problems = append(problems, err)
+			continue
+		}
+
+		tableSchema, err := dataSource.GetTableSchema(ctx, Entities.GetTableName(entity))
+		if err != nil {
+			problems = append(problems, fmt.Errorf("%s: table %q can not be read: %w", entity, Entities.GetTableName(entity), err))
+			continue
+		}
+		schemas[entity] = tableSchema
+	}
+
+	for _, entity := range entities {
+		tableSchema, ok := schemas[entity]
+		if !ok {
+			continue
+		}
+
+		checkColumn := func(column, usage string) {
+			if _, ok := tableSchema[column]; !ok {
+				problems = append(problems, fmt.Errorf("%s: %s %q is not a column of %q", entity, usage, column, Entities.GetTableName(entity)))
+			}
+		}
+
+		// Expression fields are filtered on and ordered by like columns
+		expressions := Entities.GetExpressions(entity)
+		checkArgument := func(name, usage string) {
+			if _, ok := expressions[name]; !ok {
+				checkColumn(name, usage)
+			}
+		}
+
+		for _, column := range Entities.GetAllowedFilters(entity) {
+			checkArgument(column, "allowed filter")
+		}
+		for column := range Entities.GetColumnMasks(entity) {
+			checkColumn(column, "masked column")
+		}
+		if column := Entities.GetChangeColumn(entity); column != "" {
+			checkColumn(column, "change column")
+		}
+		for role, filter := range Entities[entity].RowFilters {
+			for column := range filter {
+				checkColumn(column, fmt.Sprintf("row filter of role %q on", role))
+			}
+		}
+		for _, column := range Entities.GetHiddenColumns(entity) {
+			checkColumn(column, "hidden column")
+		}
+		for column := range Entities[entity].ColumnNames {
+			checkColumn(column, "renamed column")
+		}
+		// Of columns whose fields get the same name only one can be queried
+		fields := fieldColumns(entity, tableSchema)
+		for column := range tableSchema {
+			if name := Entities.GetFieldName(entity, column); fields[name] != column {
+				problems = append(problems, fmt.Errorf("%s: column %q has no field, its name %q is the one of column %q", entity, column, name, fields[name]))
+			}
+		}
+		for _, criteria := range Entities.GetDefaultOrder(entity) {
+			for column := range criteria {
+				checkArgument(column, "default order")
+			}
+		}
+
+		for name, field := range Entities.GetComputedFields(entity) {
+			if _, ok := tableSchema[name]; ok {
+				problems = append(problems, fmt.Errorf("%s: computed field %q is named like a column of %q", entity, name, Entities.GetTableName(entity)))
+			} else if _, ok := fields[name]; ok {
+				problems = append(problems, fmt.Errorf("%s: computed field %q is named like the field of column %q", entity, name, fields[name]))
+			}
+			if _, ok := registeredResolvers[field.Resolver]; field.Resolver != "" && !ok {
+				problems = append(problems, fmt.Errorf("%s: computed field %q has no resolver %q", entity, name, field.Resolver))
+				continue
+			}
+			for _, column := range field.Columns() {
+				checkColumn(column, fmt.Sprintf("computed field %q reads", name))
+			}
+		}
+
+		for name, relation := range Entities.GetRelations(entity) {
+			checkColumn(relation.Column, fmt.Sprintf("relation %q joins on", name))
+
+			relatedSchema, ok := schemas[relation.Entity]
+			if !ok {
+				if _, configured := Entities[relation.Entity]; !configured {
+					problems = append(problems, fmt.Errorf("%s: relation %q relates unknown entity %q", entity, name, relation.Entity))
+				}
+				continue
+			}
+			if _, ok := relatedSchema[relation.RelatedColumn]; !ok {
+				problems = append(problems, fmt.Errorf("%s: relation %q joins on %q which is not a column of %q",
+					entity, name, relation.RelatedColumn, Entities.GetTableName(relation.Entity)))
+			}
+		}
+	}
+
+	sort.Slice(problems, func(i, j int) bool { return problems[i].Error() < problems[j].Error() })
+
+	return problems
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEngine_CheckEntities(t *testing.T) {
+	entities := Entities
+	Entities = EntityConfigs{
+		"check_payments": {
+			TableName:      "check_payments",
+			AllowedFilters: []string{"id", "amount", "is_recent"},
+			ChangeColumn:   "updated_at",
+			DefaultOrder:   []map[string]interface{}{{"created_at": "desc"}},
+			Naming:         NamingConfig{Fields: NamingCamel},
+			ColumnNames:    map[string]string{"updated_at": "merchantId", "deleted_at": "deletedAt"},
+			ColumnMasks: map[string]map[string]MaskRule{
+				"card_number": {AnyRole: {Strategy: MaskRedact}},
+			},
+			RowFilters: map[string]map[string]interface{}{
+				"merchant": {"merchant_id": map[string]interface{}{"_eq": "X-Merchant-Id"}},
+			},
+			Relations: map[string]Relation{
+				"merchant": {Entity: "check_merchants", Column: "merchant_id", RelatedColumn: "uuid"},
+				"refunds":  {Entity: "check_refunds", Column: "id", RelatedColumn: "payment_id", Many: true},
+			},
+			ComputedFields: map[string]ComputedField{
+				"is_recent":  {Expression: "{updated_at} > NOW() - INTERVAL 1 DAY", Type: "tinyint(1)"},
+				"total":      {Expression: "{amount} + {fee}"},
+				"refundable": {Resolver: "check_payments.refundable"},
+				"id":         {Expression: "{id} * 2"},
+				"merchantId": {Expression: "{merchant_id} + 1"},
+			},
+		},
+		"check_merchants": {TableName: "check_merchants"},
+		"check_ledger":    {TableName: "check_ledger", DataSource: "ledger"},
+	}
+	defer func() { Entities = entities }()
+
+	engine := NewEngine(map[string]DataSource{DefaultDataSource: &fakeDataSource{
+		schema: TableSchema{"id": "int", "merchant_id": "int", "updated_at": "datetime"},
+	}})
+
+	var problems []string
+	for _, problem := range engine.CheckEntities(context.Background()) {
+		problems = append(problems, problem.Error())
+	}
+	assert.Equal(t, []string{
+		`check_payments: allowed filter "amount" is not a column of "check_payments"`,
+		`check_payments: column "updated_at" has no field, its name "merchantId" is the one of column "merchant_id"`,
+		`check_payments: computed field "id" is named like a column of "check_payments"`,
+		`check_payments: computed field "merchantId" is named like the field of column "merchant_id"`,
+		`check_payments: computed field "refundable" has no resolver "check_payments.refundable"`,
+		`check_payments: computed field "total" reads "amount" is not a column of "check_payments"`,
+		`check_payments: computed field "total" reads "fee" is not a column of "check_payments"`,
+		`check_payments: default order "created_at" is not a column of "check_payments"`,
+		`check_payments: masked column "card_number" is not a column of "check_payments"`,
+		`check_payments: relation "merchant" joins on "uuid" which is not a column of "check_merchants"`,
+		`check_payments: relation "refunds" relates unknown entity "check_refunds"`,
+		`check_payments: renamed column "deleted_at" is not a column of "check_payments"`,
+		`no data source "ledger" for entity "check_ledger"`,
+	}, problems)
+}
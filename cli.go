@@ -0,0 +1,360 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+)
+
+const usage = `Usage: graphql-query-engine [command] [flags]
+
+Commands:
+  serve         Serve the GraphQL API, the default command
+  introspect    Print the tables of the database and their columns as JSON
+  sdl           Print the GraphQL schema a role sees
+  explain       Print the SQL a query runs and its MySQL execution plan
+  check-config  Check the entity configuration against the database
+
+Run graphql-query-engine <command> -h for the flags of a command.
+`
+
+// command - subcommand of the CLI, run with the arguments after its name
+type command struct {
+	name string
+	run  func(ctx context.Context, args []string, stdout io.Writer) error
+}
+
+var commands = []command{
+	{"serve", serveCommand},
+	{"introspect", introspectCommand},
+	{"sdl", sdlCommand},
+	{"explain", explainCommand},
+	{"check-config", checkConfigCommand},
+}
+
+// errProblems - command found problems it already reported, exits with status 1 without a message
+var errProblems = errors.New("problems found")
+
+// Run - Run the command named by the first of args, serve if args start with a flag or are empty.
+// Returns the exit status.
+func Run(ctx context.Context, args []string, stdout, stderr io.Writer) int {
+	name := "serve"
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		name, args = args[0], args[1:]
+	}
+	if name == "help" {
+		fmt.Fprint(stdout, usage)
+		return 0
+	}
+
+	for _, command := range commands {
+		if command.name != name {
+			continue
+		}
+
+		err := command.run(ctx, args, stdout)
+		switch {
+		case err == nil:
+			return 0
+		case errors.Is(err, flag.ErrHelp):
+			return 0
+		case errors.Is(err, errProblems):
+			return 1
+		}
+		fmt.Fprintf(stderr, "%s: %v\n", name, err)
+		return 1
+	}
+
+	fmt.Fprintf(stderr, "unknown command %q\n\n%s", name, usage)
+	return 2
+}
+
+func newFlagSet(name, args string) *flag.FlagSet {
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: graphql-query-engine %s [flags]%s\n\nFlags:\n", name, args)
+		flags.PrintDefaults()
+	}
+
+	return flags
+}
+
+// openEngine - Engine on the databases of config, serving the entities of its entities file. The
+// database of DefaultDataSource is returned with it. Entities living in a data source config does
+// not have are an error before any pool is opened.
+func openEngine(config Config) (*MySql, *Engine, error) {
+	if config.EntitiesFile != "" {
+		entities, limits, err := LoadEntities(config.EntitiesFile)
+		if err != nil {
+			return nil, nil, err
+		}
+		Entities, RoleLimits = entities, limits
+	}
+
+	if _, ok := config.DataSources[DefaultDataSource]; ok {
+		return nil, nil, fmt.Errorf("data source %q is the database, it can not be configured as a data source", DefaultDataSource)
+	}
+	entities := make([]string, 0, len(Entities))
+	for entity := range Entities {
+		entities = append(entities, entity)
+	}
+	sort.Strings(entities)
+	for _, entity := range entities {
+		name := Entities.GetDataSource(entity)
+		if _, ok := config.DataSources[name]; !ok && name != DefaultDataSource {
+			return nil, nil, fmt.Errorf("entity %q lives in data source %q which is not configured", entity, name)
+		}
+	}
+
+	db, err := NewMySql(config.Database)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	dataSources := map[string]DataSource{DefaultDataSource: db}
+	for name, dataSourceConfig := range config.DataSources {
+		dataSource, err := NewMySql(dataSourceConfig)
+		if err != nil {
+			_ = NewEngine(dataSources).Close()
+			return nil, nil, fmt.Errorf("data source %q: %w", name, err)
+		}
+		dataSources[name] = dataSource
+	}
+
+	return db, NewEngine(dataSources), nil
+}
+
+func serveCommand(ctx context.Context, args []string, stdout io.Writer) error {
+	config, err := ParseConfig(newFlagSet("serve", ""), args, os.Getenv, serveFlags)
+	if err != nil {
+		return err
+	}
+
+	return Serve(ctx, config)
+}
+
+// introspectCommand - Print the tables of the database with their columns in order and primary key
+func introspectCommand(ctx context.Context, args []string, stdout io.Writer) error {
+	config, err := ParseConfig(newFlagSet("introspect", ""), args, os.Getenv, nil)
+	if err != nil {
+		return err
+	}
+
+	db, engine, err := openEngine(config)
+	if err != nil {
+		return err
+	}
+	defer engine.Close()
+
+	tables, err := db.Tables(ctx)
+	if err != nil {
+		return err
+	}
+
+	type column struct {
+		Name string `json:"name"`
+		Type string `json:"type"`
+	}
+	type table struct {
+		Name       string   `json:"name"`
+		Columns    []column `json:"columns"`
+		PrimaryKey []string `json:"primary_key"`
+	}
+
+	model := struct {
+		Database string  `json:"database"`
+		Tables   []table `json:"tables"`
+	}{Database: config.Database.Name, Tables: []table{}}
+	for _, name := range tables {
+		names, err := db.GetColumnNames(ctx, name)
+		if err != nil {
+			return err
+		}
+		schema, err := db.GetTableSchema(ctx, name)
+		if err != nil {
+			return err
+		}
+		primaryKey, err := db.GetPrimaryKey(ctx, name)
+		if err != nil {
+			return err
+		}
+
+		columns := make([]column, 0, len(names))
+		for _, name := range names {
+			columns = append(columns, column{Name: name, Type: schema[name]})
+		}
+		model.Tables = append(model.Tables, table{Name: name, Columns: columns, PrimaryKey: primaryKey})
+	}
+
+	encoder := json.NewEncoder(stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(model)
+}
+
+// sessionFlags - Flags of the session a command acts as
+func sessionFlags(flags *flag.FlagSet) func() *Session {
+	role := flags.String("role", DefaultRole, "role to act as")
+	variables := sessionVariables{}
+	flags.Var(variables, "var", "session variable as name=value, may be repeated")
+
+	return func() *Session {
+		return NewSession(*role, variables)
+	}
+}
+
+type sessionVariables map[string]string
+
+func (v sessionVariables) String() string {
+	pairs := make([]string, 0, len(v))
+	for name, value := range v {
+		pairs = append(pairs, name+"="+value)
+	}
+	sort.Strings(pairs)
+
+	return strings.Join(pairs, ",")
+}
+
+func (v sessionVariables) Set(pair string) error {
+	parts := strings.SplitN(pair, "=", 2)
+	if len(parts) != 2 {
+		return fmt.Errorf("session variable %q is not name=value", pair)
+	}
+	v[parts[0]] = parts[1]
+
+	return nil
+}
+
+// sdlCommand - Print the schema the role sees
+func sdlCommand(ctx context.Context, args []string, stdout io.Writer) error {
+	flags := newFlagSet("sdl", "")
+	var session func() *Session
+	config, err := ParseConfig(flags, args, os.Getenv, func(flags *flag.FlagSet, _ *Config) {
+		session = sessionFlags(flags)
+	})
+	if err != nil {
+		return err
+	}
+
+	_, engine, err := openEngine(config)
+	if err != nil {
+		return err
+	}
+	defer engine.Close()
+
+	schema, err := engine.APISchema(WithSession(ctx, session()))
+	if err != nil {
+		return err
+	}
+
+	_, err = io.WriteString(stdout, PrintSchema(schema))
+	return err
+}
+
+// explainCommand - Print the statements a query runs and the execution plan of each
+func explainCommand(ctx context.Context, args []string, stdout io.Writer) error {
+	flags := newFlagSet("explain", " <query | - for stdin>")
+	var (
+		session   func() *Session
+		variables *string
+	)
+	config, err := ParseConfig(flags, args, os.Getenv, func(flags *flag.FlagSet, _ *Config) {
+		session = sessionFlags(flags)
+		variables = flags.String("variables", "", "variables of the query as a JSON object")
+	})
+	if err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		return errors.New("expected a single query, see -h")
+	}
+
+	request := &GraphQLRequest{Query: flags.Arg(0)}
+	if request.Query == "-" {
+		query, err := ioutil.ReadAll(os.Stdin)
+		if err != nil {
+			return err
+		}
+		request.Query = string(query)
+	}
+	if *variables != "" {
+		if err := json.Unmarshal([]byte(*variables), &request.Variables); err != nil {
+			return fmt.Errorf("variables: %w", err)
+		}
+	}
+
+	db, engine, err := openEngine(config)
+	if err != nil {
+		return err
+	}
+	defer engine.Close()
+
+	statements, err := engine.Explain(WithSession(ctx, session()), request)
+	if err != nil {
+		return err
+	}
+
+	for idx, statement := range statements {
+		if idx > 0 {
+			fmt.Fprintln(stdout)
+		}
+		fmt.Fprintln(stdout, statement.SQL)
+		if len(statement.Args) > 0 {
+			args, _ := json.Marshal(statement.Args)
+			fmt.Fprintf(stdout, "-- args: %s\n", args)
+		}
+
+		columns, plan, err := db.Explain(ctx, statement)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(stdout)
+		table := tabwriter.NewWriter(stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(table, strings.Join(columns, "\t"))
+		for _, row := range plan {
+			fmt.Fprintln(table, strings.Join(row, "\t"))
+		}
+		if err := table.Flush(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// checkConfigCommand - Print the problems of the entity configuration, fails if there are any
+func checkConfigCommand(ctx context.Context, args []string, stdout io.Writer) error {
+	config, err := ParseConfig(newFlagSet("check-config", ""), args, os.Getenv, nil)
+	if err != nil {
+		return err
+	}
+
+	_, engine, err := openEngine(config)
+	if err != nil {
+		return err
+	}
+	defer engine.Close()
+
+	return reportProblems(stdout, engine.CheckEntities(ctx))
+}
+
+func reportProblems(stdout io.Writer, problems []error) error {
+	if len(problems) == 0 {
+		fmt.Fprintln(stdout, "entity configuration is valid")
+		return nil
+	}
+
+	for _, problem := range problems {
+		fmt.Fprintln(stdout, problem)
+	}
+	fmt.Fprintf(stdout, "problems found: %d\n", len(problems))
+
+	return errProblems
+}
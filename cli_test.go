@@ -0,0 +1,58 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRun(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+
+	assert.Equal(t, 0, Run(context.Background(), []string{"help"}, &stdout, &stderr))
+	assert.Contains(t, stdout.String(), "check-config")
+
+	assert.Equal(t, 2, Run(context.Background(), []string{"unknown"}, &stdout, &stderr))
+	assert.Contains(t, stderr.String(), `unknown command "unknown"`)
+
+	stderr.Reset()
+	assert.Equal(t, 1, Run(context.Background(), []string{"explain", "-db-socket", "/nonexistent"}, &stdout, &stderr))
+	assert.Contains(t, stderr.String(), "explain: expected a single query")
+}
+
+func TestOpenEngine_DataSources(t *testing.T) {
+	entities, limits := Entities, RoleLimits
+	defer func() { Entities, RoleLimits = entities, limits }()
+
+	dir, err := ioutil.TempDir("", "entities")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "entities.yaml")
+	assert.Nil(t, ioutil.WriteFile(path, []byte("entities:\n  payments: {}\n  reports: {data_source: reporting}\n"), 0o600))
+
+	config := DefaultConfig()
+	config.Database.Socket = "/nonexistent"
+	config.EntitiesFile = path
+
+	// The check fails before any database is asked for the tables
+	var stdout, stderr bytes.Buffer
+	assert.Equal(t, 1, Run(context.Background(), []string{"check-config", "-db-socket", "/nonexistent", "-entities", path}, &stdout, &stderr))
+	assert.Equal(t, "check-config: entity \"reports\" lives in data source \"reporting\" which is not configured\n", stderr.String())
+
+	config.DataSources = map[string]SqlConfig{"reporting": {Socket: "/nonexistent/reporting"}}
+	db, engine, err := openEngine(config)
+	assert.Nil(t, err)
+	defer engine.Close()
+
+	payments, err := engine.DataSource("payments")
+	assert.Nil(t, err)
+	assert.Same(t, db, payments)
+	reports, err := engine.DataSource("reports")
+	assert.Nil(t, err)
+	assert.NotSame(t, db, reports)
+}
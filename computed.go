@@ -0,0 +1,241 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/graphql-go/graphql"
+)
+
+// DefaultComputedType - column type of computed fields without a type
+const DefaultComputedType = "varchar"
+
+// ComputedField - field of an entity derived from its columns, either by a sql expression or by a
+// Go function registered with RegisterResolver, eg
+//
+//	ComputedFields: map[string]ComputedField{
+//		"amount_in_dollars": {Expression: "{amount} / 100", Type: "decimal"},
+//		"is_refundable":     {Resolver: "payments.is_refundable", Type: "tinyint(1)"},
+//	}
+//
+// Expression fields are computed by the database and can be filtered on, when the allowed filters
+// of the entity allow it, and ordered by. Neither is possible for fields with a resolver.
+type ComputedField struct {
+	// Expression - sql expression with the columns it reads in braces, eg {amount} / 100
+	Expression string `yaml:"expression"`
+	// Resolver - name the Go function computing the field is registered with
+	Resolver string `yaml:"resolver"`
+	// Type - column type of the value, eg decimal or tinyint(1) for booleans, DefaultComputedType
+	// if empty
+	Type string `yaml:"type"`
+}
+
+// ComputedResolver - Go function computing a field from the row of its entity. The columns it
+// depends on are fetched whenever the field is selected, they are masked as for the caller.
+type ComputedResolver struct {
+	DependsOn []string
+	Resolve   func(row map[string]interface{}) (interface{}, error)
+}
+
+// registeredResolvers - Go functions computing fields by the name they are registered with
+var registeredResolvers = map[string]ComputedResolver{}
+
+// RegisterResolver - Register the function computing the fields with the resolver name, resolvers
+// are registered on start up before the entities are loaded
+func RegisterResolver(name string, resolver ComputedResolver) {
+	registeredResolvers[name] = resolver
+}
+
+// columnReference - column of an expression template, eg {amount}
+var columnReference = regexp.MustCompile(`\{([A-Za-z0-9_$]+)\}`)
+
+// SQL - Sql of the expression with the columns it reads quoted
+func (f ComputedField) SQL() string {
+	return columnReference.ReplaceAllStringFunc(f.Expression, func(reference string) string {
+		return "`" + strings.Trim(reference, "{}") + "`"
+	})
+}
+
+// Columns - Columns the field is computed from, the ones of its expression or the ones its resolver
+// depends on
+func (f ComputedField) Columns() []string {
+	if f.Resolver != "" {
+		return registeredResolvers[f.Resolver].DependsOn
+	}
+
+	var columns []string
+	for _, match := range columnReference.FindAllStringSubmatch(f.Expression, -1) {
+		if !contains(columns, match[1]) {
+			columns = append(columns, match[1])
+		}
+	}
+
+	return columns
+}
+
+// ColumnType - Column type of the value of the field
+func (f ComputedField) ColumnType() string {
+	if f.Type == "" {
+		return DefaultComputedType
+	}
+
+	return f.Type
+}
+
+// GetComputedFields - Computed fields of entity by their name
+func (e EntityConfigs) GetComputedFields(entity string) map[string]ComputedField {
+	return e[entity].ComputedFields
+}
+
+// configuredColumns - Columns of entity its configuration names outside of the arguments clients
+// send, which computed fields must not be named like: renamed, hidden and masked columns, the
+// columns of row filters, of the change column, of relations from and to entity and the ones
+// computed fields read
+func (e EntityConfigs) configuredColumns(entity string) map[string]bool {
+	config := e[entity]
+	columns := map[string]bool{}
+	for column := range config.ColumnNames {
+		columns[column] = true
+	}
+	for _, column := range config.HiddenColumns {
+		columns[column] = true
+	}
+	for column := range config.ColumnMasks {
+		columns[column] = true
+	}
+	for _, filter := range config.RowFilters {
+		for column := range filter {
+			columns[column] = true
+		}
+	}
+	if config.ChangeColumn != "" {
+		columns[config.ChangeColumn] = true
+	}
+	for _, relation := range config.Relations {
+		columns[relation.Column] = true
+	}
+	for _, other := range e {
+		for _, relation := range other.Relations {
+			if relation.Entity == entity {
+				columns[relation.RelatedColumn] = true
+			}
+		}
+	}
+	for _, field := range config.ComputedFields {
+		for _, column := range field.Columns() {
+			columns[column] = true
+		}
+	}
+	delete(columns, "")
+
+	return columns
+}
+
+// computedColumnError - Error of the computed fields of entity named like a column of tableSchema,
+// their expressions would replace the column wherever the engine reads it
+func computedColumnError(entity string, tableSchema TableSchema) error {
+	var names []string
+	for name := range Entities.GetComputedFields(entity) {
+		if _, ok := tableSchema[name]; ok {
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		return nil
+	}
+	sort.Strings(names)
+
+	return fmt.Errorf("computed fields %q of entity %q are named like columns of %q", names, entity, Entities.GetTableName(entity))
+}
+
+// GetExpressions - Sql of the computed fields of entity with an expression by their name
+func (e EntityConfigs) GetExpressions(entity string) map[string]string {
+	var expressions map[string]string
+	for name, field := range e[entity].ComputedFields {
+		if field.Expression == "" {
+			continue
+		}
+		if expressions == nil {
+			expressions = map[string]string{}
+		}
+		expressions[name] = field.SQL()
+	}
+
+	return expressions
+}
+
+// computedVisible - Whether the computed field can be used by role, fields reading masked columns
+// would give their values away unless they are computed from the masked values in Go
+func computedVisible(entity string, field ComputedField, role string) bool {
+	if field.Resolver != "" {
+		return true
+	}
+	for _, column := range field.Columns() {
+		if IsMasked(entity, column, role) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// computedResolver - Resolve the computed field name of entity, from the value the database
+// computed or by calling its Go function with the row
+func computedResolver(entity, name string) graphql.FieldResolveFn {
+	return func(params graphql.ResolveParams) (interface{}, error) {
+		row, _ := params.Source.(map[string]interface{})
+		return computedValue(entity, name, row)
+	}
+}
+
+// computedValue - Value of the computed field name of entity for row
+func computedValue(entity, name string, row map[string]interface{}) (interface{}, error) {
+	field := Entities.GetComputedFields(entity)[name]
+	if field.Resolver == "" {
+		return row[name], nil
+	}
+
+	resolver, ok := registeredResolvers[field.Resolver]
+	if !ok {
+		return nil, fmt.Errorf("no resolver %q for field %q of entity %q", field.Resolver, name, entity)
+	}
+
+	return resolver.Resolve(row)
+}
+
+// computedSchema - tableSchema with the types of the expression fields of entity, which are in the
+// rows fetched next to the columns
+func computedSchema(entity string, tableSchema TableSchema) TableSchema {
+	expressions := Entities.GetExpressions(entity)
+	if len(expressions) == 0 {
+		return tableSchema
+	}
+
+	schema := make(TableSchema, len(tableSchema)+len(expressions))
+	for column, columnType := range tableSchema {
+		schema[column] = columnType
+	}
+	for name := range expressions {
+		schema[name] = Entities.GetComputedFields(entity)[name].ColumnType()
+	}
+
+	return schema
+}
+
+// argumentTypes - Column types of the fields of entity the where and order_by arguments can name,
+// the fields of the columns and the expression fields which do not collide with them
+func argumentTypes(entity string, tableSchema TableSchema) TableSchema {
+	types := TableSchema{}
+	for name, field := range Entities.GetComputedFields(entity) {
+		if field.Expression != "" {
+			types[name] = field.ColumnType()
+		}
+	}
+	for name, column := range fieldColumns(entity, tableSchema) {
+		types[name] = tableSchema[column]
+	}
+
+	return types
+}
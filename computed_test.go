@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestComputedField_SQL(t *testing.T) {
+	field := ComputedField{Expression: "{amount} - {refunded_amount} > {amount} / 2"}
+	assert.Equal(t, "`amount` - `refunded_amount` > `amount` / 2", field.SQL())
+	assert.Equal(t, []string{"amount", "refunded_amount"}, field.Columns())
+	assert.Equal(t, DefaultComputedType, field.ColumnType())
+}
+
+func TestEngine_QueryComputedFields(t *testing.T) {
+	RegisterResolver("computed_test.refundable", ComputedResolver{
+		DependsOn: []string{"status", "amount"},
+		Resolve: func(row map[string]interface{}) (interface{}, error) {
+			return row["status"] == "paid" && row["amount"].(int64) > 0, nil
+		},
+	})
+	defer delete(registeredResolvers, "computed_test.refundable")
+
+	Entities["computed_payments"] = EntityConfig{
+		ComputedFields: map[string]ComputedField{
+			"amount_in_dollars": {Expression: "{amount} / 100", Type: "double"},
+			"card_prefix":       {Expression: "LEFT({card_number}, 6)"},
+			"refundable":        {Resolver: "computed_test.refundable", Type: "tinyint(1)"},
+		},
+		ColumnMasks: map[string]map[string]MaskRule{
+			"card_number": {AnyRole: {Strategy: MaskRedact}},
+		},
+	}
+	defer delete(Entities, "computed_payments")
+
+	dataSource := &fakeDataSource{
+		schema: TableSchema{"id": "int", "amount": "int", "status": "varchar", "card_number": "varchar"},
+		rows: []map[string]interface{}{
+			{"id": int64(1), "amount": int64(1250), "status": "paid", "amount_in_dollars": 12.5},
+		},
+	}
+	engine := NewEngine(map[string]DataSource{DefaultDataSource: dataSource})
+
+	// Expressions are computed by the database, in the projection, the filters and the order
+	result := engine.Query(context.Background(), `{
+		computed_payments(where: {amount_in_dollars: {_gt: 10}}, order_by: [{amount_in_dollars: "desc"}]) { id amount_in_dollars }
+	}`)
+	assert.Empty(t, result.Errors)
+	assert.Equal(t, map[string]interface{}{"computed_payments": []interface{}{
+		map[string]interface{}{"id": 1, "amount_in_dollars": 12.5},
+	}}, result.Data)
+	assert.Contains(t, dataSource.queries[0].SQL,
+		"`id`, (`amount` / 100) AS `amount_in_dollars` FROM `computed_payments` WHERE (`amount` / 100) > ? ORDER BY (`amount` / 100) desc")
+
+	// Resolvers get the row with the columns they depend on
+	result = engine.Query(context.Background(), `{ computed_payments { id refundable } }`)
+	assert.Empty(t, result.Errors)
+	assert.Equal(t, map[string]interface{}{"computed_payments": []interface{}{
+		map[string]interface{}{"id": 1, "refundable": true},
+	}}, result.Data)
+	assert.Contains(t, dataSource.queries[1].SQL, "`id`, `status`, `amount` FROM `computed_payments`")
+
+	// Expressions reading masked columns would give their values away
+	result = engine.Query(context.Background(), `{ computed_payments { card_prefix } }`)
+	if assert.Len(t, result.Errors, 1) {
+		assert.Equal(t, FieldNotProjectable, result.Errors[0].Extensions["code"])
+	}
+	result = engine.Query(context.Background(), `{ computed_payments(order_by: [{card_prefix: "asc"}]) { id } }`)
+	if assert.Len(t, result.Errors, 1) {
+		assert.Equal(t, ColumnNotSortable, result.Errors[0].Extensions["code"])
+	}
+	// Fields computed in Go can not be filtered on
+	result = engine.Query(context.Background(), `{ computed_payments(where: {refundable: {_eq: true}}) { id } }`)
+	if assert.Len(t, result.Errors, 1) {
+		assert.Equal(t, FilterNotAllowed, result.Errors[0].Extensions["code"])
+	}
+	assert.Len(t, dataSource.queries, 2)
+
+	// A field named like a column would replace it in the row filter, the joins and the projection
+	Entities["computed_payments"] = EntityConfig{ComputedFields: map[string]ComputedField{"status": {Expression: "'paid'"}}}
+	result = engine.Query(context.Background(), `{ computed_payments { id } }`)
+	if assert.Len(t, result.Errors, 1) {
+		assert.Equal(t, `computed fields ["status"] of entity "computed_payments" are named like columns of "computed_payments"`, result.Errors[0].Message)
+	}
+	assert.Len(t, dataSource.queries, 2)
+}
@@ -0,0 +1,331 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultListenAddr - address the server listens on unless configured
+const DefaultListenAddr = ":8080"
+
+// DefaultShutdownTimeout - time requests in flight get to finish once the server is stopped
+const DefaultShutdownTimeout = 10 * time.Second
+
+// Config - settings of the commands. They are read from the JSON file named by -config or
+// CONFIG_FILE, then from the environment and then from flags, later sources override earlier ones.
+// The database password is not taken from flags so that it does not show in the process list.
+type Config struct {
+	Listen string `json:"listen"`
+	// Database - keys are the field names of SqlConfig, eg {"host": "db", "port": 3306}, durations
+	// are in nanoseconds
+	Database SqlConfig `json:"database"`
+	// DataSources - databases of the entities living in a data source other than DefaultDataSource,
+	// by the name of the data source, each in the shape of Database, eg
+	// {"reporting": {"host": "reporting-db", "port": 3306, "username": "api", "name": "reports"}}
+	DataSources map[string]SqlConfig `json:"data_sources"`
+	// EntitiesFile - YAML or JSON file the entities are loaded from, see LoadEntities, the
+	// entities compiled in are served when it is empty
+	EntitiesFile string `json:"entities_file"`
+
+	// JWTSecret - HS256 secret of the tokens, JWKSFile is used for RS256 tokens when it is empty
+	JWTSecret string `json:"jwt_secret"`
+	JWKSFile  string `json:"jwks_file"`
+	// JWTIssuer, JWTAudience - iss and aud the tokens must carry, not checked when empty
+	JWTIssuer   string `json:"jwt_issuer"`
+	JWTAudience string `json:"jwt_audience"`
+	// JWTRoleClaim - dot separated path of the claim holding the role, role when empty
+	JWTRoleClaim string `json:"jwt_role_claim"`
+	// JWTDefaultRole - role of tokens without a role claim
+	JWTDefaultRole string `json:"jwt_default_role"`
+	// JWTVariables - session variables by the dot separated path of the claim they are read from,
+	// eg {"X-Merchant-Id": "merchant.id"}, row filters refer to them
+	JWTVariables map[string]string `json:"jwt_variables"`
+	// APIKeys - sessions granted to the static api keys sent in X-Api-Key, by key, eg
+	// {"secret": {"role": "admin", "variables": {"X-Merchant-Id": "m1"}}}
+	APIKeys map[string]APIKey `json:"api_keys"`
+	// AllowAnonymous - requests without credentials get the anonymous role instead of 401, by
+	// default only when neither tokens nor api keys are configured
+	AllowAnonymous *bool `json:"allow_anonymous"`
+
+	// AllowlistFile - only the queries of the allowlist run when set
+	AllowlistFile    string `json:"allowlist_file"`
+	MaxBatchSize     int    `json:"max_batch_size"`
+	BatchParallelism int    `json:"batch_parallelism"`
+
+	// BinlogServerID - subscriptions follow the binlog as a replica of this server id when set,
+	// otherwise they poll every PollInterval
+	BinlogServerID     uint32   `json:"binlog_server_id"`
+	BinlogPositionFile string   `json:"binlog_position_file"`
+	PollInterval       Duration `json:"poll_interval"`
+
+	ShutdownTimeout Duration `json:"shutdown_timeout"`
+}
+
+// authConfig - Authentication of the requests of serve. Tokens are HS256 with JWTSecret, RS256 with
+// JWKSFile otherwise, anonymous requests are rejected once tokens or api keys are configured unless
+// AllowAnonymous says otherwise.
+func (c Config) authConfig() AuthConfig {
+	config := AuthConfig{APIKeys: c.APIKeys}
+	jwt := JWTConfig{
+		Issuer:      c.JWTIssuer,
+		Audience:    c.JWTAudience,
+		RoleClaim:   c.JWTRoleClaim,
+		DefaultRole: c.JWTDefaultRole,
+		Variables:   c.JWTVariables,
+	}
+	if c.JWTSecret != "" {
+		jwt.Algorithm, jwt.Secret = HS256, c.JWTSecret
+		config.JWT = &jwt
+	} else if c.JWKSFile != "" {
+		jwt.Algorithm, jwt.JWKSFile = RS256, c.JWKSFile
+		config.JWT = &jwt
+	}
+
+	config.AllowAnonymous = config.JWT == nil && len(config.APIKeys) == 0
+	if c.AllowAnonymous != nil {
+		config.AllowAnonymous = *c.AllowAnonymous
+	}
+
+	return config
+}
+
+// Duration - time.Duration read from JSON as a string like "1s" or a number of nanoseconds
+type Duration time.Duration
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var value interface{}
+	if err := json.Unmarshal(data, &value); err != nil {
+		return err
+	}
+
+	switch value := value.(type) {
+	case float64:
+		*d = Duration(value)
+	case string:
+		parsed, err := time.ParseDuration(value)
+		if err != nil {
+			return err
+		}
+		*d = Duration(parsed)
+	default:
+		return fmt.Errorf("invalid duration %s", data)
+	}
+
+	return nil
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// DefaultConfig - Config before any source is read, a local development database
+func DefaultConfig() Config {
+	return Config{
+		Listen: DefaultListenAddr,
+		Database: SqlConfig{
+			Host:     "localhost",
+			Port:     23306,
+			Username: "user",
+			Password: "123",
+			Name:     "database",
+			Protocol: "tcp",
+		},
+		BinlogPositionFile: "binlog.position",
+		ShutdownTimeout:    Duration(DefaultShutdownTimeout),
+	}
+}
+
+// LoadConfigFile - Override the settings of config by the ones in the JSON file at path
+func LoadConfigFile(path string, config *Config) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	if err := json.Unmarshal(data, config); err != nil {
+		return fmt.Errorf("config file %s: %w", path, err)
+	}
+
+	return nil
+}
+
+// ApplyEnv - Override the settings of config by the environment variables getenv returns
+func ApplyEnv(config *Config, getenv func(string) string) error {
+	stringVars := map[string]*string{
+		"LISTEN_ADDR":          &config.Listen,
+		"MYSQL_HOST":           &config.Database.Host,
+		"MYSQL_USER":           &config.Database.Username,
+		"MYSQL_PASSWORD":       &config.Database.Password,
+		"MYSQL_DATABASE":       &config.Database.Name,
+		"MYSQL_SOCKET":         &config.Database.Socket,
+		"ENTITIES_FILE":        &config.EntitiesFile,
+		"JWT_SECRET":           &config.JWTSecret,
+		"JWT_JWKS_FILE":        &config.JWKSFile,
+		"JWT_ISSUER":           &config.JWTIssuer,
+		"JWT_AUDIENCE":         &config.JWTAudience,
+		"JWT_ROLE_CLAIM":       &config.JWTRoleClaim,
+		"JWT_DEFAULT_ROLE":     &config.JWTDefaultRole,
+		"QUERY_ALLOWLIST_FILE": &config.AllowlistFile,
+		"BINLOG_POSITION_FILE": &config.BinlogPositionFile,
+	}
+	for name, field := range stringVars {
+		if value := getenv(name); value != "" {
+			*field = value
+		}
+	}
+
+	intVars := map[string]*int{
+		"MYSQL_PORT":                &config.Database.Port,
+		"GRAPHQL_MAX_BATCH_SIZE":    &config.MaxBatchSize,
+		"GRAPHQL_BATCH_PARALLELISM": &config.BatchParallelism,
+	}
+	for name, field := range intVars {
+		if value := getenv(name); value != "" {
+			parsed, err := strconv.Atoi(value)
+			if err != nil {
+				return fmt.Errorf("%s: %w", name, err)
+			}
+			*field = parsed
+		}
+	}
+
+	// Maps are given as JSON, in the shape of the config file
+	jsonVars := map[string]interface{}{
+		"MYSQL_DATA_SOURCES": &config.DataSources,
+		"JWT_VARIABLES":      &config.JWTVariables,
+		"API_KEYS":           &config.APIKeys,
+	}
+	for name, field := range jsonVars {
+		if value := getenv(name); value != "" {
+			if err := json.Unmarshal([]byte(value), field); err != nil {
+				return fmt.Errorf("%s: %w", name, err)
+			}
+		}
+	}
+
+	if value := getenv("ALLOW_ANONYMOUS"); value != "" {
+		allow, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("ALLOW_ANONYMOUS: %w", err)
+		}
+		config.AllowAnonymous = &allow
+	}
+	if value := getenv("BINLOG_SERVER_ID"); value != "" {
+		serverID, err := strconv.ParseUint(value, 10, 32)
+		if err != nil {
+			return fmt.Errorf("BINLOG_SERVER_ID: %w", err)
+		}
+		config.BinlogServerID = uint32(serverID)
+	}
+	if value := getenv("SUBSCRIPTION_POLL_INTERVAL"); value != "" {
+		interval, err := time.ParseDuration(value)
+		if err != nil {
+			return fmt.Errorf("SUBSCRIPTION_POLL_INTERVAL: %w", err)
+		}
+		config.PollInterval = Duration(interval)
+	}
+
+	return nil
+}
+
+// databaseFlags - Flags of the database and entity settings, every command has them
+func databaseFlags(flags *flag.FlagSet, config *Config) {
+	flags.String("config", "", "JSON config file, CONFIG_FILE by default")
+	flags.StringVar(&config.Database.Host, "db-host", config.Database.Host, "MySQL host (MYSQL_HOST)")
+	flags.IntVar(&config.Database.Port, "db-port", config.Database.Port, "MySQL port (MYSQL_PORT)")
+	flags.StringVar(&config.Database.Socket, "db-socket", config.Database.Socket, "MySQL unix socket, instead of host and port (MYSQL_SOCKET)")
+	flags.StringVar(&config.Database.Username, "db-user", config.Database.Username, "MySQL user (MYSQL_USER), the password is read from MYSQL_PASSWORD")
+	flags.StringVar(&config.Database.Name, "db-name", config.Database.Name, "MySQL database (MYSQL_DATABASE)")
+	flags.StringVar(&config.EntitiesFile, "entities", config.EntitiesFile, "YAML or JSON file of the entities (ENTITIES_FILE)")
+}
+
+// serveFlags - Flags of the server settings
+func serveFlags(flags *flag.FlagSet, config *Config) {
+	flags.StringVar(&config.Listen, "listen", config.Listen, "address to listen on (LISTEN_ADDR)")
+	flags.StringVar(&config.AllowlistFile, "allowlist", config.AllowlistFile, "file of the only queries allowed to run (QUERY_ALLOWLIST_FILE)")
+	flags.IntVar(&config.MaxBatchSize, "max-batch-size", config.MaxBatchSize, "max requests of a batch (GRAPHQL_MAX_BATCH_SIZE)")
+	flags.IntVar(&config.BatchParallelism, "batch-parallelism", config.BatchParallelism, "requests of a batch run at once (GRAPHQL_BATCH_PARALLELISM)")
+	flags.Var(serverIDFlag{&config.BinlogServerID}, "binlog-server-id", "replica server id to follow the binlog with (BINLOG_SERVER_ID)")
+	flags.StringVar(&config.BinlogPositionFile, "binlog-position-file", config.BinlogPositionFile, "file the binlog position is saved in (BINLOG_POSITION_FILE)")
+	flags.DurationVar((*time.Duration)(&config.PollInterval), "poll-interval", time.Duration(config.PollInterval), "time between polls of subscriptions (SUBSCRIPTION_POLL_INTERVAL)")
+	flags.DurationVar((*time.Duration)(&config.ShutdownTimeout), "shutdown-timeout", time.Duration(config.ShutdownTimeout), "time requests get to finish on shutdown")
+}
+
+type serverIDFlag struct {
+	value *uint32
+}
+
+func (f serverIDFlag) String() string {
+	if f.value == nil || *f.value == 0 {
+		return ""
+	}
+
+	return strconv.FormatUint(uint64(*f.value), 10)
+}
+
+func (f serverIDFlag) Set(value string) error {
+	serverID, err := strconv.ParseUint(value, 10, 32)
+	if err != nil {
+		return err
+	}
+	*f.value = uint32(serverID)
+
+	return nil
+}
+
+// ParseConfig - Config of a command with its flags defined by define on flags, see Config for the
+// order the sources are read in
+func ParseConfig(flags *flag.FlagSet, args []string, getenv func(string) string, define func(*flag.FlagSet, *Config)) (Config, error) {
+	config := DefaultConfig()
+
+	path := configPath(args)
+	if path == "" {
+		path = getenv("CONFIG_FILE")
+	}
+	if path != "" {
+		if err := LoadConfigFile(path, &config); err != nil {
+			return config, err
+		}
+	}
+	if err := ApplyEnv(&config, getenv); err != nil {
+		return config, err
+	}
+
+	// Flags default to the settings read so far, so only flags given override them
+	databaseFlags(flags, &config)
+	if define != nil {
+		define(flags, &config)
+	}
+	if err := flags.Parse(args); err != nil {
+		return config, err
+	}
+
+	return config, nil
+}
+
+// configPath - Value of the config flag in args, which is needed before the other flags are parsed
+func configPath(args []string) string {
+	for idx, arg := range args {
+		if arg == "--" {
+			break
+		}
+
+		name := strings.TrimLeft(arg, "-")
+		if name == arg {
+			continue
+		}
+		if strings.HasPrefix(name, "config=") {
+			return strings.TrimPrefix(name, "config=")
+		}
+		if name == "config" && idx+1 < len(args) {
+			return args[idx+1]
+		}
+	}
+
+	return ""
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "config.json")
+	assert.Nil(t, ioutil.WriteFile(path, []byte(`{
+		"listen": ":9000",
+		"database": {"host": "db", "name": "shop", "password": "from-file"},
+		"max_batch_size": 5,
+		"poll_interval": "2s"
+	}`), 0o600))
+
+	env := map[string]string{
+		"CONFIG_FILE":        path,
+		"MYSQL_HOST":         "db.internal",
+		"MYSQL_PASSWORD":     "from-env",
+		"MYSQL_PORT":         "3307",
+		"MYSQL_DATA_SOURCES": `{"reporting": {"host": "reporting-db", "port": 3306, "name": "reports"}}`,
+	}
+	getenv := func(name string) string { return env[name] }
+
+	config, err := ParseConfig(flag.NewFlagSet("serve", flag.ContinueOnError), []string{"-db-host", "replica", "-max-batch-size=7"}, getenv, serveFlags)
+	assert.Nil(t, err)
+	assert.Equal(t, ":9000", config.Listen)
+	assert.Equal(t, "replica", config.Database.Host)
+	assert.Equal(t, 3307, config.Database.Port)
+	assert.Equal(t, "shop", config.Database.Name)
+	assert.Equal(t, "user", config.Database.Username)
+	assert.Equal(t, "from-env", config.Database.Password)
+	assert.Equal(t, 7, config.MaxBatchSize)
+	assert.Equal(t, Duration(2*time.Second), config.PollInterval)
+	assert.Equal(t, map[string]SqlConfig{"reporting": {Host: "reporting-db", Port: 3306, Name: "reports"}}, config.DataSources)
+
+	// The config flag names the file before the other flags are parsed
+	other := filepath.Join(dir, "other.json")
+	assert.Nil(t, ioutil.WriteFile(other, []byte(`{"listen": ":9001"}`), 0o600))
+	config, err = ParseConfig(flag.NewFlagSet("serve", flag.ContinueOnError), []string{"--config=" + other}, getenv, serveFlags)
+	assert.Nil(t, err)
+	assert.Equal(t, ":9001", config.Listen)
+
+	env["MYSQL_PORT"] = "x"
+	_, err = ParseConfig(flag.NewFlagSet("serve", flag.ContinueOnError), nil, getenv, serveFlags)
+	assert.NotNil(t, err)
+}
+
+func TestConfig_AuthConfig(t *testing.T) {
+	env := map[string]string{
+		"JWT_SECRET":     "secret",
+		"JWT_AUDIENCE":   "api",
+		"JWT_ROLE_CLAIM": "app.role",
+		"JWT_VARIABLES":  `{"X-Merchant-Id": "merchant.id"}`,
+		"API_KEYS":       `{"key": {"role": "admin"}}`,
+	}
+	getenv := func(name string) string { return env[name] }
+
+	config, err := ParseConfig(flag.NewFlagSet("serve", flag.ContinueOnError), nil, getenv, serveFlags)
+	assert.Nil(t, err)
+	auth := config.authConfig()
+	assert.Equal(t, &JWTConfig{
+		Algorithm: HS256,
+		Secret:    "secret",
+		Audience:  "api",
+		RoleClaim: "app.role",
+		Variables: map[string]string{"X-Merchant-Id": "merchant.id"},
+	}, auth.JWT)
+	assert.Equal(t, map[string]APIKey{"key": {Role: "admin"}}, auth.APIKeys)
+	assert.False(t, auth.AllowAnonymous)
+
+	env["ALLOW_ANONYMOUS"] = "true"
+	config, err = ParseConfig(flag.NewFlagSet("serve", flag.ContinueOnError), nil, getenv, serveFlags)
+	assert.Nil(t, err)
+	assert.True(t, config.authConfig().AllowAnonymous)
+
+	// Without credentials every request is anonymous
+	assert.True(t, Config{}.authConfig().AllowAnonymous)
+
+	env["API_KEYS"] = "{"
+	_, err = ParseConfig(flag.NewFlagSet("serve", flag.ContinueOnError), nil, getenv, serveFlags)
+	assert.NotNil(t, err)
+}
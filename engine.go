@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+// DefaultDataSource - name of the data source entities without a data source of their own live in
+const DefaultDataSource = "default"
+
+// DataSource - database entities are introspected in and fetched from
+type DataSource interface {
+	GetTableSchema(ctx context.Context, table string) (TableSchema, error)
+	GetPrimaryKey(ctx context.Context, table string) ([]string, error)
+	FetchScan(ctx context.Context, statement *Statement, limit int, schema TableSchema) (*Result, error)
+	Stream(ctx context.Context, statement *Statement, limit int, schema TableSchema) (RowIterator, error)
+	// Exec - Run a statement modifying data, on the primary when the data source has replicas
+	Exec(ctx context.Context, statement *Statement) (sql.Result, error)
+	Close() error
+}
+
+// Engine - generates the GraphQL schema for entities and executes queries on their data sources
+type Engine struct {
+	dataSources map[string]DataSource
+	// ExportBatchSize - rows fetched per page by exports, DefaultExportBatchSize if 0
+	ExportBatchSize int
+	// MaxAllowedPacket - max_allowed_packet of the servers, DefaultMaxAllowedPacket if 0
+	MaxAllowedPacket int
+	// Cache - results of queries on entities with a cache TTL are cached in, nothing is cached if nil
+	Cache ResultCache
+	// ChangeFeed - reports changes of entities to their subscriptions, subscriptions are not
+	// supported if nil
+	ChangeFeed ChangeFeed
+}
+
+// NewEngine - Engine executing queries on the named data sources
+func NewEngine(dataSources map[string]DataSource) *Engine {
+	return &Engine{dataSources: dataSources}
+}
+
+func (e *Engine) maxAllowedPacket() int {
+	if e.MaxAllowedPacket > 0 {
+		return e.MaxAllowedPacket
+	}
+
+	return DefaultMaxAllowedPacket
+}
+
+// DataSource - Data source entity lives in
+func (e *Engine) DataSource(entity string) (DataSource, error) {
+	name := Entities.GetDataSource(entity)
+	if dataSource, ok := e.dataSources[name]; ok {
+		return dataSource, nil
+	}
+
+	return nil, fmt.Errorf("no data source %q for entity %q", name, entity)
+}
+
+// entitySchema - Schema of the table of entity without the columns hidden from every role, entities
+// with computed fields named like columns are not served
+func (e *Engine) entitySchema(ctx context.Context, entity string) (TableSchema, error) {
+	dataSource, err := e.DataSource(entity)
+	if err != nil {
+		return nil, err
+	}
+
+	tableSchema, err := dataSource.GetTableSchema(ctx, Entities.GetTableName(entity))
+	if err != nil {
+		return nil, err
+	}
+	if err := computedColumnError(entity, tableSchema); err != nil {
+		return nil, err
+	}
+
+	hidden := Entities.GetHiddenColumns(entity)
+	if len(hidden) == 0 {
+		return tableSchema, nil
+	}
+
+	// The schema may be shared with the cache of the data source, it is copied instead of modified
+	visible := make(TableSchema, len(tableSchema))
+	for column, columnType := range tableSchema {
+		if !contains(hidden, column) {
+			visible[column] = columnType
+		}
+	}
+
+	return visible, nil
+}
+
+// Close - Close all data sources of the engine
+func (e *Engine) Close() error {
+	var err error
+	for _, dataSource := range e.dataSources {
+		if closeErr := dataSource.Close(); closeErr != nil && err == nil {
+			err = closeErr
+		}
+	}
+
+	return err
+}
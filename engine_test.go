@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeDataSource - data source serving a fixed table schema and rows, recording the queries run
+type fakeDataSource struct {
+	schema     TableSchema
+	primaryKey []string
+	rows       []map[string]interface{}
+	// pages - rows returned by consecutive fetches instead of rows when set
+	pages   [][]map[string]interface{}
+	queries []*Statement
+	// mu - guards queries and pages, batched requests fetch concurrently
+	mu sync.Mutex
+}
+
+func (f *fakeDataSource) GetTableSchema(ctx context.Context, table string) (TableSchema, error) {
+	return f.schema, nil
+}
+
+func (f *fakeDataSource) GetPrimaryKey(ctx context.Context, table string) ([]string, error) {
+	return f.primaryKey, nil
+}
+
+func (f *fakeDataSource) FetchScan(ctx context.Context, statement *Statement, limit int, schema TableSchema) (*Result, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.queries = append(f.queries, statement)
+
+	source := f.rows
+	if f.pages != nil {
+		source = nil
+		if len(f.pages) > 0 {
+			source, f.pages = f.pages[0], f.pages[1:]
+		}
+	}
+
+	var rows []map[string]interface{}
+	for _, row := range source {
+		copied := map[string]interface{}{}
+		for column, value := range row {
+			copied[column] = value
+		}
+		rows = append(rows, copied)
+	}
+
+	return &Result{Rows: rows, Count: len(rows)}, nil
+}
+
+func (f *fakeDataSource) Stream(ctx context.Context, statement *Statement, limit int, schema TableSchema) (RowIterator, error) {
+	result, err := f.FetchScan(ctx, statement, limit, schema)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewSliceRowIterator(result.Rows), nil
+}
+
+func (f *fakeDataSource) Exec(ctx context.Context, statement *Statement) (sql.Result, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.queries = append(f.queries, statement)
+	return nil, nil
+}
+
+func (f *fakeDataSource) Close() error {
+	return nil
+}
+
+func TestEngine_Query(t *testing.T) {
+	Entities["engine_test"] = EntityConfig{
+		TableName:  "engine_test",
+		DataSource: "reporting",
+		RowFilters: map[string]map[string]interface{}{
+			"merchant": {"merchant_id": map[string]interface{}{"_eq": "X-Merchant-Id"}},
+		},
+	}
+	defer delete(Entities, "engine_test")
+
+	reporting := &fakeDataSource{
+		schema: TableSchema{"id": "int", "merchant_id": "varchar"},
+		rows:   []map[string]interface{}{{"id": int64(1)}},
+	}
+	engine := NewEngine(map[string]DataSource{"reporting": reporting})
+
+	ctx := WithSession(context.Background(), NewSession("merchant", map[string]string{"X-Merchant-Id": "m1"}))
+	result := engine.Query(ctx, `{ engine_test(limit: 5) { id } }`)
+	assert.Empty(t, result.Errors)
+	assert.Equal(t, map[string]interface{}{"engine_test": []interface{}{map[string]interface{}{"id": 1}}}, result.Data)
+	assert.Len(t, reporting.queries, 1)
+	assert.Contains(t, reporting.queries[0].SQL, "WHERE `merchant_id` = ? LIMIT ?")
+	assert.Equal(t, []interface{}{"m1", 5, 0}, reporting.queries[0].Args)
+
+	result = engine.Query(context.Background(), `{ engine_test { id } }`)
+	assert.NotEmpty(t, result.Errors)
+	assert.Len(t, reporting.queries, 1)
+
+	result = engine.Query(ctx, `{ engine_test(limit: -1) { id } }`)
+	if assert.Len(t, result.Errors, 1) {
+		assert.Equal(t, InvalidPagination, result.Errors[0].Extensions["code"])
+	}
+	assert.Len(t, reporting.queries, 1)
+
+	result = NewEngine(map[string]DataSource{}).Query(ctx, `{ engine_test { id } }`)
+	assert.NotEmpty(t, result.Errors)
+}
+
+func TestEngine_QueryEntityConfig(t *testing.T) {
+	Entities["engine_config_test"] = EntityConfig{
+		TableName:     "engine_config_payments",
+		GraphQLName:   "payment_list",
+		HiddenColumns: []string{"internal_note"},
+		DefaultOrder:  []map[string]interface{}{{"id": "desc"}},
+	}
+	defer delete(Entities, "engine_config_test")
+
+	dataSource := &fakeDataSource{
+		schema: TableSchema{"id": "int", "internal_note": "varchar"},
+		rows:   []map[string]interface{}{{"id": int64(1)}},
+	}
+	engine := NewEngine(map[string]DataSource{DefaultDataSource: dataSource})
+
+	result := engine.Query(context.Background(), `{ payment_list { id } }`)
+	assert.Empty(t, result.Errors)
+	assert.Equal(t, map[string]interface{}{"payment_list": []interface{}{map[string]interface{}{"id": 1}}}, result.Data)
+	assert.Len(t, dataSource.queries, 1)
+	assert.Contains(t, dataSource.queries[0].SQL, "FROM `engine_config_payments` ORDER BY `id` desc")
+
+	result = engine.Query(context.Background(), `{ payment_list { internal_note } }`)
+	assert.NotEmpty(t, result.Errors)
+	result = engine.Query(context.Background(), `{ engine_config_test { id } }`)
+	assert.NotEmpty(t, result.Errors)
+	assert.Len(t, dataSource.queries, 1)
+}
@@ -1,53 +1,180 @@
 package main
 
+import "time"
+
 // Entities
 const (
 	Payments = "payments"
 )
 
-const (
-	AllowedFilter = "allowed_filter"
-	Relations     = "relations"
-	TableName     = "table_name"
-)
+// EntityConfig - configuration of an entity, see LoadEntities for the file it is read from
+//
+// RowFilters maps a role to a filter expression in the shape of the where argument, string values
+// starting with X- are replaced by the session variable of the same name, eg
+//
+//	RowFilters: map[string]map[string]interface{}{
+//		"merchant": {"merchant_id": map[string]interface{}{"_eq": "X-Merchant-Id"}},
+//		"admin":    nil,
+//	}
+//
+// ColumnMasks maps a column to the mask rule per role, AnyRole applies to roles without a rule, eg
+//
+//	ColumnMasks: map[string]map[string]MaskRule{
+//		"card_number": {AnyRole: {Strategy: MaskKeepLast, KeepLast: 4}, "admin": {Strategy: MaskNone}},
+//		"email":       {"support": {Strategy: MaskHash, Salt: "s3cr3t"}},
+//	}
+//
+// Relations maps a field name to the entity it relates rows to, eg
+//
+//	Relations: map[string]Relation{
+//		"merchant": {Entity: "merchants", Column: "merchant_id", RelatedColumn: "id"},
+//		"refunds":  {Entity: "refunds", Column: "id", RelatedColumn: "payment_id", Many: true},
+//	}
+type EntityConfig struct {
+	// TableName - table of the entity, the name of the entity if empty
+	TableName string `yaml:"table"`
+	// GraphQLName - name of the query field of the entity, the name of the entity in the case of
+	// the field naming strategy if empty
+	GraphQLName string `yaml:"graphql_name"`
+	// TypeName - name of the object type of the entity, the name of the entity in the case of the
+	// type naming strategy if empty
+	TypeName string `yaml:"type_name"`
+	// Naming - strategies the names of the types and fields are derived with, see NamingConfig
+	Naming NamingConfig `yaml:"naming"`
+	// ColumnNames - names of the fields of columns which are not named by the naming strategy
+	ColumnNames map[string]string `yaml:"column_names"`
+	// DataSource - name of the data source the table lives in, DefaultDataSource if empty
+	DataSource string `yaml:"data_source"`
+
+	// AllowedFilters - columns allowed in where, every column if nil
+	AllowedFilters []string `yaml:"allowed_filters"`
+	// HiddenColumns - columns left out of the schema for every role
+	HiddenColumns []string `yaml:"hidden_columns"`
+	// DefaultOrder - order of the rows of queries without order_by, in the shape of order_by
+	DefaultOrder []map[string]interface{} `yaml:"default_order"`
+	// MaxLimit - maximum value of the limit argument, unlimited if 0
+	MaxLimit int `yaml:"max_limit"`
+
+	// ComputedFields - fields computed from the columns by their name, see ComputedField
+	ComputedFields map[string]ComputedField `yaml:"computed_fields"`
 
-type EntityConfig map[string]interface{}
+	Relations   map[string]Relation               `yaml:"relations"`
+	RowFilters  map[string]map[string]interface{} `yaml:"row_filters"`
+	ColumnMasks map[string]map[string]MaskRule    `yaml:"column_masks"`
 
-var Entities = EntityConfig{
-	Payments: map[string]interface{}{
+	// StatementTimeout - time a statement may run, DefaultStatementTimeout if 0
+	StatementTimeout time.Duration `yaml:"statement_timeout"`
+	// CacheTTL - time results are cached for, they are not cached if 0
+	CacheTTL time.Duration `yaml:"cache_ttl"`
+	// ChangeColumn - indexed updated_at or version column polled for changes
+	ChangeColumn string `yaml:"change_column"`
+}
+
+// EntityConfigs - configuration of the entities by their name
+type EntityConfigs map[string]EntityConfig
+
+var Entities = EntityConfigs{
+	Payments: {
 		TableName: "payments",
 	},
 }
 
-func (e EntityConfig) getConfigValue(entity, configKey string) interface{} {
-	if config, ok := e[entity]; ok {
-		if value, ok := config.(map[string]interface{})[configKey]; ok {
-			return value
+// GetAllowedFilters - Columns allowed in where, nil if not configured which allows every column
+func (e EntityConfigs) GetAllowedFilters(entity string) []string {
+	return e[entity].AllowedFilters
+}
+
+// GetRelations - Relations of entity by the name of their field
+func (e EntityConfigs) GetRelations(entity string) map[string]Relation {
+	return e[entity].Relations
+}
+
+// GetTableName - Table of entity, the entity name unless configured
+func (e EntityConfigs) GetTableName(entity string) string {
+	if table := e[entity].TableName; table != "" {
+		return table
+	}
+
+	return entity
+}
+
+// GetGraphQLName - Name of the query field of entity, the entity name in the case of its field
+// naming strategy unless configured
+func (e EntityConfigs) GetGraphQLName(entity string) string {
+	if name := e[entity].GraphQLName; name != "" {
+		return name
+	}
+
+	return e[entity].Naming.Fields.Apply(entity)
+}
+
+// EntityOf - Entity queried by the query field named name, false if no entity is
+func (e EntityConfigs) EntityOf(name string) (string, bool) {
+	for entity := range e {
+		if e.GetGraphQLName(entity) == name {
+			return entity, true
 		}
 	}
-	return nil
+
+	return "", false
 }
 
-func (e EntityConfig) GetAllowedFilters(entity string) []string {
-	if config := e.getConfigValue(entity, AllowedFilter); config != nil {
-		return config.([]string)
+// GetRowFilter - Row level filter of the role for entity. Entities without row filters are
+// unrestricted, for entities with row filters a role without an entry has no access at all.
+func (e EntityConfigs) GetRowFilter(entity, role string) (map[string]interface{}, bool) {
+	filters := e[entity].RowFilters
+	if filters == nil {
+		return nil, true
 	}
 
-	return []string{}
+	filter, ok := filters[role]
+	return filter, ok
+}
+
+func (e EntityConfigs) GetColumnMasks(entity string) map[string]map[string]MaskRule {
+	return e[entity].ColumnMasks
+}
+
+// GetHiddenColumns - Columns of entity no role sees
+func (e EntityConfigs) GetHiddenColumns(entity string) []string {
+	return e[entity].HiddenColumns
 }
 
-func (e EntityConfig) GetRelations(entity string) []string {
-	if config := e.getConfigValue(entity, Relations); config != nil {
-		return config.([]string)
+// GetDefaultOrder - Order of the rows of entity when the query has no order_by
+func (e EntityConfigs) GetDefaultOrder(entity string) []map[string]interface{} {
+	return e[entity].DefaultOrder
+}
+
+// GetMaxLimit - Maximum value of the limit argument for entity, 0 if unlimited
+func (e EntityConfigs) GetMaxLimit(entity string) int {
+	return e[entity].MaxLimit
+}
+
+// GetStatementTimeout - Time a statement on entity may run, DefaultStatementTimeout if not configured
+func (e EntityConfigs) GetStatementTimeout(entity string) time.Duration {
+	if timeout := e[entity].StatementTimeout; timeout > 0 {
+		return timeout
 	}
 
-	return []string{}
+	return DefaultStatementTimeout
+}
+
+// GetCacheTTL - Time results read from entity are cached for, 0 if they are not cached
+func (e EntityConfigs) GetCacheTTL(entity string) time.Duration {
+	return e[entity].CacheTTL
+}
+
+// GetChangeColumn - Indexed updated_at or version column polled for changes of entity, empty if
+// not configured
+func (e EntityConfigs) GetChangeColumn(entity string) string {
+	return e[entity].ChangeColumn
 }
 
-func (e EntityConfig) GetTableName(entity string) string {
-	if config := e.getConfigValue(entity, TableName); config != nil {
-		return config.(string)
+// GetDataSource - Name of the data source entity lives in, DefaultDataSource if not configured
+func (e EntityConfigs) GetDataSource(entity string) string {
+	if name := e[entity].DataSource; name != "" {
+		return name
 	}
 
-	return ""
+	return DefaultDataSource
 }
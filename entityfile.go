@@ -0,0 +1,428 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// entitiesFile - layout of an entities file, eg
+//
+//	naming: {types: pascal, fields: camel}
+//	entities:
+//	  payments:
+//	    table: payments
+//	    graphql_name: payments
+//	    type_name: Payment
+//	    column_names: {card_number: card}
+//	    allowed_filters: [status, merchant_id]
+//	    hidden_columns: [internal_note]
+//	    default_order: [{created_at: desc}]
+//	    max_limit: 500
+//	    statement_timeout: 5s
+//	    row_filters:
+//	      merchant: {merchant_id: {_eq: X-Merchant-Id}}
+//	      admin: null
+//	    column_masks:
+//	      card_number: {"*": {strategy: keep_last, keep_last: 4}}
+//	    relations:
+//	      merchant: {entity: merchants, column: merchant_id, related_column: id}
+//	    computed_fields:
+//	      amount_in_dollars: {expression: "{amount} / 100", type: decimal}
+//	      is_refundable: {resolver: payments.is_refundable, type: tinyint(1)}
+//	query_limits:
+//	  merchant: {max_depth: 3, max_complexity: 5000, max_rows: 1000}
+//
+// The naming strategies apply to the entities which do not set their own. JSON files have the same
+// layout, JSON being YAML as far as the loader is concerned.
+type entitiesFile struct {
+	Naming      NamingConfig               `yaml:"naming"`
+	Entities    EntityConfigs              `yaml:"entities"`
+	QueryLimits map[string]roleQueryLimits `yaml:"query_limits"`
+}
+
+// roleQueryLimits - query limits of a role in an entities file, the limits left out are the ones of
+// DefaultQueryLimits
+type roleQueryLimits struct {
+	MaxDepth      *int `yaml:"max_depth"`
+	MaxComplexity *int `yaml:"max_complexity"`
+	MaxRows       *int `yaml:"max_rows"`
+}
+
+// EntityFileError - problem of an entities file, at Line of the file if it is known
+type EntityFileError struct {
+	Path    string
+	Line    int
+	Message string
+}
+
+func (e *EntityFileError) Error() string {
+	if e.Line == 0 {
+		return fmt.Sprintf("%s: %s", e.Path, e.Message)
+	}
+
+	return fmt.Sprintf("%s:%d: %s", e.Path, e.Line, e.Message)
+}
+
+// EntityFileErrors - every problem found in an entities file, in the order of their lines
+type EntityFileErrors []*EntityFileError
+
+func (e EntityFileErrors) Error() string {
+	messages := make([]string, 0, len(e))
+	for _, err := range e {
+		messages = append(messages, err.Error())
+	}
+
+	return strings.Join(messages, "\n")
+}
+
+// interpolation - ${NAME} or ${NAME:-default}, $$ is a literal $
+var interpolation = regexp.MustCompile(`\$\$|\$\{([A-Za-z_][A-Za-z0-9_]*)(?::-([^}]*))?\}`)
+
+// graphQLName - names GraphQL allows for fields and types
+var graphQLName = regexp.MustCompile(`^[_A-Za-z][_0-9A-Za-z]*$`)
+
+// LoadEntities - Entity configuration and query limits per role of the YAML or JSON file at path,
+// with the environment variables it refers to interpolated, see entitiesFile for its layout
+func LoadEntities(path string) (EntityConfigs, map[string]QueryLimits, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return ParseEntities(path, content, os.LookupEnv)
+}
+
+// ParseEntities - Entity configuration and query limits per role of content, the file at path.
+// ${NAME} is replaced by the variable lookupEnv returns for NAME, ${NAME:-default} by default when
+// it is not set. Fields which do not exist and values of the wrong type are errors, as are
+// relations to entities which are not in the file, unknown mask and naming strategies, keep_last
+// counts which are negative or given to another mask strategy, sort directions other than asc and
+// desc, negative limits and durations, invalid GraphQL names, entities sharing a GraphQL or type
+// name, and computed fields without exactly one of an expression and a registered resolver.
+func ParseEntities(path string, content []byte, lookupEnv func(string) (string, bool)) (EntityConfigs, map[string]QueryLimits, error) {
+	content, err := interpolate(path, content, lookupEnv)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var file entitiesFile
+	decoder := yaml.NewDecoder(bytes.NewReader(content))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&file); err != nil {
+		return nil, nil, decodeError(path, err)
+	}
+
+	// The nodes only locate the problems found once the file is decoded
+	var root yaml.Node
+	if err := yaml.Unmarshal(content, &root); err != nil {
+		return nil, nil, decodeError(path, err)
+	}
+
+	if len(file.Entities) == 0 {
+		return nil, nil, &EntityFileError{Path: path, Message: "no entities"}
+	}
+
+	for _, strategy := range []NamingStrategy{file.Naming.Types, file.Naming.Fields} {
+		if !strategy.Valid() {
+			return nil, nil, &EntityFileError{Path: path, Line: nodeLine(&root, "naming"), Message: fmt.Sprintf("unknown naming strategy %q", strategy)}
+		}
+	}
+
+	entities := file.Entities
+	for name, entity := range entities {
+		if entity.TableName == "" {
+			entity.TableName = name
+		}
+		if entity.Naming.Types == "" {
+			entity.Naming.Types = file.Naming.Types
+		}
+		if entity.Naming.Fields == "" {
+			entity.Naming.Fields = file.Naming.Fields
+		}
+		entities[name] = entity
+	}
+
+	problem := func(key string) func(message string, keys ...string) *EntityFileError {
+		return func(message string, keys ...string) *EntityFileError {
+			return &EntityFileError{
+				Path:    path,
+				Line:    nodeLine(&root, append([]string{key}, keys...)...),
+				Message: message,
+			}
+		}
+	}
+	problems := validateEntities(entities, problem("entities"))
+	limits, limitProblems := queryLimits(file.QueryLimits, problem("query_limits"))
+	problems = append(problems, limitProblems...)
+	if len(problems) > 0 {
+		sort.SliceStable(problems, func(i, j int) bool { return problems[i].Line < problems[j].Line })
+		return nil, nil, problems
+	}
+
+	return entities, limits, nil
+}
+
+// interpolate - content with the environment variables it refers to replaced line by line, so that
+// the lines of errors found later are still the ones of the file
+func interpolate(path string, content []byte, lookupEnv func(string) (string, bool)) ([]byte, error) {
+	lines := bytes.Split(content, []byte("\n"))
+	for idx, line := range lines {
+		var missing []string
+		lines[idx] = interpolation.ReplaceAllFunc(line, func(match []byte) []byte {
+			if string(match) == "$$" {
+				return []byte("$")
+			}
+
+			groups := interpolation.FindSubmatch(match)
+			if value, ok := lookupEnv(string(groups[1])); ok {
+				return []byte(value)
+			}
+			if bytes.Contains(match, []byte(":-")) {
+				return groups[2]
+			}
+			missing = append(missing, string(groups[1]))
+			return match
+		})
+
+		if len(missing) > 0 {
+			return nil, &EntityFileError{
+				Path:    path,
+				Line:    idx + 1,
+				Message: fmt.Sprintf("environment variable %s is not set and has no default", strings.Join(missing, ", ")),
+			}
+		}
+	}
+
+	return bytes.Join(lines, []byte("\n")), nil
+}
+
+// decoderLine - line the YAML decoder starts its messages with, eg line 3: field x not found
+var decoderLine = regexp.MustCompile(`^line (\d+): `)
+
+// decodeError - Errors of the YAML decoder, with the lines their messages start with as Line
+func decodeError(path string, err error) error {
+	if typeErr, ok := err.(*yaml.TypeError); ok {
+		problems := EntityFileErrors{}
+		for _, message := range typeErr.Errors {
+			problems = append(problems, decoderError(path, message))
+		}
+		sort.SliceStable(problems, func(i, j int) bool { return problems[i].Line < problems[j].Line })
+		return problems
+	}
+
+	return decoderError(path, strings.TrimPrefix(err.Error(), "yaml: "))
+}
+
+// decoderError - Problem of a message of the YAML decoder, at the line it starts with
+func decoderError(path, message string) *EntityFileError {
+	problem := &EntityFileError{Path: path, Message: message}
+	if match := decoderLine.FindStringSubmatch(message); match != nil {
+		problem.Line, _ = strconv.Atoi(match[1])
+		problem.Message = strings.TrimPrefix(message, match[0])
+	}
+
+	return problem
+}
+
+// validateEntities - Problems of entities the YAML decoder does not find, problem creates them for
+// the value at the path of keys below the entities
+func validateEntities(entities EntityConfigs, problem func(message string, keys ...string) *EntityFileError) EntityFileErrors {
+	names := make([]string, 0, len(entities))
+	for name := range entities {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	problems := EntityFileErrors{}
+	graphQLNames := map[string]string{}
+	// Types of the schema which are not named after an entity
+	typeNames := map[string]string{"Query": "", "Subscription": ""}
+	for scalar := range builtinScalars {
+		typeNames[scalar] = ""
+		typeNames[scalar+"Comparison"] = ""
+	}
+	for _, name := range names {
+		entity := entities[name]
+
+		if !entity.Naming.Types.Valid() {
+			problems = append(problems, problem(fmt.Sprintf("unknown naming strategy %q", entity.Naming.Types), name, "naming", "types"))
+		}
+		if !entity.Naming.Fields.Valid() {
+			problems = append(problems, problem(fmt.Sprintf("unknown naming strategy %q", entity.Naming.Fields), name, "naming", "fields"))
+		}
+
+		typeName := entities.GetTypeName(name)
+		for _, typeName := range []string{typeName, entities.GetInputTypeName(name, "where"), entities.GetInputTypeName(name, "order_by")} {
+			if !graphQLName.MatchString(typeName) {
+				problems = append(problems, problem(fmt.Sprintf("%q is not a valid GraphQL type name", typeName), name, "type_name"))
+				continue
+			}
+			if other, ok := typeNames[typeName]; ok {
+				message := fmt.Sprintf("type name %q is already taken by the schema", typeName)
+				if other != "" {
+					message = fmt.Sprintf("type name %q is already the one of a type of entity %q", typeName, other)
+				}
+				problems = append(problems, problem(message, name, "type_name"))
+			}
+			typeNames[typeName] = name
+		}
+
+		for column, field := range entity.ColumnNames {
+			if !graphQLName.MatchString(field) {
+				problems = append(problems, problem(fmt.Sprintf("%q is not a valid GraphQL name", field), name, "column_names", column))
+			}
+		}
+
+		fieldName := entities.GetGraphQLName(name)
+		if !graphQLName.MatchString(fieldName) {
+			problems = append(problems, problem(fmt.Sprintf("%q is not a valid GraphQL name", fieldName), name, "graphql_name"))
+		}
+		if other, ok := graphQLNames[fieldName]; ok {
+			problems = append(problems, problem(fmt.Sprintf("GraphQL name %q is already the one of entity %q", fieldName, other), name, "graphql_name"))
+		}
+		graphQLNames[fieldName] = name
+
+		if entity.MaxLimit < 0 {
+			problems = append(problems, problem("max_limit must not be negative", name, "max_limit"))
+		}
+		if entity.StatementTimeout < 0 {
+			problems = append(problems, problem("statement_timeout must not be negative", name, "statement_timeout"))
+		}
+		if entity.CacheTTL < 0 {
+			problems = append(problems, problem("cache_ttl must not be negative", name, "cache_ttl"))
+		}
+
+		for _, criteria := range entity.DefaultOrder {
+			for column, direction := range criteria {
+				if direction, _ := direction.(string); !strings.EqualFold(direction, "asc") && !strings.EqualFold(direction, "desc") {
+					problems = append(problems, problem(fmt.Sprintf("order of %q must be asc or desc", column), name, "default_order"))
+				}
+			}
+		}
+
+		for field, relation := range entity.Relations {
+			if _, ok := entities[relation.Entity]; !ok {
+				problems = append(problems, problem(fmt.Sprintf("relation %q relates unknown entity %q", field, relation.Entity), name, "relations", field))
+			}
+			if relation.Column == "" || relation.RelatedColumn == "" {
+				problems = append(problems, problem(fmt.Sprintf("relation %q needs column and related_column", field), name, "relations", field))
+			}
+		}
+
+		columns := entities.configuredColumns(name)
+		for field, computed := range entity.ComputedFields {
+			if !graphQLName.MatchString(field) {
+				problems = append(problems, problem(fmt.Sprintf("%q is not a valid GraphQL name", field), name, "computed_fields", field))
+			}
+			if columns[field] {
+				problems = append(problems, problem(fmt.Sprintf("computed field %q is named like a column", field), name, "computed_fields", field))
+			}
+			for column, renamed := range entity.ColumnNames {
+				if renamed == field {
+					problems = append(problems, problem(fmt.Sprintf("computed field %q is named like the field of column %q", field, column), name, "computed_fields", field))
+				}
+			}
+			if (computed.Expression == "") == (computed.Resolver == "") {
+				problems = append(problems, problem(fmt.Sprintf("computed field %q needs either an expression or a resolver", field), name, "computed_fields", field))
+				continue
+			}
+			if _, ok := registeredResolvers[computed.Resolver]; computed.Resolver != "" && !ok {
+				problems = append(problems, problem(fmt.Sprintf("computed field %q has unknown resolver %q", field, computed.Resolver), name, "computed_fields", field, "resolver"))
+			}
+		}
+
+		for column, rules := range entity.ColumnMasks {
+			for role, rule := range rules {
+				switch rule.Strategy {
+				case MaskNone, MaskRedact, MaskKeepLast, MaskHash, MaskNull:
+				default:
+					problems = append(problems, problem(fmt.Sprintf("unknown mask strategy %q", rule.Strategy), name, "column_masks", column, role))
+				}
+				if rule.KeepLast < 0 {
+					problems = append(problems, problem("keep_last must not be negative", name, "column_masks", column, role, "keep_last"))
+				} else if rule.KeepLast != 0 && rule.Strategy != MaskKeepLast {
+					problems = append(problems, problem(fmt.Sprintf("keep_last is only used by the keep_last strategy, not by %q", rule.Strategy), name, "column_masks", column, role, "keep_last"))
+				}
+			}
+		}
+	}
+
+	sort.SliceStable(problems, func(i, j int) bool { return problems[i].Line < problems[j].Line })
+
+	return problems
+}
+
+// queryLimits - Query limits per role of the ones of an entities file, problem creates the problems
+// of the value at the path of keys below the query limits
+func queryLimits(limits map[string]roleQueryLimits, problem func(message string, keys ...string) *EntityFileError) (map[string]QueryLimits, EntityFileErrors) {
+	roles := make([]string, 0, len(limits))
+	for role := range limits {
+		roles = append(roles, role)
+	}
+	sort.Strings(roles)
+
+	problems := EntityFileErrors{}
+	queryLimits := map[string]QueryLimits{}
+	for _, role := range roles {
+		configured := limits[role]
+		roleLimits := DefaultQueryLimits
+		for _, limit := range []struct {
+			key    string
+			value  *int
+			target *int
+		}{
+			{"max_depth", configured.MaxDepth, &roleLimits.MaxDepth},
+			{"max_complexity", configured.MaxComplexity, &roleLimits.MaxComplexity},
+			{"max_rows", configured.MaxRows, &roleLimits.MaxRows},
+		} {
+			if limit.value == nil {
+				continue
+			}
+			if *limit.value < 0 {
+				problems = append(problems, problem(limit.key+" must not be negative", role, limit.key))
+			}
+			*limit.target = *limit.value
+		}
+		queryLimits[role] = roleLimits
+	}
+
+	return queryLimits, problems
+}
+
+// nodeLine - Line of the value at the path of mapping keys below node, the line of the deepest
+// key found if the path does not exist
+func nodeLine(node *yaml.Node, keys ...string) int {
+	if node.Kind == yaml.DocumentNode && len(node.Content) > 0 {
+		node = node.Content[0]
+	}
+
+	line := node.Line
+	for _, key := range keys {
+		if node.Kind != yaml.MappingNode {
+			break
+		}
+
+		var found *yaml.Node
+		for idx := 0; idx+1 < len(node.Content); idx += 2 {
+			if node.Content[idx].Value == key {
+				line = node.Content[idx].Line
+				found = node.Content[idx+1]
+				break
+			}
+		}
+		if found == nil {
+			break
+		}
+		node = found
+	}
+
+	return line
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseEntities(t *testing.T) {
+	content, err := ioutil.ReadFile("testdata/entities.yaml")
+	assert.Nil(t, err)
+
+	env := map[string]string{"PAYMENTS_MAX_LIMIT": "500"}
+	lookupEnv := func(name string) (string, bool) {
+		value, ok := env[name]
+		return value, ok
+	}
+
+	entities, limits, err := ParseEntities("entities.yaml", content, lookupEnv)
+	assert.Nil(t, err)
+	assert.Equal(t, EntityConfig{
+		TableName:      "payments",
+		GraphQLName:    "payment_list",
+		AllowedFilters: []string{"status", "merchant_id"},
+		HiddenColumns:  []string{"internal_note"},
+		DefaultOrder: []map[string]interface{}{
+			{"created_at": "desc"},
+			{"id": "asc"},
+		},
+		MaxLimit: 500,
+		Relations: map[string]Relation{
+			"merchant": {Entity: "merchants", Column: "merchant_id", RelatedColumn: "id"},
+		},
+		RowFilters: map[string]map[string]interface{}{
+			"merchant": {"merchant_id": map[string]interface{}{"_eq": "X-Merchant-Id"}},
+			"admin":    nil,
+		},
+		ColumnMasks: map[string]map[string]MaskRule{
+			"card_number": {AnyRole: {Strategy: MaskKeepLast, KeepLast: 4}, "admin": {Strategy: MaskNone}},
+		},
+		StatementTimeout: 5 * time.Second,
+		CacheTTL:         time.Minute,
+		ChangeColumn:     "updated_at",
+	}, entities["payments"])
+	// The table defaults to the name of the entity
+	assert.Equal(t, EntityConfig{TableName: "merchants", DataSource: "merchants"}, entities["merchants"])
+	// Limits left out are the default ones
+	assert.Equal(t, map[string]QueryLimits{
+		"merchant": {MaxDepth: 3, MaxComplexity: DefaultQueryLimits.MaxComplexity, MaxRows: 1000},
+	}, limits)
+	entity, ok := entities.EntityOf("payment_list")
+	assert.True(t, ok)
+	assert.Equal(t, "payments", entity)
+
+	env["PAYMENTS_TABLE"] = "payments_v2"
+	entities, _, err = ParseEntities("entities.yaml", content, lookupEnv)
+	assert.Nil(t, err)
+	assert.Equal(t, "payments_v2", entities.GetTableName("payments"))
+
+	delete(env, "PAYMENTS_MAX_LIMIT")
+	_, _, err = ParseEntities("entities.yaml", content, lookupEnv)
+	assert.EqualError(t, err, "entities.yaml:11: environment variable PAYMENTS_MAX_LIMIT is not set and has no default")
+
+	// JSON is read the same way, entities without naming strategies of their own get the ones of the file
+	entities, _, err = ParseEntities("entities.json", []byte(`{
+		"naming": {"types": "pascal", "fields": "camel"},
+		"entities": {"refunds": {"max_limit": 10, "statement_timeout": "2s", "naming": {"fields": "snake"}}}
+	}`), lookupEnv)
+	assert.Nil(t, err)
+	assert.Equal(t, EntityConfig{
+		TableName:        "refunds",
+		MaxLimit:         10,
+		StatementTimeout: 2 * time.Second,
+		Naming:           NamingConfig{Types: NamingPascal, Fields: NamingSnake},
+	}, entities["refunds"])
+
+	tests := []struct {
+		content string
+		err     string
+	}{
+		{"entities:\n  payments:\n    tabel: payments\n", "entities.yaml:3: field tabel not found in type main.EntityConfig"},
+		{"entities:\n  payments:\n    max_limit: many\n", "entities.yaml:3: cannot unmarshal !!str `many` into int"},
+		{"entities:\n  payments:\n    allowed_filters: [a\n", "entities.yaml:2: did not find expected ',' or ']'"},
+		{"payments: {}\n", "entities.yaml:1: field payments not found in type main.entitiesFile"},
+		{
+			"entities:\n  payments:\n    tabel: payments\n    max_limit: many\n",
+			"entities.yaml:3: field tabel not found in type main.EntityConfig\nentities.yaml:4: cannot unmarshal !!str `many` into int",
+		},
+		{"entities: {}\n", "entities.yaml: no entities"},
+		{
+			"entities:\n  payments:\n    relations:\n      merchant: {entity: merchant, column: merchant_id, related_column: id}\n",
+			`entities.yaml:4: relation "merchant" relates unknown entity "merchant"`,
+		},
+		{
+			"entities:\n  payments:\n    column_masks:\n      email:\n        support: {strategy: scramble}\n",
+			`entities.yaml:5: unknown mask strategy "scramble"`,
+		},
+		{
+			"entities:\n  payments:\n    column_masks:\n      card_number:\n        support:\n          strategy: keep_last\n          keep_last: -4\n" +
+				"      email:\n        support: {strategy: redact, keep_last: 4}\n",
+			"entities.yaml:7: keep_last must not be negative\nentities.yaml:9: keep_last is only used by the keep_last strategy, not by \"redact\"",
+		},
+		{
+			"entities:\n  payments:\n    default_order: [{id: up}]\n    max_limit: -1\n",
+			"entities.yaml:3: order of \"id\" must be asc or desc\nentities.yaml:4: max_limit must not be negative",
+		},
+		{"naming: {fields: kebab}\nentities:\n  payments: {}\n", `entities.yaml:1: unknown naming strategy "kebab"`},
+		{
+			"entities:\n  payments:\n    max_limit: -1\nquery_limits:\n  merchant:\n    max_rows: -1\n    max_dept: 2\n",
+			"entities.yaml:7: field max_dept not found in type main.roleQueryLimits",
+		},
+		{
+			"query_limits:\n  merchant: {max_rows: -1}\nentities:\n  payments:\n    max_limit: -1\n",
+			"entities.yaml:2: max_rows must not be negative\nentities.yaml:5: max_limit must not be negative",
+		},
+		{
+			"naming: {types: pascal}\nentities:\n  payments: {}\n  payments_where: {}\n",
+			`entities.yaml:4: type name "PaymentsWhere" is already the one of a type of entity "payments"`,
+		},
+		{
+			"entities:\n  payments:\n    type_name: Query\n    column_names: {card_number: card-number}\n",
+			"entities.yaml:3: type name \"Query\" is already taken by the schema\nentities.yaml:4: \"card-number\" is not a valid GraphQL name",
+		},
+		{
+			"entities:\n  payments: {}\n  refunds:\n    graphql_name: payments\n",
+			`entities.yaml:4: GraphQL name "payments" is already the one of entity "payments"`,
+		},
+		{
+			"entities:\n  payments:\n    computed_fields:\n      total: {expression: \"{amount}\", resolver: total}\n      is-paid: {expression: \"{status} = 'paid'\"}\n",
+			"entities.yaml:4: computed field \"total\" needs either an expression or a resolver\nentities.yaml:5: \"is-paid\" is not a valid GraphQL name",
+		},
+		{
+			"entities:\n  payments:\n    computed_fields:\n      refundable:\n        resolver: payments.refundable\n",
+			`entities.yaml:5: computed field "refundable" has unknown resolver "payments.refundable"`,
+		},
+		{
+			"entities:\n  payments:\n    row_filters: {merchant: {merchant_id: m1}}\n    column_names: {card_number: card}\n    computed_fields:\n" +
+				"      merchant_id: {expression: \"'m1'\"}\n      card: {expression: \"{card_number}\"}\n",
+			"entities.yaml:6: computed field \"merchant_id\" is named like a column\nentities.yaml:7: computed field \"card\" is named like the field of column \"card_number\"",
+		},
+		{
+			"entities:\n  payments:\n    computed_fields:\n      payment_id: {expression: \"0\"}\n" +
+				"  refunds:\n    relations:\n      payment: {entity: payments, column: payment_id, related_column: payment_id}\n",
+			`entities.yaml:4: computed field "payment_id" is named like a column`,
+		},
+	}
+	for _, test := range tests {
+		_, _, err := ParseEntities("entities.yaml", []byte(test.content), lookupEnv)
+		assert.EqualError(t, err, test.err, test.content)
+	}
+}
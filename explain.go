@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"sync"
+)
+
+// Explain - Statements the operation of request runs, in the order they run. The operation runs
+// on data sources returning no rows, so statements of relations, which depend on the rows of
+// their parents, are not part of it.
+func (e *Engine) Explain(ctx context.Context, request *GraphQLRequest) ([]*Statement, error) {
+	recorder := &statementRecorder{}
+
+	dataSources := map[string]DataSource{}
+	for name, dataSource := range e.dataSources {
+		dataSources[name] = &recordingDataSource{DataSource: dataSource, recorder: recorder}
+	}
+	explained := NewEngine(dataSources)
+	explained.MaxAllowedPacket = e.MaxAllowedPacket
+
+	result := explained.Execute(WithPrimaryRead(ctx), request)
+	if len(result.Errors) > 0 {
+		return nil, errors.New(result.Errors[0].Message)
+	}
+
+	return recorder.statements, nil
+}
+
+type statementRecorder struct {
+	mu         sync.Mutex
+	statements []*Statement
+}
+
+func (r *statementRecorder) record(statement *Statement) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.statements = append(r.statements, statement)
+}
+
+// recordingDataSource - DataSource recording the statements run on it instead of running them,
+// table schemas are read from the wrapped data source
+type recordingDataSource struct {
+	DataSource
+	recorder *statementRecorder
+}
+
+func (d *recordingDataSource) FetchScan(ctx context.Context, statement *Statement, limit int, schema TableSchema) (*Result, error) {
+	d.recorder.record(statement)
+	return &Result{}, nil
+}
+
+func (d *recordingDataSource) Stream(ctx context.Context, statement *Statement, limit int, schema TableSchema) (RowIterator, error) {
+	d.recorder.record(statement)
+	return &sliceRowIterator{}, nil
+}
+
+func (d *recordingDataSource) Exec(ctx context.Context, statement *Statement) (sql.Result, error) {
+	return nil, errors.New("statements modifying data are not explained")
+}
+
+// Close - The wrapped data source stays open, it is closed by its own engine
+func (d *recordingDataSource) Close() error {
+	return nil
+}
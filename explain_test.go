@@ -0,0 +1,34 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEngine_Explain(t *testing.T) {
+	Entities["explain_test"] = EntityConfig{TableName: "explain_test"}
+	defer delete(Entities, "explain_test")
+
+	dataSource := &fakeDataSource{
+		schema: TableSchema{"id": "int", "status": "varchar(10)"},
+		rows:   []map[string]interface{}{{"id": int64(1), "status": "pending"}},
+	}
+	engine := NewEngine(map[string]DataSource{DefaultDataSource: dataSource})
+
+	statements, err := engine.Explain(context.Background(), &GraphQLRequest{
+		Query:     `query($status: String) { explain_test(where: {status: {_eq: $status}}, limit: 5) { id } }`,
+		Variables: map[string]interface{}{"status": "pending"},
+	})
+	assert.Nil(t, err)
+	assert.Len(t, statements, 1)
+	assert.Equal(t, &Statement{
+		SQL:  "SELECT /*+ MAX_EXECUTION_TIME(30000) */ `id` FROM `explain_test` WHERE `status` = ? LIMIT ? OFFSET ?;",
+		Args: []interface{}{"pending", 5, 0},
+	}, statements[0])
+	assert.Empty(t, dataSource.queries)
+
+	_, err = engine.Explain(context.Background(), &GraphQLRequest{Query: `{ explain_test { unknown } }`})
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,583 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/language/ast"
+	"github.com/graphql-go/graphql/language/parser"
+	"github.com/graphql-go/graphql/language/source"
+	"github.com/xitongsys/parquet-go/writer"
+)
+
+// Export formats
+const (
+	FormatNDJSON  = "ndjson"
+	FormatCSV     = "csv"
+	FormatParquet = "parquet"
+)
+
+var exportContentTypes = map[string]string{
+	FormatNDJSON:  "application/x-ndjson",
+	FormatCSV:     "text/csv; charset=utf-8",
+	FormatParquet: "application/vnd.apache.parquet",
+}
+
+// DefaultExportBatchSize - rows fetched per keyset page of an export
+const DefaultExportBatchSize = 1000
+
+// parquetRowGroupSize - bytes buffered before a parquet row group is flushed to the client
+const parquetRowGroupSize = 16 * 1024 * 1024
+
+// ExportRequest - query with a single entity field to export, limit on the field caps the rows
+// exported and is not bound by the maximum limit of the entity, offset is not supported
+type ExportRequest struct {
+	Query  string `json:"query"`
+	Format string `json:"format"`
+}
+
+// ExportHandler - Stream the rows of an entity query as NDJSON, CSV or Parquet. The format is
+// taken from the format parameter or the Accept header, NDJSON by default.
+func ExportHandler(engine *Engine) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var request ExportRequest
+		if r.Method == http.MethodPost {
+			if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+				writeExportError(w, http.StatusBadRequest, err)
+				return
+			}
+		} else {
+			request.Query = r.URL.Query().Get("query")
+			request.Format = r.URL.Query().Get("format")
+		}
+		if request.Format == "" {
+			request.Format = exportFormatFromAccept(r.Header.Get("Accept"))
+		}
+
+		contentType, ok := exportContentTypes[request.Format]
+		if !ok {
+			writeExportError(w, http.StatusBadRequest, fmt.Errorf("unsupported export format %q", request.Format))
+			return
+		}
+
+		export, err := engine.PrepareExport(r.Context(), request.Query)
+		if err != nil {
+			writeExportError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", contentType)
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", export.entity+"."+request.Format))
+
+		if err := export.Write(r.Context(), request.Format, w); err != nil {
+			// Headers are gone already, aborting the response tells the client the export is incomplete
+			log.Printf("export of %s failed, error: %v", export.entity, err)
+			panic(http.ErrAbortHandler)
+		}
+	})
+}
+
+func exportFormatFromAccept(accept string) string {
+	for format, contentType := range exportContentTypes {
+		if strings.Contains(accept, strings.Split(contentType, ";")[0]) {
+			return format
+		}
+	}
+
+	return FormatNDJSON
+}
+
+func writeExportError(w http.ResponseWriter, status int, err error) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(errorResult(err))
+}
+
+// Export - validated export of an entity query, paginated by keyset seeks on the primary key
+type Export struct {
+	engine      *Engine
+	entity      string
+	dataSource  DataSource
+	tableSchema TableSchema
+	session     *Session
+	filter      map[string]interface{}
+	rowFilter   map[string]interface{}
+	columns     []string
+	// fields - names of the fields of columns, the names the rows are written with
+	fields     []string
+	primaryKey []string
+	limit      int
+}
+
+// PrepareExport - Validate the export query against the generated schema and the permissions of
+// the caller, no rows are fetched yet
+func (e *Engine) PrepareExport(ctx context.Context, query string) (*Export, error) {
+	document, err := parser.Parse(parser.ParseParams{Source: source.NewSource(&source.Source{
+		Body: []byte(query),
+		Name: "export",
+	})})
+	if err != nil {
+		return nil, err
+	}
+
+	field, err := exportField(document)
+	if err != nil {
+		return nil, err
+	}
+
+	entity, err := entityOf(field.Name.Value)
+	if err != nil {
+		return nil, err
+	}
+	export := &Export{engine: e, entity: entity, session: SessionFromContext(ctx)}
+
+	export.dataSource, err = e.DataSource(entity)
+	if err != nil {
+		return nil, err
+	}
+
+	export.tableSchema, err = e.entitySchema(ctx, entity)
+	if err != nil {
+		return nil, err
+	}
+
+	filters := filterableFields(entity, export.session.Role, export.tableSchema)
+	graphqlSchema, err := e.GenerateSchema(entity, export.tableSchema, filters)
+	if err != nil {
+		return nil, err
+	}
+	if result := graphql.ValidateDocument(graphqlSchema, document, nil); !result.IsValid {
+		return nil, result.Errors[0]
+	}
+
+	export.rowFilter, err = RowFilter(entity, export.session)
+	if err != nil {
+		return nil, err
+	}
+
+	arguments := FieldArguments(field, nil)
+	if _, ok := arguments["order_by"]; ok {
+		return nil, errors.New("order_by is not supported, exports are ordered by primary key")
+	}
+	if _, ok := arguments["offset"]; ok {
+		return nil, errors.New("offset is not supported, exports are paginated by primary key")
+	}
+	if value, ok := arguments["where"].(map[string]interface{}); ok {
+		export.filter = value
+	}
+	queryArguments := QueryArguments{Filter: export.filter, Fields: FieldProjection(field)}
+	if err := ValidateQuery(entity, export.session.Role, export.tableSchema, queryArguments); err != nil {
+		return nil, err
+	}
+	export.fields = queryArguments.Fields
+	queryArguments = columnArguments(entity, export.tableSchema, queryArguments)
+	export.filter, export.columns = queryArguments.Filter, queryArguments.Fields
+	if value, ok := arguments["limit"].(int64); ok {
+		export.limit = int(value)
+	}
+	if err := CheckPagination(export.limit, 0); err != nil {
+		return nil, err
+	}
+
+	export.primaryKey, err = export.dataSource.GetPrimaryKey(ctx, Entities.GetTableName(entity))
+	if err != nil {
+		return nil, err
+	}
+	if len(export.primaryKey) == 0 {
+		return nil, fmt.Errorf("%q has no primary key to paginate the export by", entity)
+	}
+
+	return export, nil
+}
+
+// exportField - The single entity field of the only operation in document
+func exportField(document *ast.Document) (*ast.Field, error) {
+	var fields []*ast.Field
+	for _, definition := range document.Definitions {
+		if operation, ok := definition.(*ast.OperationDefinition); ok {
+			for _, selection := range operation.GetSelectionSet().Selections {
+				if field, ok := selection.(*ast.Field); ok {
+					fields = append(fields, field)
+				}
+			}
+		}
+	}
+
+	if len(fields) != 1 {
+		return nil, errors.New("export query must select exactly one entity")
+	}
+
+	return fields[0], nil
+}
+
+// Write - Fetch all rows page by page and write them to w in format
+func (x *Export) Write(ctx context.Context, format string, w io.Writer) error {
+	computed := Entities.GetComputedFields(x.entity)
+	fieldSchema := make(TableSchema, len(x.fields))
+	for idx, name := range x.fields {
+		fieldSchema[name] = x.tableSchema[x.columns[idx]]
+		if field, ok := computed[x.columns[idx]]; ok {
+			fieldSchema[name] = field.ColumnType()
+		}
+		// Masks turn values into text whatever the type of their column
+		if IsMasked(x.entity, x.columns[idx], x.session.Role) {
+			fieldSchema[name] = "varchar"
+		}
+	}
+	rowWriter, err := newRowWriter(format, w, x.fields, fieldSchema)
+	if err != nil {
+		return err
+	}
+
+	// Rows are written with the names of the fields the export selected
+	err = x.each(ctx, func(row map[string]interface{}) error {
+		named := make(map[string]interface{}, len(x.fields))
+		for idx, name := range x.fields {
+			if _, ok := x.tableSchema[x.columns[idx]]; ok {
+				named[name] = row[x.columns[idx]]
+				continue
+			}
+			value, err := computedValue(x.entity, x.columns[idx], row)
+			if err != nil {
+				return err
+			}
+			named[name] = value
+		}
+		return rowWriter.WriteRow(named)
+	})
+	if closeErr := rowWriter.Close(); err == nil {
+		err = closeErr
+	}
+
+	return err
+}
+
+// each - Call fn with each exported row, rows are fetched in pages seeking past the primary key of
+// the last row of the previous page so that late pages cost the same as the first
+func (x *Export) each(ctx context.Context, fn func(row map[string]interface{}) error) error {
+	projection := columnProjection(x.entity, x.columns, x.tableSchema)
+	for _, column := range x.primaryKey {
+		if !contains(projection, column) {
+			projection = append(projection, column)
+		}
+	}
+
+	sortOrder := make([]map[string]interface{}, len(x.primaryKey))
+	for idx, column := range x.primaryKey {
+		sortOrder[idx] = map[string]interface{}{column: "ASC"}
+	}
+
+	batchSize := x.engine.ExportBatchSize
+	if batchSize <= 0 {
+		batchSize = DefaultExportBatchSize
+	}
+	timeout := Entities.GetStatementTimeout(x.entity)
+
+	var (
+		after    []interface{}
+		exported int
+	)
+	for {
+		pageSize := batchSize
+		if x.limit > 0 && x.limit-exported < pageSize {
+			pageSize = x.limit - exported
+		}
+		if pageSize <= 0 {
+			return nil
+		}
+
+		query, err := NewSelectDefinition(Entities.GetTableName(x.entity)).
+			WithExpressions(Entities.GetExpressions(x.entity)).
+			WithFilters(x.filter).
+			WithPredicate(x.rowFilter).
+			WithSeek(x.primaryKey, after).
+			WithProjections(projection).
+			WithSortCriteria(sortOrder).
+			WithPagination(0, pageSize).
+			WithTimeout(timeout).Build()
+		if err != nil {
+			return err
+		}
+
+		fetched, err := x.page(ctx, query.(*Statement), pageSize, timeout, func(row map[string]interface{}) error {
+			after = make([]interface{}, len(x.primaryKey))
+			for idx, column := range x.primaryKey {
+				after[idx] = row[column]
+			}
+
+			MaskRows(x.entity, x.session.Role, []map[string]interface{}{row})
+			return fn(row)
+		})
+		if err != nil {
+			return err
+		}
+
+		exported += fetched
+		if fetched < pageSize {
+			return nil
+		}
+	}
+}
+
+func (x *Export) page(ctx context.Context, statement *Statement, limit int, timeout time.Duration, fn func(row map[string]interface{}) error) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	iterator, err := x.dataSource.Stream(ctx, statement, limit, computedSchema(x.entity, x.tableSchema))
+	if err != nil {
+		return 0, err
+	}
+	defer iterator.Close()
+
+	fetched := 0
+	for iterator.Next() {
+		if err := fn(iterator.Row()); err != nil {
+			return fetched, err
+		}
+		fetched++
+	}
+
+	return fetched, iterator.Err()
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
+
+// RowWriter - writes rows in an export format, Close flushes what is buffered
+type RowWriter interface {
+	WriteRow(row map[string]interface{}) error
+	Close() error
+}
+
+func newRowWriter(format string, w io.Writer, columns []string, schema TableSchema) (RowWriter, error) {
+	switch format {
+	case FormatNDJSON:
+		return &ndjsonWriter{columns: columns, encoder: json.NewEncoder(w)}, nil
+	case FormatCSV:
+		return newCSVRowWriter(w, columns)
+	case FormatParquet:
+		return newParquetRowWriter(w, columns, schema)
+	}
+
+	return nil, fmt.Errorf("unsupported export format %q", format)
+}
+
+type ndjsonWriter struct {
+	columns []string
+	encoder *json.Encoder
+}
+
+func (n *ndjsonWriter) WriteRow(row map[string]interface{}) error {
+	projected := make(map[string]interface{}, len(n.columns))
+	for _, column := range n.columns {
+		projected[column] = row[column]
+	}
+
+	return n.encoder.Encode(projected)
+}
+
+func (n *ndjsonWriter) Close() error {
+	return nil
+}
+
+// csvRowWriter - RFC 4180 CSV with a header line, NULL is written as an empty field
+type csvRowWriter struct {
+	columns []string
+	writer  *csv.Writer
+	record  []string
+}
+
+func newCSVRowWriter(w io.Writer, columns []string) (*csvRowWriter, error) {
+	c := &csvRowWriter{columns: columns, writer: csv.NewWriter(w), record: make([]string, len(columns))}
+	c.writer.UseCRLF = true
+
+	return c, c.writer.Write(columns)
+}
+
+func (c *csvRowWriter) WriteRow(row map[string]interface{}) error {
+	for idx, column := range c.columns {
+		text, err := exportText(row[column])
+		if err != nil {
+			return err
+		}
+		c.record[idx] = text
+	}
+
+	return c.writer.Write(c.record)
+}
+
+func (c *csvRowWriter) Close() error {
+	c.writer.Flush()
+	return c.writer.Error()
+}
+
+// exportText - Text representation of a value in text based export formats
+func exportText(value interface{}) (string, error) {
+	switch value := value.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return value, nil
+	case []byte:
+		return string(value), nil
+	case time.Time:
+		return value.Format(time.RFC3339Nano), nil
+	case map[string]interface{}, []interface{}:
+		encoded, err := json.Marshal(value)
+		return string(encoded), err
+	}
+
+	return fmt.Sprint(value), nil
+}
+
+// parquetRowWriter - Parquet file with a column per projected field typed by the table schema,
+// rows are buffered up to a row group before they are written
+type parquetRowWriter struct {
+	columns []string
+	kinds   []string
+	writer  *writer.CSVWriter
+}
+
+// Parquet column kinds
+const (
+	parquetInt64     = "INT64"
+	parquetUint64    = "UINT64"
+	parquetDouble    = "DOUBLE"
+	parquetBoolean   = "BOOLEAN"
+	parquetTimestamp = "TIMESTAMP"
+	parquetString    = "STRING"
+)
+
+func newParquetRowWriter(w io.Writer, columns []string, schema TableSchema) (*parquetRowWriter, error) {
+	p := &parquetRowWriter{columns: columns, kinds: make([]string, len(columns))}
+
+	metadata := make([]string, len(columns))
+	for idx, column := range columns {
+		kind := parquetKind(schema[column])
+		p.kinds[idx] = kind
+
+		var parquetType string
+		switch kind {
+		case parquetInt64:
+			parquetType = "type=INT64"
+		case parquetUint64:
+			parquetType = "type=INT64, convertedtype=UINT_64"
+		case parquetDouble:
+			parquetType = "type=DOUBLE"
+		case parquetBoolean:
+			parquetType = "type=BOOLEAN"
+		case parquetTimestamp:
+			parquetType = "type=INT64, convertedtype=TIMESTAMP_MILLIS"
+		default:
+			parquetType = "type=BYTE_ARRAY, convertedtype=UTF8"
+		}
+		metadata[idx] = fmt.Sprintf("name=%s, %s, repetitiontype=OPTIONAL", column, parquetType)
+	}
+
+	var err error
+	p.writer, err = writer.NewCSVWriterFromWriter(metadata, w, 1)
+	if err != nil {
+		return nil, err
+	}
+	p.writer.RowGroupSize = parquetRowGroupSize
+
+	return p, nil
+}
+
+func parquetKind(columnType string) string {
+	switch columnDatatype(columnType) {
+	case graphql.Int:
+		return parquetInt64
+	case BigInt:
+		if isUnsignedColumn(columnType) || baseType(columnType) == "bit" {
+			return parquetUint64
+		}
+		return parquetInt64
+	case graphql.Float:
+		return parquetDouble
+	case graphql.Boolean:
+		return parquetBoolean
+	case graphql.DateTime:
+		return parquetTimestamp
+	}
+
+	return parquetString
+}
+
+func (p *parquetRowWriter) WriteRow(row map[string]interface{}) error {
+	record := make([]interface{}, len(p.columns))
+	for idx, column := range p.columns {
+		value, err := p.convert(p.kinds[idx], row[column])
+		if err != nil {
+			return fmt.Errorf("column %q: %w", column, err)
+		}
+		record[idx] = value
+	}
+
+	return p.writer.Write(record)
+}
+
+// convert - Value as the Go type the parquet writer expects for kind
+func (p *parquetRowWriter) convert(kind string, value interface{}) (interface{}, error) {
+	if value == nil {
+		return nil, nil
+	}
+
+	switch kind {
+	case parquetInt64:
+		switch value := value.(type) {
+		case int64:
+			return value, nil
+		case int:
+			return int64(value), nil
+		}
+	case parquetUint64:
+		if value, ok := value.(uint64); ok {
+			return int64(value), nil
+		}
+	case parquetDouble:
+		if value, ok := value.(float64); ok {
+			return value, nil
+		}
+	case parquetBoolean:
+		if value, ok := value.(bool); ok {
+			return value, nil
+		}
+	case parquetTimestamp:
+		if value, ok := value.(time.Time); ok {
+			return value.UnixNano() / int64(time.Millisecond), nil
+		}
+	}
+
+	// Masked values and anything without a typed representation are written as text
+	text, err := exportText(value)
+	if err != nil {
+		return nil, err
+	}
+	if kind == parquetString {
+		return text, nil
+	}
+
+	return nil, fmt.Errorf("can not write %q as %s", strconv.Quote(text), kind)
+}
+
+func (p *parquetRowWriter) Close() error {
+	return p.writer.WriteStop()
+}
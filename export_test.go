@@ -0,0 +1,89 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExport(t *testing.T) {
+	Entities["export_test"] = EntityConfig{
+		TableName: "export_test",
+		ColumnMasks: map[string]map[string]MaskRule{
+			"email": {AnyRole: {Strategy: MaskRedact}},
+			"pin":   {AnyRole: {Strategy: MaskHash}},
+		},
+	}
+	defer delete(Entities, "export_test")
+
+	created := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	newDataSource := func() *fakeDataSource {
+		return &fakeDataSource{
+			schema:     TableSchema{"id": "bigint unsigned", "name": "varchar(20)", "email": "varchar(50)", "created_at": "datetime", "active": "tinyint(1)", "pin": "int"},
+			primaryKey: []string{"id"},
+			pages: [][]map[string]interface{}{
+				{{"id": uint64(1), "name": "a,\"b\"", "email": "x@y.z", "created_at": created, "active": true, "pin": int64(1234)}, {"id": uint64(2), "name": nil, "email": "x@y.z", "created_at": created, "active": false}},
+				{{"id": uint64(3), "name": "c", "email": nil, "created_at": created, "active": true}},
+			},
+		}
+	}
+
+	query := `{ export_test(where: {active: {_eq: true}}) { id name email created_at active } }`
+	ctx := context.Background()
+
+	dataSource := newDataSource()
+	engine := NewEngine(map[string]DataSource{DefaultDataSource: dataSource})
+	engine.ExportBatchSize = 2
+
+	export, err := engine.PrepareExport(ctx, query)
+	assert.Nil(t, err)
+
+	var buf bytes.Buffer
+	assert.Nil(t, export.Write(ctx, FormatCSV, &buf))
+	assert.Equal(t, "id,name,email,created_at,active\r\n"+
+		"1,\"a,\"\"b\"\"\",[REDACTED],2020-01-02T03:04:05Z,true\r\n"+
+		"2,,[REDACTED],2020-01-02T03:04:05Z,false\r\n"+
+		"3,c,,2020-01-02T03:04:05Z,true\r\n", buf.String())
+
+	assert.Len(t, dataSource.queries, 2)
+	assert.Equal(t, "SELECT /*+ MAX_EXECUTION_TIME(30000) */ `id`, `name`, `email`, `created_at`, `active` FROM `export_test` WHERE `active` = ? ORDER BY `id` ASC LIMIT ? OFFSET ?;", dataSource.queries[0].SQL)
+	assert.Equal(t, []interface{}{true, 2, 0}, dataSource.queries[0].Args)
+	assert.Contains(t, dataSource.queries[1].SQL, "WHERE (`active` = ?) AND (`id` > ?) ORDER BY `id` ASC")
+	assert.Equal(t, []interface{}{true, uint64(2), 2, 0}, dataSource.queries[1].Args)
+
+	buf.Reset()
+	engine.dataSources[DefaultDataSource] = newDataSource()
+	export, err = engine.PrepareExport(ctx, query)
+	assert.Nil(t, err)
+	assert.Nil(t, export.Write(ctx, FormatNDJSON, &buf))
+	assert.Equal(t, 3, bytes.Count(buf.Bytes(), []byte("\n")))
+
+	// Masked values of typed columns are written as text
+	dataSource = newDataSource()
+	engine.dataSources[DefaultDataSource] = dataSource
+	export, err = engine.PrepareExport(ctx, `{ export_test { id created_at pin } }`)
+	assert.Nil(t, err)
+
+	buf.Reset()
+	assert.Nil(t, export.Write(ctx, FormatParquet, &buf))
+	assert.True(t, bytes.HasPrefix(buf.Bytes(), []byte("PAR1")))
+	assert.True(t, bytes.HasSuffix(buf.Bytes(), []byte("PAR1")))
+
+	_, err = engine.PrepareExport(ctx, `{ export_test { id } other: export_test { id } }`)
+	assert.NotNil(t, err)
+
+	_, err = engine.PrepareExport(ctx, `{ export_test { id unknown } }`)
+	assert.NotNil(t, err)
+
+	_, err = engine.PrepareExport(ctx, `{ export_test(where: {email: {_eq: "x@y.z"}}) { id } }`)
+	assert.NotNil(t, err)
+
+	// Exports are paginated by primary key, not by offset
+	_, err = engine.PrepareExport(ctx, `{ export_test(offset: 10) { id } }`)
+	assert.EqualError(t, err, "offset is not supported, exports are paginated by primary key")
+	_, err = engine.PrepareExport(ctx, `{ export_test(limit: -1) { id } }`)
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"embed"
+	"html/template"
+	"io/fs"
+	"net/http"
+	"strings"
+)
+
+// The React, ReactDOM and GraphiQL bundles are vendored into graphiql in the versions pinned here,
+// go generate downloads them
+//go:generate curl -fsSL -o graphiql/react.production.min.js https://unpkg.com/react@17.0.2/umd/react.production.min.js
+//go:generate curl -fsSL -o graphiql/react-dom.production.min.js https://unpkg.com/react-dom@17.0.2/umd/react-dom.production.min.js
+//go:generate curl -fsSL -o graphiql/graphiql.min.js https://unpkg.com/graphiql@1.4.7/graphiql.min.js
+//go:generate curl -fsSL -o graphiql/graphiql.min.css https://unpkg.com/graphiql@1.4.7/graphiql.min.css
+
+// graphiqlAssets - the GraphiQL page, the script rendering it and the bundles it loads, compiled
+// into the binary so that the page is served without reaching a CDN
+//
+//go:embed graphiql
+var graphiqlAssets embed.FS
+
+var graphiqlPage = template.Must(template.ParseFS(graphiqlAssets, "graphiql/index.html"))
+
+// GraphiQLHandler - Serve GraphiQL at prefix, querying endpoint. Requests carry the headers set in
+// its headers editor, the schema in its docs is the one of the role they authenticate.
+func GraphiQLHandler(prefix, endpoint string) http.Handler {
+	prefix = strings.TrimSuffix(prefix, "/")
+	assets, _ := fs.Sub(graphiqlAssets, "graphiql")
+	files := http.StripPrefix(prefix, http.FileServer(http.FS(assets)))
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if path := strings.TrimPrefix(r.URL.Path, prefix); path != "" && path != "/" && path != "/index.html" {
+			files.ServeHTTP(w, r)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		_ = graphiqlPage.Execute(w, struct{ Base, Endpoint string }{Base: prefix, Endpoint: endpoint})
+	})
+}
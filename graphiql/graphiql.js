@@ -0,0 +1,34 @@
+(function () {
+  "use strict";
+
+  var root = document.getElementById("graphiql");
+  var endpoint = root.dataset.endpoint;
+
+  // Requests carry the headers of the header editor, so that queries and the docs built from
+  // introspection are the ones of the role they authenticate
+  function fetcher(params, options) {
+    var headers = { "Accept": "application/json", "Content-Type": "application/json" };
+    var extra = (options && options.headers) || {};
+    Object.keys(extra).forEach(function (name) {
+      headers[name] = extra[name];
+    });
+
+    return fetch(endpoint, {
+      method: "POST",
+      headers: headers,
+      body: JSON.stringify(params),
+      credentials: "same-origin"
+    }).then(function (response) {
+      return response.json();
+    });
+  }
+
+  ReactDOM.render(
+    React.createElement(GraphiQL, {
+      fetcher: fetcher,
+      headerEditorEnabled: true,
+      shouldPersistHeaders: true
+    }),
+    root
+  );
+})();
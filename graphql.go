@@ -1,26 +1,67 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/gqlerrors"
 	"github.com/graphql-go/graphql/language/ast"
 	"github.com/graphql-go/graphql/language/parser"
 	"github.com/graphql-go/graphql/language/source"
 	"log"
 	"strconv"
+	"time"
 )
 
 // mysqlDatatype - mysql datatype to graphql scalar
 var mysqlDatatype = map[string]graphql.Output{
-	"tinyint": graphql.Int,
-	"bigint":  graphql.Int,
-	"int":     graphql.Int,
-	"varchar": graphql.String,
-	"char":    graphql.String,
-	"text":    graphql.String,
+	"tinyint":    graphql.Int,
+	"smallint":   graphql.Int,
+	"mediumint":  graphql.Int,
+	"int":        graphql.Int,
+	"year":       graphql.Int,
+	"bigint":     BigInt,
+	"bit":        BigInt,
+	"decimal":    Decimal,
+	"float":      graphql.Float,
+	"double":     graphql.Float,
+	"varchar":    graphql.String,
+	"char":       graphql.String,
+	"text":       graphql.String,
+	"tinytext":   graphql.String,
+	"mediumtext": graphql.String,
+	"longtext":   graphql.String,
+	"enum":       graphql.String,
+	"set":        graphql.String,
+	"time":       graphql.String,
+	"date":       graphql.DateTime,
+	"datetime":   graphql.DateTime,
+	"timestamp":  graphql.DateTime,
+	"json":       JSON,
 }
 
+// columnDatatype - graphql scalar of a column type as introspected, eg tinyint(1) or bigint unsigned
+func columnDatatype(columnType string) graphql.Output {
+	if isBoolColumn(columnType) {
+		return graphql.Boolean
+	}
+	// Int is 32 bit signed, int unsigned goes up to 2^32-1
+	if baseType(columnType) == "int" && isUnsignedColumn(columnType) {
+		return BigInt
+	}
+	if datatype, ok := mysqlDatatype[baseType(columnType)]; ok {
+		return datatype
+	}
+
+	// Binary and spatial types have no better representation
+	return graphql.String
+}
+
+// DefaultLimit - rows returned when the query has no limit argument
+const DefaultLimit = 100
+
 var DefaultArgs = map[string]*graphql.ArgumentConfig{
 	"offset": {
 		Type:         graphql.Int,
@@ -29,32 +70,32 @@ var DefaultArgs = map[string]*graphql.ArgumentConfig{
 	},
 	"limit": {
 		Type:         graphql.Int,
-		DefaultValue: 100,
+		DefaultValue: DefaultLimit,
 		Description:  "Limit no of rows returned by some value",
 	},
 }
 
+// primaryDirective - @primary on the query or an entity field reads from the primary instead of
+// a replica, for read-your-writes consistency
+var primaryDirective = graphql.NewDirective(graphql.DirectiveConfig{
+	Name:        PrimaryDirective,
+	Description: "Read from the primary database instead of a replica",
+	Locations:   []string{graphql.DirectiveLocationQuery, graphql.DirectiveLocationField},
+})
+
 var supportedComparisonOps = []string{
 	"_gt", "_lt", "_gte", "_lte", "_in", "_eq",
 }
 
-func GenerateSchema(tableName string, tableSchema TableSchema, filters []string) (*graphql.Schema, error) {
-	// Object Type
-	objectType := graphql.ObjectConfig{
-		Name:   tableName,
-		Fields: graphql.Fields{},
-	}
+func (e *Engine) GenerateSchema(entity string, tableSchema TableSchema, filters []string) (*graphql.Schema, error) {
+	return e.generateSchema(entity, map[string]TableSchema{entity: tableSchema}, filters, AnyRole)
+}
 
-	// SelectionList
-	//
-	// Iterate over MySQL field type and generate GraphQL fields
-	fields := graphql.Fields{}
-	for fieldName, fieldType := range tableSchema {
-		fields[fieldName] = &graphql.Field{
-			Type: mysqlDatatype[fieldType],
-		}
-	}
-	objectType.Fields = fields
+// generateSchema - Schema querying entity as seen by role, the entities related to it are
+// reachable through its relations when their table schemas are in schemas
+func (e *Engine) generateSchema(entity string, schemas map[string]TableSchema, filters []string, role string) (*graphql.Schema, error) {
+	tableSchema := schemas[entity]
+	objectTypes := e.objectTypes(schemas, role)
 
 	// Arguments
 	//
@@ -64,78 +105,88 @@ func GenerateSchema(tableName string, tableSchema TableSchema, filters []string)
 		args[field] = arg
 	}
 
+	// Columns are named by their fields in the arguments, expression fields are used like columns
+	fieldTypes := argumentTypes(entity, tableSchema)
+	// If no field is specified then create filter on all fields
+	if filters == nil {
+		for name := range fieldTypes {
+			filters = append(filters, name)
+		}
+	}
+
 	filterFields := graphql.Fields{}
 	// Iterate over allowed filters and generate GraphQL filters
 	for _, filterField := range filters {
-		if fieldType, ok := tableSchema[filterField]; ok {
-			field := graphql.Field{}
-			fieldArgs := graphql.FieldConfigArgument{}
-			for _, op := range supportedComparisonOps {
-				fieldArgs[op] = &graphql.ArgumentConfig{
-					Type: mysqlDatatype[fieldType],
-				}
-			}
-			field.Type = graphql.String
-			field.Args = fieldArgs
-			filterFields[filterField] = &field
+		fieldType, ok := fieldTypes[filterField]
+		if !ok {
+			continue
 		}
-	}
-
-	// If no field is specified then create filter on all fields
-	if len(filters) == 0 {
-		for filterField, fieldType := range tableSchema {
-			field := graphql.Field{}
-			fieldArgs := graphql.FieldConfigArgument{}
-			for _, op := range supportedComparisonOps {
-				fieldArgs[op] = &graphql.ArgumentConfig{
-					Type: mysqlDatatype[fieldType],
-				}
+		field := graphql.Field{}
+		fieldArgs := graphql.FieldConfigArgument{}
+		for _, op := range supportedComparisonOps {
+			fieldArgs[op] = &graphql.ArgumentConfig{
+				Type: columnDatatype(fieldType),
 			}
-			field.Type = graphql.String
-			field.Args = fieldArgs
-			filterFields[filterField] = &field
 		}
+		field.Type = graphql.String
+		field.Args = fieldArgs
+		filterFields[filterField] = &field
 	}
 
-	// Where field arguments
-	args["where"] = &graphql.ArgumentConfig{
-		Type: graphql.NewObject(graphql.ObjectConfig{
-			Name:        "where",
-			Description: "where condition",
-			Fields:      filterFields,
-		}),
+	// Where field arguments, the type is named after the entity as it would collide with the
+	// where types of the related entities otherwise
+	if len(filterFields) > 0 {
+		args["where"] = &graphql.ArgumentConfig{
+			Type: graphql.NewObject(graphql.ObjectConfig{
+				Name:        Entities.GetInputTypeName(entity, "where"),
+				Description: "where condition",
+				Fields:      filterFields,
+			}),
+		}
 	}
 
 	// Iterate over MySQL fields and generate GraphQL argument field for order_by
 	orderFields := graphql.Fields{}
-	for fieldName, fieldType := range tableSchema {
+	for fieldName, fieldType := range fieldTypes {
 		orderFields[fieldName] = &graphql.Field{
-			Type: mysqlDatatype[fieldType],
+			Type: columnDatatype(fieldType),
 		}
 	}
 
 	args["order_by"] = &graphql.ArgumentConfig{
 		Type: graphql.NewList(graphql.NewObject(graphql.ObjectConfig{
-			Name:   "order_by",
+			Name:   Entities.GetInputTypeName(entity, "order_by"),
 			Fields: orderFields,
 		})),
 	}
+	entityField := &graphql.Field{
+		Type:    graphql.NewList(objectTypes[entity]),
+		Args:    args,
+		Resolve: e.Resolver(entity, tableSchema),
+	}
+	fieldName := Entities.GetGraphQLName(entity)
+
 	// Create query object
 	var queryType = graphql.NewObject(
 		graphql.ObjectConfig{
-			Name: "Query",
-			Fields: graphql.Fields{
-				tableName: &graphql.Field{
-					Type:    graphql.NewList(graphql.NewObject(objectType)),
-					Args:    args,
-					Resolve: DefaultResolverFn,
-				},
-			},
+			Name:   "Query",
+			Fields: graphql.Fields{fieldName: entityField},
 		})
 
+	// Subscriptions run the same field again whenever the rows of the entity change
+	var subscriptionType *graphql.Object
+	if e.ChangeFeed != nil {
+		subscriptionType = graphql.NewObject(graphql.ObjectConfig{
+			Name:   "Subscription",
+			Fields: graphql.Fields{fieldName: entityField},
+		})
+	}
+
 	var schema, err = graphql.NewSchema(
 		graphql.SchemaConfig{
-			Query: queryType,
+			Query:        queryType,
+			Subscription: subscriptionType,
+			Directives:   append(graphql.SpecifiedDirectives, primaryDirective),
 		},
 	)
 
@@ -146,19 +197,36 @@ func GenerateSchema(tableName string, tableSchema TableSchema, filters []string)
 	return &schema, nil
 }
 
-func DefaultResolverFn(params graphql.ResolveParams) (interface{}, error) {
+// Resolver - Resolve an entity field by fetching its rows from the data source of the entity
+func (e *Engine) Resolver(entity string, tableSchema TableSchema) graphql.FieldResolveFn {
+	return func(params graphql.ResolveParams) (interface{}, error) {
+		return e.resolve(params, entity, tableSchema)
+	}
+}
+
+func (e *Engine) resolve(params graphql.ResolveParams, entity string, tableSchema TableSchema) (interface{}, error) {
 	// Use (GraphQL AST) to create (RQL) -> (generated SQL).
 	arguments := GetArguments(params)
-	projection := GetProjection(params)
 
-	selectDef := NewSelectDefinition("payments")
+	selectDef := NewSelectDefinition(Entities.GetTableName(entity)).WithExpressions(Entities.GetExpressions(entity))
+
+	dataSource, err := e.DataSource(entity)
+	if err != nil {
+		return nil, err
+	}
+
+	session := SessionFromContext(params.Context)
+	rowFilter, err := RowFilter(entity, session)
+	if err != nil {
+		return nil, err
+	}
 
 	var filter map[string]interface{}
 	if value, ok := arguments["where"].(map[string]interface{}); ok {
 		filter = value
 	}
 
-	limit := 100
+	limit := EntityLimit(entity, DefaultLimit)
 	if value, ok := arguments["limit"].(int64); ok {
 		limit = int(value)
 	}
@@ -167,77 +235,285 @@ func DefaultResolverFn(params graphql.ResolveParams) (interface{}, error) {
 	if value, ok := arguments["offset"].(int64); ok {
 		offset = int(value)
 	}
+	if err := CheckPagination(limit, offset); err != nil {
+		return nil, err
+	}
 
 	var orderBy []map[string]interface{}
 	if value, ok := arguments["order_by"].([]map[string]interface{}); ok {
 		orderBy = value
 	}
 
+	queryArguments := QueryArguments{Filter: filter, OrderBy: orderBy, Fields: GetProjection(params)}
+	if err := ValidateQuery(entity, session.Role, tableSchema, queryArguments); err != nil {
+		return nil, err
+	}
+	queryArguments = columnArguments(entity, tableSchema, queryArguments)
+	filter, orderBy = queryArguments.Filter, queryArguments.OrderBy
+	if orderBy == nil {
+		orderBy = Entities.GetDefaultOrder(entity)
+	}
+	projection := columnProjection(entity, queryArguments.Fields, tableSchema)
+
+	timeout := Entities.GetStatementTimeout(entity)
 	generatedSQLQuery, err := selectDef.
 		WithFilters(filter).
+		WithPredicate(rowFilter).
 		WithPagination(offset, limit).
 		WithProjections(projection).
-		WithSortCriteria(orderBy).Build()
+		WithSortCriteria(orderBy).
+		WithTimeout(timeout).Build()
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(params.Context, timeout)
+	defer cancel()
 
-	result, err := mysql.FetchScan(generatedSQLQuery.(string), limit)
+	result, err := dataSource.FetchScan(ctx, generatedSQLQuery.(*Statement), limit, computedSchema(entity, tableSchema))
 	if err != nil {
 		return nil, err
 	}
+	keepRelationKeys(entity, result.Rows)
+	MaskRows(entity, session.Role, result.Rows)
 
 	return result.Rows, nil
 }
 
-func Query(query string) (interface{}, error) {
+// Query - Execute the GraphQL query, the session attached to ctx decides what the caller can see
+func (e *Engine) Query(ctx context.Context, query string) *graphql.Result {
+	return e.Execute(ctx, &GraphQLRequest{Query: query})
+}
+
+// Execute - Execute the operation of request with its variables, see Query
+func (e *Engine) Execute(ctx context.Context, request *GraphQLRequest) *graphql.Result {
+	ctx, op, err := e.parseOperation(ctx, request)
+	if err != nil {
+		return errorResult(err)
+	}
+	if op.kind == ast.OperationTypeSubscription {
+		return errorResult(errors.New("subscriptions are served over WebSocket or server-sent events"))
+	}
+	if op.entity == "" {
+		graphqlSchema, err := e.APISchema(ctx)
+		if err != nil {
+			return errorResult(err)
+		}
+		return e.run(ctx, graphqlSchema, op)
+	}
+
+	// Reads from the primary ask for fresh data and skip the cache
+	var (
+		cacheKey string
+		cacheTTL time.Duration
+		session  = SessionFromContext(ctx)
+	)
+	if e.Cache != nil && !isPrimaryRead(ctx) {
+		if ttl, ok := cachePolicy(op.entities); ok {
+			key, err := resultCacheKey(op.document, request.OperationName, request.Variables, session)
+			if err != nil {
+				return errorResult(err)
+			}
+			if cached, ok := e.cachedResult(key); ok {
+				return cached
+			}
+			cacheKey, cacheTTL = key, ttl
+		}
+	}
+
+	graphqlSchema, err := e.operationSchema(ctx, op)
+	if err != nil {
+		return errorResult(err)
+	}
+
+	result := e.run(ctx, graphqlSchema, op)
+	if cacheKey != "" {
+		e.cacheResult(cacheKey, result, cacheTTL, op.entities)
+	}
+
+	return result
+}
+
+// operation - Operation of a request checked against the limits of the caller, ready to run.
+// Introspection operations have no entity.
+type operation struct {
+	// document - the operation and the fragments it spreads
+	document  *ast.Document
+	kind      string
+	entity    string
+	field     *ast.Field
+	variables map[string]interface{}
+	// entities - the entity and the entities reached through its relations
+	entities []string
+}
+
+// parseOperation - Parse the operation of request, ctx is returned with the read preference of
+// the operation
+func (e *Engine) parseOperation(ctx context.Context, request *GraphQLRequest) (context.Context, *operation, error) {
 	syntaxTree, err := parser.Parse(parser.ParseParams{Source: source.NewSource(&source.Source{
-		Body: []byte(query),
+		Body: []byte(request.Query),
 		Name: "request",
 	})})
 
 	if err != nil {
-		return nil, err
+		return ctx, nil, err
 	}
 
-	entity := getEntityName(syntaxTree)
-	if entity == "" {
-		return nil, errors.New("no entity in query")
+	// The schema only has the entity of the operation run, other operations would fail validation
+	document := operationDocument(syntaxTree, request.OperationName)
+	if document == nil {
+		return ctx, nil, fmt.Errorf("unknown operation named %q", request.OperationName)
 	}
 
-	tableSchema, err := mysql.GetTableSchema(entity)
+	if isIntrospection(document) {
+		return ctx, &operation{
+			document:  document,
+			kind:      document.Definitions[0].(*ast.OperationDefinition).Operation,
+			variables: request.Variables,
+		}, nil
+	}
+
+	entityField := getEntityField(document, "")
+	if entityField == nil {
+		return ctx, nil, errors.New("no entity in query")
+	}
+	entity, err := entityOf(entityField.Name.Value)
 	if err != nil {
-		log.Fatalf("failed to get table schema, error: %v", err)
+		return ctx, nil, err
+	}
+
+	if hasPrimaryDirective(document) {
+		ctx = WithPrimaryRead(ctx)
+	}
+
+	session := SessionFromContext(ctx)
+	if _, err := CheckQueryLimits(document, request.Variables, session.Role); err != nil {
+		return ctx, nil, err
+	}
+
+	return ctx, &operation{
+		document:  document,
+		kind:      document.Definitions[0].(*ast.OperationDefinition).Operation,
+		entity:    entity,
+		field:     entityField,
+		variables: request.Variables,
+		entities:  append([]string{entity}, relatedEntities(entity, entityField)...),
+	}, nil
+}
+
+// operationSchema - Schema of the entity of op and the entities it reaches through its relations,
+// as seen by the role of the session
+func (e *Engine) operationSchema(ctx context.Context, op *operation) (*graphql.Schema, error) {
+	tableSchema, err := e.entitySchema(ctx, op.entity)
+	if err != nil {
+		log.Printf("failed to get table schema, error: %v", err)
 		return nil, err
 	}
 
-	graphqlSchema, err := GenerateSchema(Entities.GetTableName(entity), tableSchema, Entities.GetAllowedFilters(entity))
+	schemas := map[string]TableSchema{op.entity: tableSchema}
+	if err := e.relatedSchemas(ctx, op.entity, op.field, schemas); err != nil {
+		log.Printf("failed to get table schema, error: %v", err)
+		return nil, err
+	}
+
+	role := SessionFromContext(ctx).Role
+	filters := filterableFields(op.entity, role, tableSchema)
+	graphqlSchema, err := e.generateSchema(op.entity, schemas, filters, role)
 	if err != nil {
-		log.Fatalf("failed to create new schema, error: %v", err)
+		log.Printf("failed to create new schema, error: %v", err)
 		return nil, err
 	}
 
-	params := graphql.Params{Schema: *graphqlSchema, RequestString: query}
-	result := graphql.Do(params)
+	return graphqlSchema, nil
+}
+
+// run - Validate op against graphqlSchema and execute it, every run gets loaders of its own
+// unless ctx already has some
+func (e *Engine) run(ctx context.Context, graphqlSchema *graphql.Schema, op *operation) *graphql.Result {
+	if validation := graphql.ValidateDocument(graphqlSchema, op.document, nil); !validation.IsValid {
+		return &graphql.Result{Errors: validation.Errors}
+	}
+
+	return graphql.Execute(graphql.ExecuteParams{
+		Schema:  *graphqlSchema,
+		AST:     op.document,
+		Args:    op.variables,
+		Context: WithLoaders(ctx),
+	})
+}
+
+// errorResult - GraphQL result for a request which failed before execution
+func errorResult(err error) *graphql.Result {
+	formatted := gqlerrors.FormatError(err)
+	if extended, ok := err.(gqlerrors.ExtendedError); ok {
+		formatted.Extensions = extended.Extensions()
+	}
+
+	return &graphql.Result{Errors: []gqlerrors.FormattedError{formatted}}
+}
+
+// filterableColumns - Columns the role can filter on, the allowed filters of the entity (every
+// column if none are configured) without the columns masked for the role
+func filterableColumns(entity, role string, tableSchema TableSchema) []string {
+	allowed := Entities.GetAllowedFilters(entity)
+	if allowed == nil {
+		for column := range tableSchema {
+			allowed = append(allowed, column)
+		}
+	}
+
+	filterable := []string{}
+	for _, column := range allowed {
+		if !IsMasked(entity, column, role) {
+			filterable = append(filterable, column)
+		}
+	}
 
-	if result.HasErrors() {
-		fmt.Printf("%+v\n", result.Errors)
+	return filterable
+}
+
+// filterableFields - Names of the fields the role can filter on, the fields of the filterable columns
+// and the expression fields allowed as filters which read no column masked for the role
+func filterableFields(entity, role string, tableSchema TableSchema) []string {
+	fields := columnFieldNames(entity, filterableColumns(entity, role, tableSchema), tableSchema)
+
+	allowed := Entities.GetAllowedFilters(entity)
+	for name, field := range Entities.GetComputedFields(entity) {
+		if field.Expression == "" || contains(fields, name) || !computedVisible(entity, field, role) {
+			continue
+		}
+		if allowed == nil || contains(allowed, name) {
+			fields = append(fields, name)
+		}
 	}
 
-	return result.Data, nil
+	return fields
 }
 
 func GetArguments(params graphql.ResolveParams) map[string]interface{} {
-	selection := params.Info.Operation.GetSelectionSet()
+	return FieldArguments(params.Info.FieldASTs[0], params.Info.VariableValues)
+}
+
+// FieldArguments - Arguments of field as given in the query, variables are replaced by their value
+func FieldArguments(field *ast.Field, variables map[string]interface{}) map[string]interface{} {
 	argument := map[string]interface{}{}
 
-	if firstField, ok := selection.Selections[0].(*ast.Field); ok {
-		for _, arg := range firstField.Arguments {
+	if field != nil {
+		for _, arg := range field.Arguments {
 			switch arg.Value.GetKind() {
 			case "ObjectValue":
 				if value, ok := arg.Value.(*ast.ObjectValue); ok {
-					argument[arg.Name.Value] = objectValueArg(value)
+					argument[arg.Name.Value] = objectValueArg(value, variables)
 				}
 			case "ListValue":
 				if value, ok := arg.Value.(*ast.ListValue); ok {
-					argument[arg.Name.Value] = listValueArg(value)
+					argument[arg.Name.Value] = listValueArg(value, variables)
+				}
+			case "Variable":
+				if value, ok := arg.Value.(*ast.Variable); ok {
+					if variable, ok := variables[value.Name.Value]; ok {
+						argument[arg.Name.Value] = variableArg(variable)
+					}
 				}
 			case "IntValue":
 				fallthrough
@@ -254,14 +530,14 @@ func GetArguments(params graphql.ResolveParams) map[string]interface{} {
 	return argument
 }
 
-func listValueArg(listValue *ast.ListValue) []map[string]interface{} {
+func listValueArg(listValue *ast.ListValue, variables map[string]interface{}) []map[string]interface{} {
 	var listArgument []map[string]interface{}
 
 	for _, value := range listValue.Values {
 		switch value.GetKind() {
 		case "ObjectValue":
 			if value, ok := value.(*ast.ObjectValue); ok {
-				listArgument = append(listArgument, objectValueArg(value))
+				listArgument = append(listArgument, objectValueArg(value, variables))
 			}
 		}
 	}
@@ -269,14 +545,29 @@ func listValueArg(listValue *ast.ListValue) []map[string]interface{} {
 	return listArgument
 }
 
-func objectValueArg(object *ast.ObjectValue) map[string]interface{} {
+func objectValueArg(object *ast.ObjectValue, variables map[string]interface{}) map[string]interface{} {
 	argument := map[string]interface{}{}
 
 	for _, field := range object.Fields {
 		switch field.Value.GetKind() {
 		case "ObjectValue":
 			if value, ok := field.Value.(*ast.ObjectValue); ok {
-				argument[field.Name.Value] = objectValueArg(value)
+				argument[field.Name.Value] = objectValueArg(value, variables)
+			}
+		case "ListValue":
+			// Lists inside objects are operands, eg of _in
+			if value, ok := field.Value.(*ast.ListValue); ok {
+				var values []interface{}
+				for _, item := range value.Values {
+					values = append(values, valueArg(item, variables))
+				}
+				argument[field.Name.Value] = values
+			}
+		case "Variable":
+			if value, ok := field.Value.(*ast.Variable); ok {
+				if variable, ok := variables[value.Name.Value]; ok {
+					argument[field.Name.Value] = variableArg(variable)
+				}
 			}
 		case "IntValue":
 			fallthrough
@@ -292,6 +583,56 @@ func objectValueArg(object *ast.ObjectValue) map[string]interface{} {
 	return argument
 }
 
+// valueArg - Value of a scalar or a variable
+func valueArg(value ast.Value, variables map[string]interface{}) interface{} {
+	if variable, ok := value.(*ast.Variable); ok {
+		return variableArg(variables[variable.Name.Value])
+	}
+
+	return scalarArg(value)
+}
+
+// variableArg - Value of a variable in the shape of the literal it stands for, JSON numbers which
+// are integers become int64 and lists of objects []map[string]interface{}
+func variableArg(value interface{}) interface{} {
+	switch value := value.(type) {
+	case float64:
+		if value == float64(int64(value)) {
+			return int64(value)
+		}
+	case int:
+		return int64(value)
+	case json.Number:
+		if v, err := value.Int64(); err == nil {
+			return v
+		}
+		v, _ := value.Float64()
+		return v
+	case map[string]interface{}:
+		argument := map[string]interface{}{}
+		for key, item := range value {
+			argument[key] = variableArg(item)
+		}
+		return argument
+	case []interface{}:
+		objects := make([]map[string]interface{}, 0, len(value))
+		values := make([]interface{}, 0, len(value))
+		for _, item := range value {
+			item = variableArg(item)
+			if object, ok := item.(map[string]interface{}); ok {
+				objects = append(objects, object)
+			}
+			values = append(values, item)
+		}
+		if len(value) > 0 && len(objects) == len(value) {
+			return objects
+		}
+		return values
+	}
+
+	return value
+}
+
 func scalarArg(scalar ast.Value) interface{} {
 	value := scalar.GetValue()
 
@@ -302,6 +643,10 @@ func scalarArg(scalar ast.Value) interface{} {
 	case "StringValue":
 		return value.(string)
 	case "BooleanValue":
+		// The parser already gives booleans as bool
+		if v, ok := value.(bool); ok {
+			return v
+		}
 		v, _ := strconv.ParseBool(value.(string))
 		return v
 	}
@@ -309,34 +654,136 @@ func scalarArg(scalar ast.Value) interface{} {
 	return scalar.GetValue()
 }
 
+// hasPrimaryDirective - Whether the operation or one of its root fields carries @primary
+func hasPrimaryDirective(document *ast.Document) bool {
+	for _, definition := range document.Definitions {
+		operation, ok := definition.(*ast.OperationDefinition)
+		if !ok {
+			continue
+		}
+
+		directives := operation.Directives
+		for _, selection := range operation.GetSelectionSet().Selections {
+			if field, ok := selection.(*ast.Field); ok {
+				directives = append(directives, field.Directives...)
+			}
+		}
+
+		for _, directive := range directives {
+			if directive.Name.Value == PrimaryDirective {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
 func getEntityName(document *ast.Document) string {
-	if len(document.Definitions) > 0 {
+	if field := getEntityField(document, ""); field != nil {
+		return field.Name.Value
+	}
+
+	return ""
+}
+
+// getEntityField - Field of the entity queried by the operation named operationName, or by the
+// first operation if operationName is empty, nil if there is none
+func getEntityField(document *ast.Document, operationName string) *ast.Field {
+	for _, definition := range document.Definitions {
 		// query operation
-		if firstDefinition, ok := document.Definitions[0].(*ast.OperationDefinition); ok {
-			selectionSet := firstDefinition.GetSelectionSet()
+		operation, ok := definition.(*ast.OperationDefinition)
+		if !ok {
+			continue
+		}
+		if operationName != "" && (operation.Name == nil || operation.Name.Value != operationName) {
+			continue
+		}
 
-			// selection set
-			if len(selectionSet.Selections) > 0 {
-				if firstSelection, ok := selectionSet.Selections[0].(*ast.Field); ok {
-					return firstSelection.Name.Value
+		// selection set
+		selectionSet := operation.GetSelectionSet()
+		if len(selectionSet.Selections) > 0 {
+			if firstSelection, ok := selectionSet.Selections[0].(*ast.Field); ok {
+				return firstSelection
+			}
+		}
+
+		return nil
+	}
+
+	return nil
+}
+
+// operationDocument - Document of the operation named operationName, or of the first operation if
+// operationName is empty, with only the fragments it spreads. Nil if there is no such operation.
+func operationDocument(document *ast.Document, operationName string) *ast.Document {
+	var (
+		operation *ast.OperationDefinition
+		fragments = map[string]*ast.FragmentDefinition{}
+	)
+	for _, definition := range document.Definitions {
+		switch definition := definition.(type) {
+		case *ast.OperationDefinition:
+			if operation != nil {
+				continue
+			}
+			if operationName == "" || (definition.Name != nil && definition.Name.Value == operationName) {
+				operation = definition
+			}
+		case *ast.FragmentDefinition:
+			fragments[definition.Name.Value] = definition
+		}
+	}
+
+	if operation == nil {
+		return nil
+	}
+
+	spread := map[string]bool{}
+	var walk func(set *ast.SelectionSet)
+	walk = func(set *ast.SelectionSet) {
+		if set == nil {
+			return
+		}
+		for _, selection := range set.Selections {
+			switch selection := selection.(type) {
+			case *ast.Field:
+				walk(selection.SelectionSet)
+			case *ast.InlineFragment:
+				walk(selection.SelectionSet)
+			case *ast.FragmentSpread:
+				name := selection.Name.Value
+				if fragment, ok := fragments[name]; ok && !spread[name] {
+					spread[name] = true
+					walk(fragment.SelectionSet)
 				}
 			}
 		}
 	}
+	walk(operation.SelectionSet)
 
-	return ""
+	definitions := []ast.Node{operation}
+	for _, definition := range document.Definitions {
+		if fragment, ok := definition.(*ast.FragmentDefinition); ok && spread[fragment.Name.Value] {
+			definitions = append(definitions, fragment)
+		}
+	}
+
+	return ast.NewDocument(&ast.Document{Loc: document.Loc, Definitions: definitions})
 }
 
 func GetProjection(params graphql.ResolveParams) []string {
-	selectionSet := params.Info.Operation.GetSelectionSet()
+	return FieldProjection(params.Info.FieldASTs[0])
+}
+
+// FieldProjection - Names of the fields selected on field
+func FieldProjection(field *ast.Field) []string {
 	var projection []string
 
-	for _, value := range selectionSet.Selections {
-		if selection, ok := value.(*ast.Field); ok {
-			for _, field := range selection.SelectionSet.Selections {
-				if fieldDef, ok := field.(*ast.Field); ok {
-					projection = append(projection, fieldDef.Name.Value)
-				}
+	if field != nil && field.SelectionSet != nil {
+		for _, selection := range field.SelectionSet.Selections {
+			if fieldDef, ok := selection.(*ast.Field); ok {
+				projection = append(projection, fieldDef.Name.Value)
 			}
 		}
 	}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"github.com/graphql-go/graphql"
@@ -16,10 +17,10 @@ func TestGenerateSchema(t *testing.T) {
 	mysql, err := NewMySql(defaultConf)
 	assert.Nil(t, err)
 
-	schema, err := mysql.GetTableSchema("test")
+	schema, err := mysql.GetTableSchema(context.Background(), "test")
 	assert.Nil(t, err)
 
-	graphqlSchema, err := GenerateSchema("test", schema, []string{"id"})
+	graphqlSchema, err := NewEngine(map[string]DataSource{DefaultDataSource: mysql}).GenerateSchema("test", schema, []string{"id"})
 	assert.Nil(t, err)
 
 	jsn, _ := json.MarshalIndent(graphqlSchema, "", " ")
@@ -163,5 +164,5 @@ func GenerateSQLFromGraphQL(params graphql.ResolveParams) (string, error) {
 		WithProjections(projection).
 		WithSortCriteria(orderBy).Build()
 
-	return generatedSQLQuery.(string), err
+	return generatedSQLQuery.(*Statement).SQL, err
 }
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/graphql-go/graphql"
+)
+
+const (
+	// DefaultMaxBatchSize - requests a batch may hold when no limit is configured
+	DefaultMaxBatchSize = 20
+	// DefaultBatchParallelism - requests of a batch run at once when no limit is configured
+	DefaultBatchParallelism = 4
+)
+
+// GraphQLRequest - body of a GraphQL request, or the parameters of a GET request
+type GraphQLRequest struct {
+	Query         string                 `json:"query"`
+	Variables     map[string]interface{} `json:"variables"`
+	OperationName string                 `json:"operationName"`
+	Extensions    RequestExtensions      `json:"extensions"`
+}
+
+type RequestExtensions struct {
+	PersistedQuery *PersistedQuery `json:"persistedQuery,omitempty"`
+	// OperationID - id of an operation on a single connection event stream
+	OperationID string `json:"operationId,omitempty"`
+}
+
+// HandlerConfig - options of the GraphQL handler
+type HandlerConfig struct {
+	// PersistedQueries - store of automatic persisted queries, they are not supported if nil
+	PersistedQueries ResultCache
+	// Allowlist - when set only its queries run and no queries can be persisted by clients
+	Allowlist *Allowlist
+	// MaxBatchSize - requests a batch may hold, DefaultMaxBatchSize if not positive
+	MaxBatchSize int
+	// BatchParallelism - requests of a batch run at once, DefaultBatchParallelism if not positive
+	BatchParallelism int
+	// ConnectionInitTimeout - time a WebSocket client has to initialise the connection,
+	// DefaultConnectionInitTimeout if not positive
+	ConnectionInitTimeout time.Duration
+	// HeartbeatInterval - time between two heartbeats of an event stream,
+	// DefaultHeartbeatInterval if not positive
+	HeartbeatInterval time.Duration
+	// ReconnectTimeout - time the operations of a single connection event stream are kept after
+	// the stream dropped, DefaultReconnectTimeout if not positive
+	ReconnectTimeout time.Duration
+}
+
+func (c HandlerConfig) maxBatchSize() int {
+	if c.MaxBatchSize <= 0 {
+		return DefaultMaxBatchSize
+	}
+	return c.MaxBatchSize
+}
+
+func (c HandlerConfig) batchParallelism() int {
+	if c.BatchParallelism <= 0 {
+		return DefaultBatchParallelism
+	}
+	return c.BatchParallelism
+}
+
+func (c HandlerConfig) connectionInitTimeout() time.Duration {
+	if c.ConnectionInitTimeout <= 0 {
+		return DefaultConnectionInitTimeout
+	}
+	return c.ConnectionInitTimeout
+}
+
+func (c HandlerConfig) heartbeatInterval() time.Duration {
+	if c.HeartbeatInterval <= 0 {
+		return DefaultHeartbeatInterval
+	}
+	return c.HeartbeatInterval
+}
+
+func (c HandlerConfig) reconnectTimeout() time.Duration {
+	if c.ReconnectTimeout <= 0 {
+		return DefaultReconnectTimeout
+	}
+	return c.ReconnectTimeout
+}
+
+// GraphQLHandler - Run GraphQL queries sent as JSON body or as GET parameters. A body holding a
+// JSON array is a batch, its requests run concurrently and their results are returned in the
+// same order.
+func GraphQLHandler(engine *Engine, config HandlerConfig) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		requests, batch, err := decodeGraphQLRequests(r)
+		if err == nil && batch && (len(requests) == 0 || len(requests) > config.maxBatchSize()) {
+			err = fmt.Errorf("batch must hold between 1 and %d requests, got %d", config.maxBatchSize(), len(requests))
+		}
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			_ = json.NewEncoder(w).Encode(errorResult(err))
+			return
+		}
+
+		ctx := r.Context()
+		if r.Header.Get(PrimaryHeader) == "true" {
+			ctx = WithPrimaryRead(ctx)
+		}
+
+		if !batch {
+			result, status := executeGraphQLRequest(ctx, engine, config, requests[0])
+			w.WriteHeader(status)
+			_ = json.NewEncoder(w).Encode(result)
+			return
+		}
+
+		// Relations looked up by several requests of the batch are fetched once
+		ctx = WithLoaders(ctx)
+
+		results := make([]*graphql.Result, len(requests))
+		slots := make(chan struct{}, config.batchParallelism())
+		var wg sync.WaitGroup
+		for idx, request := range requests {
+			wg.Add(1)
+			slots <- struct{}{}
+			go func(idx int, request *GraphQLRequest) {
+				defer func() {
+					<-slots
+					wg.Done()
+				}()
+				results[idx], _ = executeGraphQLRequest(ctx, engine, config, request)
+			}(idx, request)
+		}
+		wg.Wait()
+
+		_ = json.NewEncoder(w).Encode(results)
+	})
+}
+
+// executeGraphQLRequest - Result of request and the status it is answered with on its own
+func executeGraphQLRequest(ctx context.Context, engine *Engine, config HandlerConfig, request *GraphQLRequest) (*graphql.Result, int) {
+	resolved, err := resolveGraphQLRequest(request, config)
+	if err != nil {
+		status := http.StatusBadRequest
+		if persistedErr, ok := err.(*PersistedQueryError); ok {
+			status = persistedErr.Status
+		}
+		return errorResult(err), status
+	}
+
+	return engine.Execute(ctx, resolved), http.StatusOK
+}
+
+// resolveGraphQLRequest - request with the query text to run, unknown queries are rejected here
+// before their entity is introspected
+func resolveGraphQLRequest(request *GraphQLRequest, config HandlerConfig) (*GraphQLRequest, error) {
+	query, err := persistedQuery(request, config.PersistedQueries, config.Allowlist)
+	if err != nil {
+		return nil, err
+	}
+
+	return &GraphQLRequest{
+		Query:         query,
+		Variables:     request.Variables,
+		OperationName: request.OperationName,
+	}, nil
+}
+
+// decodeGraphQLRequests - Requests of r and whether they were sent as batch
+func decodeGraphQLRequests(r *http.Request) ([]*GraphQLRequest, bool, error) {
+	if r.Method == http.MethodGet {
+		request, err := decodeGraphQLParams(r)
+		if err != nil {
+			return nil, false, err
+		}
+		return []*GraphQLRequest{request}, false, nil
+	}
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return nil, false, err
+	}
+
+	body = bytes.TrimSpace(body)
+	if len(body) > 0 && body[0] == '[' {
+		var requests []*GraphQLRequest
+		if err := json.Unmarshal(body, &requests); err != nil {
+			return nil, true, err
+		}
+		for _, request := range requests {
+			if request == nil {
+				return nil, true, fmt.Errorf("batch must not hold null requests")
+			}
+		}
+		return requests, true, nil
+	}
+
+	var request GraphQLRequest
+	if err := json.Unmarshal(body, &request); err != nil {
+		return nil, false, err
+	}
+
+	return []*GraphQLRequest{&request}, false, nil
+}
+
+func decodeGraphQLParams(r *http.Request) (*GraphQLRequest, error) {
+	params := r.URL.Query()
+	request := GraphQLRequest{
+		Query:         params.Get("query"),
+		OperationName: params.Get("operationName"),
+	}
+
+	if variables := params.Get("variables"); variables != "" {
+		if err := json.Unmarshal([]byte(variables), &request.Variables); err != nil {
+			return nil, err
+		}
+	}
+	if extensions := params.Get("extensions"); extensions != "" {
+		if err := json.Unmarshal([]byte(extensions), &request.Extensions); err != nil {
+			return nil, err
+		}
+	}
+
+	return &request, nil
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func serveGraphQL(handler http.Handler, body string) (int, map[string]interface{}) {
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/graphql", strings.NewReader(body)))
+
+	var response map[string]interface{}
+	_ = json.Unmarshal(recorder.Body.Bytes(), &response)
+	return recorder.Code, response
+}
+
+func errorCode(response map[string]interface{}) interface{} {
+	errors, _ := response["errors"].([]interface{})
+	if len(errors) == 0 {
+		return nil
+	}
+
+	extensions, _ := errors[0].(map[string]interface{})["extensions"].(map[string]interface{})
+	return extensions["code"]
+}
+
+func TestGraphQLHandler_PersistedQueries(t *testing.T) {
+	Entities["handler_test"] = EntityConfig{TableName: "handler_test"}
+	defer delete(Entities, "handler_test")
+
+	dataSource := &fakeDataSource{
+		schema: TableSchema{"id": "int"},
+		rows:   []map[string]interface{}{{"id": int64(1)}},
+	}
+	handler := GraphQLHandler(NewEngine(map[string]DataSource{DefaultDataSource: dataSource}), HandlerConfig{
+		PersistedQueries: NewLRUCache(0),
+	})
+
+	query := `{ handler_test { id } }`
+	persisted := `{"persistedQuery": {"version": 1, "sha256Hash": "` + queryHash(query) + `"}}`
+	extensions := `"extensions": ` + persisted
+
+	status, response := serveGraphQL(handler, `{`+extensions+`}`)
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, PersistedQueryNotFoundCode, errorCode(response))
+	assert.Equal(t, PersistedQueryNotFound, response["errors"].([]interface{})[0].(map[string]interface{})["message"])
+	assert.Len(t, dataSource.queries, 0)
+
+	status, response = serveGraphQL(handler, `{"query": "`+query+`", `+extensions+`}`)
+	assert.Equal(t, http.StatusOK, status)
+	assert.Nil(t, response["errors"])
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/graphql?extensions="+url.QueryEscape(persisted), nil))
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.JSONEq(t, `{"data": {"handler_test": [{"id": 1}]}}`, recorder.Body.String())
+	assert.Len(t, dataSource.queries, 2)
+
+	status, response = serveGraphQL(handler, `{"query": "{ handler_test { id id } }", `+extensions+`}`)
+	assert.Equal(t, http.StatusBadRequest, status)
+	assert.Equal(t, PersistedQueryHashMismatch, errorCode(response))
+
+	status, response = serveGraphQL(GraphQLHandler(NewEngine(nil), HandlerConfig{}), `{`+extensions+`}`)
+	assert.Equal(t, http.StatusBadRequest, status)
+	assert.Equal(t, PersistedQueryNotSupportedCode, errorCode(response))
+}
+
+func TestGraphQLHandler_Allowlist(t *testing.T) {
+	Entities["handler_test"] = EntityConfig{TableName: "handler_test"}
+	defer delete(Entities, "handler_test")
+
+	allowed := `{ handler_test { id } }`
+	dir, err := ioutil.TempDir("", "allowlist")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "allowlist.json")
+	assert.Nil(t, ioutil.WriteFile(path, []byte(`["`+allowed+`"]`), 0600))
+
+	allowlist, err := LoadAllowlist(path)
+	assert.Nil(t, err)
+
+	dataSource := &fakeDataSource{schema: TableSchema{"id": "int"}}
+	handler := GraphQLHandler(NewEngine(map[string]DataSource{DefaultDataSource: dataSource}), HandlerConfig{
+		PersistedQueries: NewLRUCache(0),
+		Allowlist:        allowlist,
+	})
+
+	status, response := serveGraphQL(handler, `{"query": "`+allowed+`"}`)
+	assert.Equal(t, http.StatusOK, status)
+	assert.Nil(t, response["errors"])
+
+	status, _ = serveGraphQL(handler, `{"extensions": {"persistedQuery": {"version": 1, "sha256Hash": "`+queryHash(allowed)+`"}}}`)
+	assert.Equal(t, http.StatusOK, status)
+
+	other := `{ handler_test { id id } }`
+	status, response = serveGraphQL(handler, `{"query": "`+other+`"}`)
+	assert.Equal(t, http.StatusForbidden, status)
+	assert.Equal(t, QueryNotAllowed, errorCode(response))
+	assert.Len(t, dataSource.queries, 2)
+
+	// Registering through the persisted query protocol is not possible either
+	status, _ = serveGraphQL(handler, `{"query": "`+other+`", "extensions": {"persistedQuery": {"version": 1, "sha256Hash": "`+queryHash(other)+`"}}}`)
+	assert.Equal(t, http.StatusForbidden, status)
+
+	manifest, _ := json.Marshal(map[string]string{queryHash(other): other})
+	assert.Nil(t, ioutil.WriteFile(path, manifest, 0600))
+	assert.Nil(t, os.Chtimes(path, time.Now(), time.Now().Add(time.Minute)))
+
+	stop := allowlist.Watch(10 * time.Millisecond)
+	defer stop()
+	assert.Eventually(t, func() bool {
+		_, ok := allowlist.Query(queryHash(other))
+		return ok
+	}, time.Second, 10*time.Millisecond)
+
+	_, ok := allowlist.Query(queryHash(allowed))
+	assert.False(t, ok)
+
+	assert.Nil(t, ioutil.WriteFile(path, []byte(`{"bad": "{ handler_test { id } }"}`), 0600))
+	assert.NotNil(t, allowlist.Reload())
+	_, ok = allowlist.Query(queryHash(other))
+	assert.True(t, ok)
+}
+
+func TestGraphQLHandler_Batch(t *testing.T) {
+	Entities["relation_payments"] = EntityConfig{
+		TableName: "relation_payments",
+		Relations: map[string]Relation{
+			"merchant": {Entity: "relation_merchants", Column: "merchant_id", RelatedColumn: "id"},
+		},
+	}
+	Entities["relation_merchants"] = EntityConfig{
+		TableName:  "relation_merchants",
+		DataSource: "merchants",
+	}
+	defer delete(Entities, "relation_payments")
+	defer delete(Entities, "relation_merchants")
+
+	payments := &fakeDataSource{
+		schema: TableSchema{"id": "int", "merchant_id": "varchar(10)"},
+		rows:   []map[string]interface{}{{"id": int64(1), "merchant_id": "m1"}},
+	}
+	merchants := &fakeDataSource{
+		schema: TableSchema{"id": "varchar(10)", "name": "varchar(100)"},
+		rows:   []map[string]interface{}{{"id": "m1", "name": "one"}},
+	}
+	handler := GraphQLHandler(NewEngine(map[string]DataSource{DefaultDataSource: payments, "merchants": merchants}), HandlerConfig{
+		MaxBatchSize: 3,
+	})
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/graphql", strings.NewReader(`[
+		{"query": "query Payments($limit: Int) { relation_payments(limit: $limit) { id merchant { name } } }", "variables": {"limit": 5}},
+		{"query": "{ unknown { id } }"},
+		{"query": "query A { relation_merchants { id } } query B { relation_payments { merchant { name } } }", "operationName": "B"}
+	]`)))
+	assert.Equal(t, http.StatusOK, recorder.Code)
+
+	var results []map[string]interface{}
+	assert.Nil(t, json.Unmarshal(recorder.Body.Bytes(), &results))
+	assert.Len(t, results, 3)
+	assert.Equal(t, map[string]interface{}{"relation_payments": []interface{}{
+		map[string]interface{}{"id": float64(1), "merchant": map[string]interface{}{"name": "one"}},
+	}}, results[0]["data"])
+	assert.NotNil(t, results[1]["errors"])
+	assert.Equal(t, map[string]interface{}{"relation_payments": []interface{}{
+		map[string]interface{}{"merchant": map[string]interface{}{"name": "one"}},
+	}}, results[2]["data"])
+
+	// The loaders are shared by the batch, the merchant is fetched once
+	assert.Len(t, payments.queries, 2)
+	assert.Len(t, merchants.queries, 1)
+	args := [][]interface{}{payments.queries[0].Args, payments.queries[1].Args}
+	assert.Contains(t, args, []interface{}{5, 0})
+
+	status, response := serveGraphQL(handler, `[{"query": "{ a }"}, {"query": "{ b }"}, {"query": "{ c }"}, {"query": "{ d }"}]`)
+	assert.Equal(t, http.StatusBadRequest, status)
+	assert.NotNil(t, response["errors"])
+
+	status, _ = serveGraphQL(handler, `[]`)
+	assert.Equal(t, http.StatusBadRequest, status)
+}
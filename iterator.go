@@ -0,0 +1,136 @@
+package main
+
+import "database/sql"
+
+// RowIterator - rows of a result read one at a time, Close must always be called
+type RowIterator interface {
+	// Next - Advance to the next row, false once the rows or the limit are exhausted or on error
+	Next() bool
+	Row() map[string]interface{}
+	// Err - Error which stopped the iteration, nil if the rows were exhausted
+	Err() error
+	Close() error
+}
+
+// sqlRowIterator - iterator over sql.Rows decoding each row with the decoders of its columns
+type sqlRowIterator struct {
+	rows     *sql.Rows
+	release  func()
+	columns  []string
+	decoders []ColumnDecoder
+	limit    int
+	count    int
+	row      map[string]interface{}
+	err      error
+	closed   bool
+}
+
+// newSqlRowIterator - Iterator over rows stopping after limit rows, no limit if limit is 0
+func newSqlRowIterator(rows *sql.Rows, release func(), limit int, schema TableSchema) (*sqlRowIterator, error) {
+	columnTypes, err := rows.ColumnTypes()
+	if err != nil {
+		release()
+		return nil, err
+	}
+
+	iterator := &sqlRowIterator{
+		rows:     rows,
+		release:  release,
+		columns:  make([]string, len(columnTypes)),
+		decoders: make([]ColumnDecoder, len(columnTypes)),
+		limit:    limit,
+	}
+	for idx, columnType := range columnTypes {
+		iterator.columns[idx] = columnType.Name()
+		iterator.decoders[idx] = NewColumnDecoder(columnType, schema[columnType.Name()])
+	}
+
+	return iterator, nil
+}
+
+func (it *sqlRowIterator) Next() bool {
+	if it.closed || it.err != nil || (it.limit > 0 && it.count >= it.limit) {
+		return false
+	}
+
+	if !it.rows.Next() {
+		it.err = it.rows.Err()
+		return false
+	}
+
+	columnPtr := make([]interface{}, len(it.columns))
+	for idx, column := range it.columns {
+		columnPtr[idx] = &ColValueScanner{column: column, decode: it.decoders[idx]}
+	}
+	if err := it.rows.Scan(columnPtr...); err != nil {
+		it.err = err
+		return false
+	}
+
+	it.row = make(map[string]interface{}, len(it.columns))
+	for idx, column := range it.columns {
+		it.row[column] = columnPtr[idx].(*ColValueScanner).value
+	}
+	it.count++
+
+	return true
+}
+
+func (it *sqlRowIterator) Row() map[string]interface{} {
+	return it.row
+}
+
+func (it *sqlRowIterator) Err() error {
+	return it.err
+}
+
+func (it *sqlRowIterator) Close() error {
+	if !it.closed {
+		it.closed = true
+		it.release()
+	}
+
+	return nil
+}
+
+type sliceRowIterator struct {
+	rows []map[string]interface{}
+	idx  int
+}
+
+// NewSliceRowIterator - Iterator over rows already in memory
+func NewSliceRowIterator(rows []map[string]interface{}) RowIterator {
+	return &sliceRowIterator{rows: rows, idx: -1}
+}
+
+func (it *sliceRowIterator) Next() bool {
+	if it.idx+1 >= len(it.rows) {
+		return false
+	}
+	it.idx++
+	return true
+}
+
+func (it *sliceRowIterator) Row() map[string]interface{} {
+	return it.rows[it.idx]
+}
+
+func (it *sliceRowIterator) Err() error {
+	return nil
+}
+
+func (it *sliceRowIterator) Close() error {
+	return nil
+}
+
+// CollectRows - Read all rows of the iterator and close it
+func CollectRows(it RowIterator) ([]map[string]interface{}, error) {
+	defer it.Close()
+
+	var rows []map[string]interface{}
+	for it.Next() {
+		rows = append(rows, it.Row())
+	}
+
+	return rows, it.Err()
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// failingRowIterator - yields its rows then fails as a connection dropping mid-stream would
+type failingRowIterator struct {
+	RowIterator
+	closed bool
+}
+
+func (it *failingRowIterator) Err() error {
+	return errors.New("connection lost")
+}
+
+func (it *failingRowIterator) Close() error {
+	it.closed = true
+	return nil
+}
+
+func TestCollectRows(t *testing.T) {
+	rows, err := CollectRows(NewSliceRowIterator([]map[string]interface{}{{"id": 1}, {"id": 2}}))
+	assert.Nil(t, err)
+	assert.Equal(t, []map[string]interface{}{{"id": 1}, {"id": 2}}, rows)
+
+	// A result cut short is an error, not fewer rows
+	failing := &failingRowIterator{RowIterator: NewSliceRowIterator([]map[string]interface{}{{"id": 1}})}
+	_, err = CollectRows(failing)
+	assert.EqualError(t, err, "connection lost")
+	assert.True(t, failing.closed)
+}
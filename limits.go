@@ -0,0 +1,252 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/graphql-go/graphql/language/ast"
+)
+
+// QueryLimits - limits a role's queries are checked against before any SQL runs, zero is unlimited
+type QueryLimits struct {
+	// MaxDepth - maximum nesting of selection sets, a root field with scalar fields has depth 1
+	MaxDepth int
+	// MaxComplexity - maximum sum over all selected fields of the rows they are estimated for
+	MaxComplexity int
+	// MaxRows - maximum estimated rows fetched by the whole request
+	MaxRows int
+}
+
+var DefaultQueryLimits = QueryLimits{
+	MaxDepth:      5,
+	MaxComplexity: 100000,
+	MaxRows:       10000,
+}
+
+// RoleLimits - query limits per role, the query_limits of the entities file, roles missing here get
+// DefaultQueryLimits
+var RoleLimits = map[string]QueryLimits{}
+
+func LimitsFor(role string) QueryLimits {
+	if limits, ok := RoleLimits[role]; ok {
+		return limits
+	}
+
+	return DefaultQueryLimits
+}
+
+// Limit violation codes reported in the extensions of the GraphQL error
+const (
+	MaxDepthExceeded      = "MAX_DEPTH_EXCEEDED"
+	MaxComplexityExceeded = "MAX_COMPLEXITY_EXCEEDED"
+	MaxRowsExceeded       = "MAX_ROWS_EXCEEDED"
+	MaxLimitExceeded      = "MAX_LIMIT_EXCEEDED"
+	InvalidPagination     = "INVALID_PAGINATION"
+)
+
+type QueryLimitError struct {
+	Code    string
+	Message string
+}
+
+func (e *QueryLimitError) Error() string {
+	return e.Message
+}
+
+func (e *QueryLimitError) Extensions() map[string]interface{} {
+	return map[string]interface{}{"code": e.Code}
+}
+
+// QueryCost - estimated cost of a query document
+type QueryCost struct {
+	Depth      int
+	Complexity int
+	Rows       int
+}
+
+// CheckQueryLimits - Estimate the cost of document and reject it if it exceeds the limits of role
+// or requests more rows than an entity allows with limit
+func CheckQueryLimits(document *ast.Document, variables map[string]interface{}, role string) (QueryCost, error) {
+	analyzer := costAnalyzer{
+		variables: variables,
+		fragments: map[string]*ast.FragmentDefinition{},
+	}
+	for _, definition := range document.Definitions {
+		if fragment, ok := definition.(*ast.FragmentDefinition); ok {
+			analyzer.fragments[fragment.Name.Value] = fragment
+		}
+	}
+
+	for _, definition := range document.Definitions {
+		if operation, ok := definition.(*ast.OperationDefinition); ok {
+			if err := analyzer.selectionSet(operation.SelectionSet, "", 0, 1, true, map[string]bool{}); err != nil {
+				return analyzer.cost, err
+			}
+		}
+	}
+
+	limits := LimitsFor(role)
+	cost := analyzer.cost
+	switch {
+	case limits.MaxDepth > 0 && cost.Depth > limits.MaxDepth:
+		return cost, &QueryLimitError{MaxDepthExceeded, fmt.Sprintf("query depth %d exceeds maximum depth %d", cost.Depth, limits.MaxDepth)}
+	case limits.MaxComplexity > 0 && cost.Complexity > limits.MaxComplexity:
+		return cost, &QueryLimitError{MaxComplexityExceeded, fmt.Sprintf("query complexity %d exceeds maximum complexity %d", cost.Complexity, limits.MaxComplexity)}
+	case limits.MaxRows > 0 && cost.Rows > limits.MaxRows:
+		return cost, &QueryLimitError{MaxRowsExceeded, fmt.Sprintf("query may fetch %d rows, more than the budget of %d rows", cost.Rows, limits.MaxRows)}
+	}
+
+	return cost, nil
+}
+
+type costAnalyzer struct {
+	variables map[string]interface{}
+	fragments map[string]*ast.FragmentDefinition
+	cost      QueryCost
+}
+
+// selectionSet - Walk the selection set of entity where every field is fetched for parentRows
+// rows, root fields are entities whose limit argument is checked against the entity maximum
+func (a *costAnalyzer) selectionSet(set *ast.SelectionSet, entity string, depth, parentRows int, root bool, spreads map[string]bool) error {
+	if set == nil {
+		return nil
+	}
+
+	for _, selection := range set.Selections {
+		switch selection := selection.(type) {
+		case *ast.Field:
+			if selection.SelectionSet == nil {
+				a.cost.Complexity += parentRows
+				continue
+			}
+
+			fieldEntity := selection.Name.Value
+			if root {
+				if entity, ok := Entities.EntityOf(fieldEntity); ok {
+					fieldEntity = entity
+				}
+			}
+
+			limit, err := a.limit(selection, fieldEntity, root)
+			if err != nil {
+				return err
+			}
+
+			if !root {
+				relation, ok := Entities.GetRelations(entity)[selection.Name.Value]
+				fieldEntity = relation.Entity
+				// A relation to a single row fetches at most one row per parent row, a relation to
+				// a list at most the maximum limit of its entity
+				if ok && !relation.Many {
+					limit = 1
+				} else if ok {
+					limit = EntityLimit(relation.Entity, limit)
+				}
+			}
+
+			rows := parentRows * limit
+			a.cost.Rows += rows
+			a.cost.Complexity += rows
+			if depth+1 > a.cost.Depth {
+				a.cost.Depth = depth + 1
+			}
+
+			if err := a.selectionSet(selection.SelectionSet, fieldEntity, depth+1, rows, false, spreads); err != nil {
+				return err
+			}
+		case *ast.InlineFragment:
+			if err := a.selectionSet(selection.SelectionSet, entity, depth, parentRows, root, spreads); err != nil {
+				return err
+			}
+		case *ast.FragmentSpread:
+			name := selection.Name.Value
+			fragment, ok := a.fragments[name]
+			// Cyclic spreads are rejected by validation later, here they only must not recurse forever
+			if !ok || spreads[name] {
+				continue
+			}
+
+			spreads[name] = true
+			err := a.selectionSet(fragment.SelectionSet, entity, depth, parentRows, root, spreads)
+			delete(spreads, name)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// limit - Rows requested by the limit argument of field on entity, the default limit if it has none
+func (a *costAnalyzer) limit(field *ast.Field, entity string, root bool) (int, error) {
+	limit := DefaultLimit
+	if root {
+		limit = EntityLimit(entity, DefaultLimit)
+	}
+
+	if value, ok := a.intArgument(field, "limit"); ok {
+		limit = value
+	}
+	offset, _ := a.intArgument(field, "offset")
+	if err := CheckPagination(limit, offset); err != nil {
+		return 0, err
+	}
+
+	if root {
+		if maxLimit := Entities.GetMaxLimit(entity); maxLimit > 0 && limit > maxLimit {
+			return 0, &QueryLimitError{MaxLimitExceeded, fmt.Sprintf("limit %d on %q exceeds maximum limit %d", limit, entity, maxLimit)}
+		}
+	}
+
+	return limit, nil
+}
+
+// intArgument - Value of the integer argument name of field, given literally or by a variable
+func (a *costAnalyzer) intArgument(field *ast.Field, name string) (int, bool) {
+	for _, argument := range field.Arguments {
+		if argument.Name.Value != name {
+			continue
+		}
+
+		switch value := argument.Value.(type) {
+		case *ast.IntValue:
+			if v, err := strconv.Atoi(value.Value); err == nil {
+				return v, true
+			}
+		case *ast.Variable:
+			switch v := a.variables[value.Name.Value].(type) {
+			case int:
+				return v, true
+			case int64:
+				return int(v), true
+			case float64:
+				return int(v), true
+			}
+		}
+	}
+
+	return 0, false
+}
+
+// CheckPagination - Reject negative limits and offsets, a negative limit would lower the cost of
+// its query below the one of the rows other fields fetch and MySQL does not take it
+func CheckPagination(limit, offset int) error {
+	if limit < 0 {
+		return &QueryLimitError{InvalidPagination, fmt.Sprintf("limit %d must not be negative", limit)}
+	}
+	if offset < 0 {
+		return &QueryLimitError{InvalidPagination, fmt.Sprintf("offset %d must not be negative", offset)}
+	}
+
+	return nil
+}
+
+// EntityLimit - limit capped at the maximum limit of entity
+func EntityLimit(entity string, limit int) int {
+	if maxLimit := Entities.GetMaxLimit(entity); maxLimit > 0 && limit > maxLimit {
+		return maxLimit
+	}
+
+	return limit
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/graphql-go/graphql/language/parser"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckQueryLimits(t *testing.T) {
+	Entities["limits_test"] = EntityConfig{
+		TableName: "payments",
+		MaxLimit:  500,
+		Relations: map[string]Relation{
+			"merchant": {Entity: "merchants", Column: "merchant_id", RelatedColumn: "id"},
+			"refunds":  {Entity: "refunds", Column: "id", RelatedColumn: "payment_id", Many: true},
+		},
+	}
+	RoleLimits["limits_test"] = QueryLimits{MaxDepth: 2, MaxComplexity: 2000, MaxRows: 600}
+	defer delete(Entities, "limits_test")
+	defer delete(RoleLimits, "limits_test")
+
+	check := func(query string, variables map[string]interface{}) (QueryCost, error) {
+		document, err := parser.Parse(parser.ParseParams{Source: query})
+		assert.Nil(t, err)
+		return CheckQueryLimits(document, variables, "limits_test")
+	}
+
+	cost, err := check(`{ limits_test(limit: 10) { id amount } }`, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, QueryCost{Depth: 1, Complexity: 30, Rows: 10}, cost)
+
+	cost, err = check(`query { limits_test { ...f } } fragment f on limits_test { id }`, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, QueryCost{Depth: 1, Complexity: 200, Rows: 100}, cost)
+
+	cost, err = check(`{ limits_test(limit: 10) { id merchant { name } } }`, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, QueryCost{Depth: 2, Complexity: 40, Rows: 20}, cost)
+
+	// Relations to lists fetch their limit per parent row
+	cost, err = check(`{ limits_test(limit: 10) { id refunds(limit: 5) { id } } }`, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, QueryCost{Depth: 2, Complexity: 120, Rows: 60}, cost)
+
+	_, err = check(`query q($n: Int) { limits_test(limit: $n) { id } }`, map[string]interface{}{"n": float64(501)})
+	assert.Equal(t, MaxLimitExceeded, err.(*QueryLimitError).Code)
+
+	_, err = check(`{ limits_test(limit: 500) { id amount status currency } }`, nil)
+	assert.Equal(t, MaxComplexityExceeded, err.(*QueryLimitError).Code)
+
+	_, err = check(`{ limits_test(limit: 1) { a { b { c } } } }`, nil)
+	assert.Equal(t, MaxDepthExceeded, err.(*QueryLimitError).Code)
+
+	_, err = check(`{ a: limits_test(limit: 300) { id } b: limits_test(limit: 301) { id } }`, nil)
+	assert.Equal(t, MaxRowsExceeded, err.(*QueryLimitError).Code)
+
+	// Negative limits would take rows off the budget of the other fields
+	_, err = check(`{ a: limits_test(limit: 500) { id } b: limits_test(limit: -500) { id } }`, nil)
+	assert.Equal(t, InvalidPagination, err.(*QueryLimitError).Code)
+	_, err = check(`query q($n: Int) { limits_test(offset: $n) { id merchant(offset: 1) { id } } }`, map[string]interface{}{"n": float64(-1)})
+	assert.Equal(t, InvalidPagination, err.(*QueryLimitError).Code)
+}
+
+func TestEngine_QueryConfiguredLimits(t *testing.T) {
+	entities, limits, err := ParseEntities("entities.yaml", []byte(`
+entities:
+  limits_config_test: {}
+query_limits:
+  limits_config_test: {max_rows: 10}
+`), func(string) (string, bool) { return "", false })
+	assert.Nil(t, err)
+	Entities["limits_config_test"] = entities["limits_config_test"]
+	RoleLimits["limits_config_test"] = limits["limits_config_test"]
+	defer delete(Entities, "limits_config_test")
+	defer delete(RoleLimits, "limits_config_test")
+
+	dataSource := &fakeDataSource{schema: TableSchema{"id": "int"}}
+	engine := NewEngine(map[string]DataSource{DefaultDataSource: dataSource})
+	ctx := WithSession(context.Background(), NewSession("limits_config_test", nil))
+
+	result := engine.Query(ctx, `{ limits_config_test(limit: 11) { id } }`)
+	if assert.Len(t, result.Errors, 1) {
+		assert.Equal(t, MaxRowsExceeded, result.Errors[0].Extensions["code"])
+	}
+	assert.Empty(t, dataSource.queries)
+
+	// The other roles keep the default limits
+	result = engine.Query(context.Background(), `{ limits_config_test(limit: 11) { id } }`)
+	assert.Empty(t, result.Errors)
+	assert.Len(t, dataSource.queries, 1)
+}
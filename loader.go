@@ -0,0 +1,197 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// DefaultMaxAllowedPacket - max_allowed_packet of the server when not configured, the MySQL 5.7
+// default, key lists of batched lookups are split to stay below it
+const DefaultMaxAllowedPacket = 4 << 20
+
+const (
+	// maxChunkKeys - keys per lookup, well below the 65535 placeholders a statement may have
+	maxChunkKeys = 1 << 15
+	// packetReserve - bytes of a packet left for the statement around the keys
+	packetReserve = 64 << 10
+	// keyOverhead - bytes a key takes in a packet besides its value, for its type and length
+	keyOverhead = 8
+)
+
+// BatchFunc - Fetch the rows of all keys at once, grouped by the loaderKey of their key
+type BatchFunc func(ctx context.Context, keys []interface{}) (map[string][]map[string]interface{}, error)
+
+// Loader - Collects the keys looked up by the resolvers of a level and fetches them in a single
+// batch once the first of them needs its rows. The rows of a key are remembered for the rest of
+// the request so identical lookups are not fetched again. Batches run without holding the lock,
+// lookups of keys queued meanwhile dispatch their own batch, lookups of a key being fetched wait
+// for its batch.
+type Loader struct {
+	batch BatchFunc
+
+	mu      sync.Mutex
+	pending []interface{}
+	results map[string]*loaderResult
+}
+
+type loaderResult struct {
+	rows []map[string]interface{}
+	err  error
+	// dispatched - the key is in a batch, rows and err are set once done is closed
+	dispatched bool
+	done       chan struct{}
+}
+
+func NewLoader(batch BatchFunc) *Loader {
+	return &Loader{
+		batch:   batch,
+		results: map[string]*loaderResult{},
+	}
+}
+
+// Load - Queue key for the next batch, the returned thunk gives the rows of key and runs the batch
+// of every key queued so far unless it already ran
+func (l *Loader) Load(ctx context.Context, key interface{}) func() ([]map[string]interface{}, error) {
+	id := loaderKey(key)
+
+	l.mu.Lock()
+	if _, ok := l.results[id]; !ok {
+		l.results[id] = &loaderResult{done: make(chan struct{})}
+		l.pending = append(l.pending, key)
+	}
+	l.mu.Unlock()
+
+	return func() ([]map[string]interface{}, error) {
+		l.mu.Lock()
+		result := l.results[id]
+		if result.dispatched {
+			l.mu.Unlock()
+		} else {
+			l.dispatch(ctx)
+		}
+
+		<-result.done
+		return result.rows, result.err
+	}
+}
+
+// dispatch - Fetch the keys queued so far in a batch, l.mu is held when it is called and released
+// before the batch runs
+func (l *Loader) dispatch(ctx context.Context) {
+	keys := l.pending
+	l.pending = nil
+	results := make([]*loaderResult, len(keys))
+	for idx, key := range keys {
+		results[idx] = l.results[loaderKey(key)]
+		results[idx].dispatched = true
+	}
+	l.mu.Unlock()
+
+	rows, err := l.batch(ctx, keys)
+	for idx, key := range keys {
+		results[idx].rows = rows[loaderKey(key)]
+		results[idx].err = err
+		close(results[idx].done)
+	}
+}
+
+// loaderKey - Key compared by its value regardless of the Go type it was decoded to, eg int64 of
+// a signed column and uint64 of an unsigned one
+func loaderKey(key interface{}) string {
+	if value, ok := key.([]byte); ok {
+		return string(value)
+	}
+
+	return fmt.Sprint(key)
+}
+
+// chunkKeys - Split keys into lists small enough for a single statement below maxPacket. Chunks
+// are padded to a power of two with their last key so lookups share a few statement shapes.
+func chunkKeys(keys []interface{}, maxPacket int) [][]interface{} {
+	budget := maxPacket - packetReserve
+	if budget < packetReserve {
+		budget = packetReserve
+	}
+
+	var (
+		chunks [][]interface{}
+		chunk  []interface{}
+		size   int
+	)
+	for _, key := range keys {
+		keySize := len(loaderKey(key)) + keyOverhead
+		if len(chunk) > 0 && (size+keySize > budget || len(chunk) == maxChunkKeys) {
+			chunks = append(chunks, padKeys(chunk, size, budget))
+			chunk, size = nil, 0
+		}
+		chunk = append(chunk, key)
+		size += keySize
+	}
+	if len(chunk) > 0 {
+		chunks = append(chunks, padKeys(chunk, size, budget))
+	}
+
+	return chunks
+}
+
+func padKeys(chunk []interface{}, size, budget int) []interface{} {
+	padded := 1
+	for padded < len(chunk) {
+		padded <<= 1
+	}
+
+	last := chunk[len(chunk)-1]
+	if size+(padded-len(chunk))*(len(loaderKey(last))+keyOverhead) > budget {
+		return chunk
+	}
+
+	for len(chunk) < padded {
+		chunk = append(chunk, last)
+	}
+
+	return chunk
+}
+
+// Loaders - Loaders of a request by name
+type Loaders struct {
+	mu      sync.Mutex
+	loaders map[string]*Loader
+}
+
+type loadersContextKey struct{}
+
+// WithLoaders - Context sharing loaders between the resolvers run with it, ctx is returned as is
+// if it already has loaders
+func WithLoaders(ctx context.Context) context.Context {
+	if LoadersFromContext(ctx) != nil {
+		return ctx
+	}
+
+	return context.WithValue(ctx, loadersContextKey{}, &Loaders{loaders: map[string]*Loader{}})
+}
+
+// LoadersFromContext - Loaders of ctx, nil if it has none
+func LoadersFromContext(ctx context.Context) *Loaders {
+	loaders, _ := ctx.Value(loadersContextKey{}).(*Loaders)
+	return loaders
+}
+
+// Get - Loader named name, created with batch if there is none yet. Without loaders every call
+// gets a loader of its own and nothing is batched.
+func (l *Loaders) Get(name string, batch BatchFunc) *Loader {
+	if l == nil {
+		return NewLoader(batch)
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	loader, ok := l.loaders[name]
+	if !ok {
+		loader = NewLoader(batch)
+		l.loaders[name] = loader
+	}
+
+	return loader
+}
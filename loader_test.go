@@ -0,0 +1,200 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoader(t *testing.T) {
+	var batches [][]interface{}
+	loader := NewLoader(func(ctx context.Context, keys []interface{}) (map[string][]map[string]interface{}, error) {
+		batches = append(batches, keys)
+		rows := map[string][]map[string]interface{}{}
+		for _, key := range keys {
+			rows[loaderKey(key)] = []map[string]interface{}{{"id": key}}
+		}
+		return rows, nil
+	})
+
+	ctx := context.Background()
+	first := loader.Load(ctx, int64(1))
+	second := loader.Load(ctx, uint64(2))
+	again := loader.Load(ctx, uint64(1))
+
+	rows, err := second()
+	assert.Nil(t, err)
+	assert.Equal(t, []map[string]interface{}{{"id": uint64(2)}}, rows)
+
+	rows, err = first()
+	assert.Nil(t, err)
+	assert.Equal(t, []map[string]interface{}{{"id": int64(1)}}, rows)
+
+	rows, err = again()
+	assert.Nil(t, err)
+	assert.Equal(t, []map[string]interface{}{{"id": int64(1)}}, rows)
+	assert.Equal(t, [][]interface{}{{int64(1), uint64(2)}}, batches)
+
+	rows, err = loader.Load(ctx, int64(2))()
+	assert.Nil(t, err)
+	assert.Len(t, rows, 1)
+	assert.Len(t, batches, 1)
+}
+
+func TestLoader_Concurrent(t *testing.T) {
+	started, release := make(chan struct{}), make(chan struct{})
+	var (
+		mu      sync.Mutex
+		batches [][]interface{}
+	)
+	loader := NewLoader(func(ctx context.Context, keys []interface{}) (map[string][]map[string]interface{}, error) {
+		mu.Lock()
+		batches = append(batches, keys)
+		mu.Unlock()
+		if keys[0] == 1 {
+			close(started)
+			<-release
+		}
+		return map[string][]map[string]interface{}{loaderKey(keys[0]): {{"id": keys[0]}}}, nil
+	})
+
+	ctx := context.Background()
+	first := make(chan []map[string]interface{})
+	go func() {
+		rows, _ := loader.Load(ctx, 1)()
+		first <- rows
+	}()
+	<-started
+
+	// Keys queued while a batch runs are not held up by it
+	rows, err := loader.Load(ctx, 2)()
+	assert.Nil(t, err)
+	assert.Equal(t, []map[string]interface{}{{"id": 2}}, rows)
+
+	// Lookups of a key being fetched wait for its batch
+	again := make(chan []map[string]interface{})
+	go func() {
+		rows, _ := loader.Load(ctx, 1)()
+		again <- rows
+	}()
+	close(release)
+	assert.Equal(t, []map[string]interface{}{{"id": 1}}, <-first)
+	assert.Equal(t, []map[string]interface{}{{"id": 1}}, <-again)
+	assert.Equal(t, [][]interface{}{{1}, {2}}, batches)
+}
+
+func TestChunkKeys(t *testing.T) {
+	keys := []interface{}{1, 2, 3, 4, 5}
+	assert.Equal(t, [][]interface{}{{1, 2, 3, 4, 5, 5, 5, 5}}, chunkKeys(keys, DefaultMaxAllowedPacket))
+
+	large := make([]interface{}, 3)
+	for idx := range large {
+		large[idx] = strings.Repeat("x", packetReserve-keyOverhead)
+	}
+	chunks := chunkKeys(large, 2*packetReserve)
+	assert.Len(t, chunks, 3)
+	for _, chunk := range chunks {
+		assert.Len(t, chunk, 1)
+	}
+}
+
+func TestEngine_QueryRelations(t *testing.T) {
+	Entities["relation_payments"] = EntityConfig{
+		TableName: "relation_payments",
+		Relations: map[string]Relation{
+			"merchant": {Entity: "relation_merchants", Column: "merchant_id", RelatedColumn: "id"},
+		},
+	}
+	Entities["relation_merchants"] = EntityConfig{
+		TableName:  "relation_merchants",
+		DataSource: "merchants",
+	}
+	defer delete(Entities, "relation_payments")
+	defer delete(Entities, "relation_merchants")
+
+	payments := &fakeDataSource{
+		schema: TableSchema{"id": "int", "merchant_id": "varchar(10)"},
+		rows: []map[string]interface{}{
+			{"id": int64(1), "merchant_id": "m1"},
+			{"id": int64(2), "merchant_id": "m2"},
+			{"id": int64(3), "merchant_id": "m1"},
+		},
+	}
+	merchants := &fakeDataSource{
+		schema: TableSchema{"id": "varchar(10)", "name": "varchar(100)"},
+		rows: []map[string]interface{}{
+			{"id": "m1", "name": "one"},
+			{"id": "m2", "name": "two"},
+		},
+	}
+	engine := NewEngine(map[string]DataSource{DefaultDataSource: payments, "merchants": merchants})
+
+	result := engine.Query(context.Background(), `{ relation_payments { id merchant { name } } }`)
+	assert.Empty(t, result.Errors)
+	assert.Equal(t, map[string]interface{}{"relation_payments": []interface{}{
+		map[string]interface{}{"id": 1, "merchant": map[string]interface{}{"name": "one"}},
+		map[string]interface{}{"id": 2, "merchant": map[string]interface{}{"name": "two"}},
+		map[string]interface{}{"id": 3, "merchant": map[string]interface{}{"name": "one"}},
+	}}, result.Data)
+
+	assert.Len(t, payments.queries, 1)
+	assert.Contains(t, payments.queries[0].SQL, "SELECT /*+ MAX_EXECUTION_TIME(30000) */ `id`, `merchant_id` FROM")
+	assert.Len(t, merchants.queries, 1)
+	assert.Equal(t, "SELECT /*+ MAX_EXECUTION_TIME(30000) */ `name`, `id` FROM "+
+		"(SELECT `name`, `id`, ROW_NUMBER() OVER (PARTITION BY `id`) AS `__key_row` FROM `relation_merchants` WHERE `id` IN (?, ?)) AS `keyed` "+
+		"WHERE `__key_row` <= ?;", merchants.queries[0].SQL)
+	assert.Equal(t, []interface{}{"m1", "m2", 1}, merchants.queries[0].Args)
+}
+
+func TestEngine_QueryManyRelationLimit(t *testing.T) {
+	Entities["limit_merchants"] = EntityConfig{
+		Relations: map[string]Relation{
+			"payments": {Entity: "limit_payments", Column: "id", RelatedColumn: "merchant_id", Many: true},
+		},
+	}
+	Entities["limit_payments"] = EntityConfig{DataSource: "payments", MaxLimit: 3}
+	defer delete(Entities, "limit_merchants")
+	defer delete(Entities, "limit_payments")
+
+	merchants := &fakeDataSource{
+		schema: TableSchema{"id": "varchar(10)"},
+		rows:   []map[string]interface{}{{"id": "m1"}, {"id": "m2"}},
+	}
+	payments := &fakeDataSource{
+		schema: TableSchema{"id": "int", "merchant_id": "varchar(10)"},
+		rows: []map[string]interface{}{
+			{"id": int64(1), "merchant_id": "m1"},
+			{"id": int64(2), "merchant_id": "m1"},
+			{"id": int64(3), "merchant_id": "m2"},
+			{"id": int64(4), "merchant_id": "m1"},
+			{"id": int64(5), "merchant_id": "m1"},
+		},
+	}
+	engine := NewEngine(map[string]DataSource{DefaultDataSource: merchants, "payments": payments})
+
+	payment := func(id int) map[string]interface{} { return map[string]interface{}{"id": id} }
+	result := engine.Query(context.Background(), `{ limit_merchants { id payments(limit: 2) { id } } }`)
+	assert.Empty(t, result.Errors)
+	assert.Equal(t, map[string]interface{}{"limit_merchants": []interface{}{
+		map[string]interface{}{"id": "m1", "payments": []interface{}{payment(1), payment(2)}},
+		map[string]interface{}{"id": "m2", "payments": []interface{}{payment(3)}},
+	}}, result.Data)
+	// The database returns no more than the limit per merchant either
+	if assert.Len(t, payments.queries, 1) {
+		assert.Contains(t, payments.queries[0].SQL, "WHERE `__key_row` <= ?;")
+		assert.Equal(t, []interface{}{"m1", "m2", 2}, payments.queries[0].Args)
+	}
+
+	// The rows per merchant are capped by the maximum limit of payments
+	result = engine.Query(context.Background(), `{ limit_merchants { id payments { id } } }`)
+	assert.Empty(t, result.Errors)
+	assert.Len(t, result.Data.(map[string]interface{})["limit_merchants"].([]interface{})[0].(map[string]interface{})["payments"], 3)
+
+	result = engine.Query(context.Background(), `{ limit_merchants { id payments(limit: -1) { id } } }`)
+	if assert.Len(t, result.Errors, 1) {
+		assert.Equal(t, InvalidPagination, result.Errors[0].Extensions["code"])
+	}
+}
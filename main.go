@@ -1,42 +1,138 @@
 package main
 
 import (
-	"encoding/json"
-	"fmt"
+	"context"
+	"errors"
+	"expvar"
+	"log"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 )
 
 func main() {
-	http.HandleFunc("/graphql", func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Content-Type", "application/json")
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
-		var query map[string]interface{}
-		err := json.NewDecoder(r.Body).Decode(&query)
+	os.Exit(Run(ctx, os.Args[1:], os.Stdout, os.Stderr))
+}
+
+// Serve - Serve the GraphQL API configured by config until ctx is done, requests in flight get
+// ShutdownTimeout to finish then
+func Serve(ctx context.Context, config Config) error {
+	authenticator, err := NewAuthenticator(config.authConfig())
+	if err != nil {
+		return err
+	}
+
+	db, engine, err := openEngine(config)
+	if err != nil {
+		return err
+	}
+	// Only results of entities with a cache TTL are cached
+	engine.Cache = NewLRUCache(DefaultResultCacheSize)
+	defer engine.Close()
+
+	// Problems of the entities are reported but do not stop the server, entities with computed
+	// fields named like columns are refused when they are queried
+	for _, problem := range engine.CheckEntities(ctx) {
+		log.Printf("entity problem: %v", problem)
+	}
+
+	// Served with the other expvars at /debug/vars
+	expvar.Publish("statement_cache", expvar.Func(func() interface{} {
+		stats := db.StatementCacheStats()
+		return map[string]interface{}{
+			"hits":      stats.Hits,
+			"misses":    stats.Misses,
+			"evictions": stats.Evictions,
+			"size":      stats.Size,
+			"hit_rate":  stats.HitRate(),
+		}
+	}))
 
-		result, err := Query(query["query"].(string))
+	handlerConf := HandlerConfig{
+		PersistedQueries: NewLRUCache(DefaultResultCacheSize),
+		MaxBatchSize:     config.MaxBatchSize,
+		BatchParallelism: config.BatchParallelism,
+	}
+	// Strict mode, only the queries of the allowlist run
+	if config.AllowlistFile != "" {
+		handlerConf.Allowlist, err = LoadAllowlist(config.AllowlistFile)
 		if err != nil {
-			_, _ = w.Write([]byte(fmt.Sprintf("Error : %v", err)))
+			return err
 		}
-		_ = json.NewEncoder(w).Encode(result)
-	})
+		stop := handlerConf.Allowlist.Watch(5 * time.Second)
+		defer stop()
+	}
 
-	var defaultConf = SqlConfig{
-		Host:     "localhost",
-		Port:     23306,
-		Username: "user",
-		Password: "123",
-		Name:     "database",
-		Protocol: "tcp",
+	// Subscriptions follow the binlog when a replica server id is configured, otherwise they poll
+	// the change column of their entities
+	if config.BinlogServerID != 0 {
+		feed := NewBinlogFeed(BinlogConfig{
+			ServerID:   config.BinlogServerID,
+			Host:       config.Database.Host,
+			Port:       uint16(config.Database.Port),
+			User:       config.Database.Username,
+			Password:   config.Database.Password,
+			Schema:     config.Database.Name,
+			Source:     db,
+			Positions:  FilePositionStore{Path: config.BinlogPositionFile},
+			Invalidate: engine.InvalidateEntity,
+		})
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		go func() {
+			if err := feed.Run(ctx); err != nil && ctx.Err() == nil {
+				log.Printf("binlog feed stopped, error: %v", err)
+			}
+		}()
+		engine.ChangeFeed = feed
+	} else {
+		engine.ChangeFeed = NewPollingFeed(engine, time.Duration(config.PollInterval))
 	}
 
-	_, err := NewMySql(defaultConf)
-	if err != nil {
-		panic(err)
+	server := &http.Server{Addr: config.Listen, Handler: serveMux(engine, authenticator, handlerConf)}
+	served := make(chan error, 1)
+	go func() {
+		served <- server.ListenAndServe()
+	}()
+	log.Printf("Server is running on %s", config.Listen)
+
+	select {
+	case err := <-served:
+		return err
+	case <-ctx.Done():
 	}
 
-	fmt.Println("Server is running on port 8080")
-	err = http.ListenAndServe(":8080", nil)
-	if err != nil {
-		panic(err)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), time.Duration(config.ShutdownTimeout))
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		return err
 	}
+	if err := <-served; !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+
+	return nil
+}
+
+// serveMux - Endpoints of serve on engine, requests are authenticated by authenticator
+func serveMux(engine *Engine, authenticator Authenticator, handlerConf HandlerConfig) *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.Handle("/graphql", AuthMiddleware(authenticator, GraphQLHandler(engine, handlerConf)))
+	mux.Handle("/graphql/ws", SubscriptionHandler(engine, authenticator, handlerConf))
+	mux.Handle("/graphql/stream", AuthMiddleware(authenticator, SSEHandler(engine, handlerConf)))
+	mux.Handle("/export", AuthMiddleware(authenticator, ExportHandler(engine)))
+	mux.Handle("/schema.graphql", AuthMiddleware(authenticator, SDLHandler(engine)))
+	mux.Handle("/schema.json", AuthMiddleware(authenticator, IntrospectionHandler(engine)))
+	// The page is public, the queries it sends authenticate with the headers set in it
+	graphiql := GraphiQLHandler("/graphiql", "/graphql")
+	mux.Handle("/graphiql", graphiql)
+	mux.Handle("/graphiql/", graphiql)
+	mux.Handle("/debug/vars", expvar.Handler())
+
+	return mux
 }
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestServeMux(t *testing.T) {
+	Entities["serve_test"] = EntityConfig{
+		RowFilters: map[string]map[string]interface{}{
+			"merchant": {"merchant_id": map[string]interface{}{"_eq": "X-Merchant-Id"}},
+		},
+	}
+	defer delete(Entities, "serve_test")
+
+	config := Config{
+		JWTSecret:    "secret",
+		JWTVariables: map[string]string{"X-Merchant-Id": "merchant.id"},
+	}
+	authenticator, err := NewAuthenticator(config.authConfig())
+	assert.Nil(t, err)
+
+	dataSource := &fakeDataSource{
+		schema: TableSchema{"id": "int", "merchant_id": "varchar"},
+		rows:   []map[string]interface{}{{"id": int64(1)}},
+	}
+	mux := serveMux(NewEngine(map[string]DataSource{DefaultDataSource: dataSource}), authenticator, HandlerConfig{})
+
+	request := func(token string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPost, "/graphql", strings.NewReader(`{"query": "{ serve_test { id } }"}`))
+		if token != "" {
+			r.Header.Set("Authorization", "Bearer "+token)
+		}
+		recorder := httptest.NewRecorder()
+		mux.ServeHTTP(recorder, r)
+		return recorder
+	}
+
+	// Anonymous requests are rejected once tokens are configured
+	assert.Equal(t, http.StatusUnauthorized, request("").Code)
+	assert.Empty(t, dataSource.queries)
+
+	claims := map[string]interface{}{
+		"role":     "merchant",
+		"exp":      time.Now().Add(time.Hour).Unix(),
+		"merchant": map[string]interface{}{"id": "m1"},
+	}
+	token := signToken(t, map[string]interface{}{"alg": HS256}, claims, func(data []byte) []byte {
+		mac := hmac.New(sha256.New, []byte("secret"))
+		mac.Write(data)
+		return mac.Sum(nil)
+	})
+
+	response := request(token)
+	assert.Equal(t, http.StatusOK, response.Code)
+	assert.JSONEq(t, `{"data": {"serve_test": [{"id": 1}]}}`, response.Body.String())
+	if assert.Len(t, dataSource.queries, 1) {
+		assert.Contains(t, dataSource.queries[0].SQL, "WHERE `merchant_id` = ?")
+		assert.Equal(t, "m1", dataSource.queries[0].Args[0])
+	}
+}
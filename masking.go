@@ -0,0 +1,117 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// Masking strategies
+const (
+	MaskNone     = "none"
+	MaskRedact   = "redact"
+	MaskKeepLast = "keep_last"
+	MaskHash     = "hash"
+	MaskNull     = "null"
+)
+
+// AnyRole - mask rule applied to every role without a rule of its own
+const AnyRole = "*"
+
+const redacted = "[REDACTED]"
+
+// MaskRule - how the value of a column is masked for a role
+type MaskRule struct {
+	Strategy string `yaml:"strategy"`
+	// KeepLast - no of trailing characters left visible by keep_last
+	KeepLast int `yaml:"keep_last"`
+	// Salt - prepended to the value before hashing
+	Salt string `yaml:"salt"`
+}
+
+// MaskFor - Mask rule of column for role, second return value is false if the column is not masked
+func MaskFor(entity, column, role string) (MaskRule, bool) {
+	rules, ok := Entities.GetColumnMasks(entity)[column]
+	if !ok {
+		return MaskRule{}, false
+	}
+
+	rule, ok := rules[role]
+	if !ok {
+		rule, ok = rules[AnyRole]
+	}
+	if !ok || rule.Strategy == MaskNone {
+		return MaskRule{}, false
+	}
+
+	return rule, true
+}
+
+// IsMasked - Whether the column is masked for role, masked columns can not be filtered on
+func IsMasked(entity, column, role string) bool {
+	_, masked := MaskFor(entity, column, role)
+	return masked
+}
+
+// CheckFilterable - Reject filters on columns the role can not filter on. The where argument is not
+// validated against its type, so the generated schema alone does not keep masked columns out.
+func CheckFilterable(filter map[string]interface{}, filterable []string) error {
+	for _, column := range sortedKeys(filter) {
+		if !contains(filterable, column) {
+			return &ValidationError{FilterNotAllowed, column, fmt.Sprintf("can not filter on %q", column)}
+		}
+	}
+
+	return nil
+}
+
+// MaskRows - Apply mask rules of entity for role on the fetched rows in place
+func MaskRows(entity, role string, rows []map[string]interface{}) {
+	rules := map[string]MaskRule{}
+	for column := range Entities.GetColumnMasks(entity) {
+		if rule, ok := MaskFor(entity, column, role); ok {
+			rules[column] = rule
+		}
+	}
+	if len(rules) == 0 {
+		return
+	}
+
+	for _, row := range rows {
+		for column, rule := range rules {
+			if value, ok := row[column]; ok {
+				row[column] = rule.Apply(value)
+			}
+		}
+	}
+}
+
+// Apply - Masked value, null values stay null
+func (r MaskRule) Apply(value interface{}) interface{} {
+	if value == nil {
+		return nil
+	}
+
+	switch r.Strategy {
+	case MaskNull:
+		return nil
+	case MaskKeepLast:
+		str := []rune(fmt.Sprint(value))
+		// A negative count would slice past the end, it keeps nothing
+		keep := r.KeepLast
+		if keep < 0 {
+			keep = 0
+		}
+		if keep >= len(str) {
+			return strings.Repeat("*", len(str))
+		}
+		return strings.Repeat("*", len(str)-keep) + string(str[len(str)-keep:])
+	case MaskHash:
+		sum := sha256.Sum256([]byte(r.Salt + fmt.Sprint(value)))
+		return hex.EncodeToString(sum[:])
+	}
+
+	// Unknown strategies redact, a typo must never leak the value
+	return redacted
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMaskRows(t *testing.T) {
+	Entities["mask_test"] = EntityConfig{
+		TableName: "payments",
+		ColumnMasks: map[string]map[string]MaskRule{
+			"card_number": {AnyRole: {Strategy: MaskKeepLast, KeepLast: 4}, "admin": {Strategy: MaskNone}},
+			"email":       {"support": {Strategy: MaskRedact}},
+			"phone":       {"support": {Strategy: MaskNull}},
+			"token":       {AnyRole: {Strategy: MaskHash, Salt: "salt"}},
+		},
+	}
+	defer delete(Entities, "mask_test")
+
+	rows := []map[string]interface{}{
+		{"card_number": "4111111111111111", "email": "a@b.c", "phone": "123", "token": "t", "amount": int64(10)},
+		{"card_number": nil, "email": "d@e.f"},
+	}
+	MaskRows("mask_test", "support", rows)
+
+	assert.Equal(t, "************1111", rows[0]["card_number"])
+	assert.Equal(t, redacted, rows[0]["email"])
+	assert.Nil(t, rows[0]["phone"])
+	assert.Len(t, rows[0]["token"], 64)
+	assert.NotEqual(t, "t", rows[0]["token"])
+	assert.Equal(t, int64(10), rows[0]["amount"])
+	assert.Nil(t, rows[1]["card_number"])
+
+	// A negative count keeps nothing instead of slicing past the end
+	assert.Equal(t, "****", MaskRule{Strategy: MaskKeepLast, KeepLast: -1}.Apply(int64(1234)))
+
+	assert.False(t, IsMasked("mask_test", "card_number", "admin"))
+	assert.True(t, IsMasked("mask_test", "card_number", "merchant"))
+	assert.False(t, IsMasked("mask_test", "email", "merchant"))
+
+	filterable := filterableColumns("mask_test", "support", TableSchema{"card_number": "varchar", "amount": "int"})
+	assert.Equal(t, []string{"amount"}, filterable)
+	assert.Nil(t, CheckFilterable(map[string]interface{}{"amount": int64(1)}, filterable))
+	assert.NotNil(t, CheckFilterable(map[string]interface{}{"card_number": "4111"}, filterable))
+}
+
+func TestEngine_QueryMaskedColumns(t *testing.T) {
+	Entities["mask_payments"] = EntityConfig{
+		ColumnMasks: map[string]map[string]MaskRule{
+			"pin": {"support": {Strategy: MaskKeepLast, KeepLast: 2}},
+		},
+	}
+	defer delete(Entities, "mask_payments")
+
+	dataSource := &fakeDataSource{
+		schema: TableSchema{"id": "int", "pin": "int"},
+		rows:   []map[string]interface{}{{"id": int64(1), "pin": int64(1234)}},
+	}
+	engine := NewEngine(map[string]DataSource{DefaultDataSource: dataSource})
+
+	// Masks of integer columns are strings, an Int field would answer null
+	support := WithSession(context.Background(), NewSession("support", nil))
+	result := engine.Query(support, `{ mask_payments { id pin } }`)
+	assert.Empty(t, result.Errors)
+	assert.Equal(t, map[string]interface{}{"mask_payments": []interface{}{
+		map[string]interface{}{"id": 1, "pin": "**34"},
+	}}, result.Data)
+
+	schema, err := engine.APISchema(support)
+	assert.Nil(t, err)
+	assert.Contains(t, PrintSchema(schema), "type mask_payments {\n  id: Int\n  pin: String\n}")
+
+	result = engine.Query(context.Background(), `{ mask_payments { pin } }`)
+	assert.Empty(t, result.Errors)
+	assert.Equal(t, map[string]interface{}{"mask_payments": []interface{}{
+		map[string]interface{}{"pin": 1234},
+	}}, result.Data)
+}
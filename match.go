@@ -0,0 +1,155 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+)
+
+// MatchFilter - Whether row matches filter, the filter expression of the where argument evaluated
+// in Go like conditions translates it to sql. Columns missing in row match as their value is not
+// known, NULL matches no comparison like in sql.
+func MatchFilter(filter map[string]interface{}, row map[string]interface{}) bool {
+	for field, condition := range filter {
+		value, ok := row[field]
+		if !ok {
+			continue
+		}
+
+		conditionMap, isMap := condition.(map[string]interface{})
+		if !isMap {
+			if !matchOperator(Equal, value, condition) {
+				return false
+			}
+			continue
+		}
+
+		for operator, operand := range conditionMap {
+			if strings.HasPrefix(operator, "_") && !matchOperator(operator, value, operand) {
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
+func matchOperator(operator string, value, operand interface{}) bool {
+	if operator == In {
+		operands, _ := operand.([]interface{})
+		for _, operand := range operands {
+			if cmp, ok := compareValues(value, operand); ok && cmp == 0 {
+				return true
+			}
+		}
+		return false
+	}
+
+	cmp, ok := compareValues(value, operand)
+	if !ok {
+		return false
+	}
+
+	switch operator {
+	case Equal:
+		return cmp == 0
+	case NotEqual:
+		return cmp != 0
+	case GreaterThan:
+		return cmp > 0
+	case GreaterThanEqual:
+		return cmp >= 0
+	case LessThan:
+		return cmp < 0
+	case LessThanEqual:
+		return cmp <= 0
+	}
+
+	return false
+}
+
+// compareValues - Order of a and b, numbers compare by value whatever their Go type, times by
+// instant and anything else as strings, case insensitively like the default collations. False if
+// either is NULL.
+func compareValues(a, b interface{}) (int, bool) {
+	if a == nil || b == nil {
+		return 0, false
+	}
+
+	if at, ok := timeValue(a, b); ok {
+		if bt, ok := timeValue(b, a); ok {
+			switch {
+			case at.Before(bt):
+				return -1, true
+			case at.After(bt):
+				return 1, true
+			}
+			return 0, true
+		}
+	}
+
+	// Strings compare as numbers against numbers only, VARCHAR columns compare as strings in sql
+	if isNumber(a) || isNumber(b) {
+		if an, ok := numericValue(a); ok {
+			if bn, ok := numericValue(b); ok {
+				return an.Cmp(bn), true
+			}
+		}
+	}
+
+	return strings.Compare(strings.ToLower(stringValue(a)), strings.ToLower(stringValue(b))), true
+}
+
+// timeValue - value as time if it or other is a time, strings in the DATETIME format are parsed
+func timeValue(value, other interface{}) (time.Time, bool) {
+	if t, ok := value.(time.Time); ok {
+		return t, true
+	}
+	if _, ok := other.(time.Time); !ok {
+		return time.Time{}, false
+	}
+
+	for _, layout := range []string{"2006-01-02 15:04:05.999999999", time.RFC3339Nano, "2006-01-02"} {
+		if t, err := time.ParseInLocation(layout, stringValue(value), time.UTC); err == nil {
+			return t, true
+		}
+	}
+
+	return time.Time{}, false
+}
+
+func isNumber(value interface{}) bool {
+	switch value.(type) {
+	case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64, float32, float64, json.Number:
+		return true
+	}
+
+	return false
+}
+
+func numericValue(value interface{}) (*big.Float, bool) {
+	switch v := value.(type) {
+	case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64, float32, float64:
+		number, ok := new(big.Float).SetString(fmt.Sprint(v))
+		return number, ok
+	case json.Number:
+		number, ok := new(big.Float).SetString(v.String())
+		return number, ok
+	case string:
+		// DECIMAL columns are decoded as strings
+		number, ok := new(big.Float).SetString(v)
+		return number, ok
+	}
+
+	return nil, false
+}
+
+func stringValue(value interface{}) string {
+	if v, ok := value.([]byte); ok {
+		return string(v)
+	}
+
+	return fmt.Sprint(value)
+}
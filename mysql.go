@@ -1,41 +1,158 @@
 package main
 
 import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io/ioutil"
+	"net"
+	"reflect"
 	"regexp"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 
-	_ "github.com/go-sql-driver/mysql"
+	mysqldriver "github.com/go-sql-driver/mysql"
 )
 
-const ConnectionDSNFormat = "%s:%s@%s(%s:%d)/%s?charset=utf8&parseTime=True&loc=Local"
+// DefaultStatementTimeout - time a statement may run for entities without a timeout of their own
+var DefaultStatementTimeout = 30 * time.Second
 
-var (
-	mysqlOnce sync.Once
-	mysql     *MySql
-)
+// killTimeout - time allowed for KILL QUERY of a cancelled statement
+const killTimeout = 5 * time.Second
 
 type MySql struct {
-	Db *sql.DB
+	Db       *sql.DB
+	replicas *replicaSet
+	// statements - prepared statement cache of every pool, nil if caching is disabled
+	statements map[*sql.DB]*stmtCache
+
+	mu      sync.Mutex
+	schemas map[string]TableSchema
 }
 
+// NewMySql - Open a connection pool configured by c, every call opens a pool of its own. Reads are
+// routed to the replicas of c when it has any.
 func NewMySql(c SqlConfig) (*MySql, error) {
-	var err error
+	db, err := openPool(c)
+	if err != nil {
+		return nil, err
+	}
 
-	mysqlOnce.Do(func() {
-		mysql = &MySql{}
-		mysql.Db, err = sql.Open("mysql", c.GetConnectionPath())
+	mysql := &MySql{Db: db, schemas: map[string]TableSchema{}}
+	if len(c.Replicas) > 0 {
+		mysql.replicas, err = newReplicaSet(c)
 		if err != nil {
-			panic(err)
+			_ = db.Close()
+			return nil, err
+		}
+	}
+
+	if size := c.statementCacheSize(); size > 0 {
+		mysql.statements = map[*sql.DB]*stmtCache{db: newStmtCache(db, size)}
+		if mysql.replicas != nil {
+			for _, replica := range mysql.replicas.replicas {
+				mysql.statements[replica.db] = newStmtCache(replica.db, size)
+			}
+		}
+	}
+
+	return mysql, nil
+}
+
+func openPool(c SqlConfig) (*sql.DB, error) {
+	dsn, err := c.GetConnectionPath()
+	if err != nil {
+		return nil, err
+	}
+
+	connector, err := mysqldriver.MySQLDriver{}.OpenConnector(dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	killer := newKillConnector(connector)
+	db := sql.OpenDB(killer)
+	killer.db = db
+
+	db.SetMaxOpenConns(c.MaxOpenConns)
+	// Zero keeps the database/sql default of 2 idle connections instead of disabling idle ones
+	if c.MaxIdleConns > 0 {
+		db.SetMaxIdleConns(c.MaxIdleConns)
+	}
+	db.SetConnMaxLifetime(c.ConnMaxLifetime)
+	db.SetConnMaxIdleTime(c.ConnMaxIdleTime)
+
+	return db, nil
+}
+
+func (m *MySql) Close() error {
+	for _, cache := range m.statements {
+		cache.purge()
+	}
+	if m.replicas != nil {
+		m.replicas.close()
+	}
+
+	return m.Db.Close()
+}
+
+// reader - Pool reads are run on, a healthy replica unless ctx asks for the primary. The returned
+// done must be called once the read finished.
+func (m *MySql) reader(ctx context.Context) (*sql.DB, func()) {
+	if m.replicas != nil && !isPrimaryRead(ctx) {
+		if replica := m.replicas.pick(); replica != nil {
+			return replica.db, replica.done
 		}
-	})
-	return mysql, err
+	}
+
+	return m.Db, func() {}
 }
 
-func (m MySql) Instance() interface{} {
+// Exec - Run a statement modifying data, always on the primary
+func (m *MySql) Exec(ctx context.Context, statement *Statement) (sql.Result, error) {
+	cache := m.statements[m.Db]
+	if cache == nil {
+		return m.Db.ExecContext(ctx, statement.SQL, statement.Args...)
+	}
+
+	stmt, release, err := cache.prepare(ctx, statement.SQL)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	result, err := stmt.ExecContext(ctx, statement.Args...)
+	if err != nil && isConnectionError(err) {
+		cache.evict(statement.SQL)
+	}
+
+	return result, err
+}
+
+// StatementCacheStats - Counters of the prepared statement caches of all pools
+func (m *MySql) StatementCacheStats() StatementCacheStats {
+	var stats StatementCacheStats
+	for _, cache := range m.statements {
+		stats = stats.add(cache.snapshot())
+	}
+
+	return stats
+}
+
+// InvalidateStatements - Close all prepared statements, they are prepared again on their next use
+func (m *MySql) InvalidateStatements() {
+	for _, cache := range m.statements {
+		cache.purge()
+	}
+}
+
+func (m *MySql) Instance() interface{} {
 	return m.Db
 }
 
@@ -46,10 +163,156 @@ type SqlConfig struct {
 	Password string
 	Name     string
 	Protocol string
+	// Socket - unix socket path, used instead of Host and Port when set
+	Socket string
+
+	// Charset - connection character set, utf8mb4 by default
+	Charset string
+	// Collation - connection collation, utf8mb4_unicode_ci by default
+	Collation string
+	// Location - time zone DATETIME and TIMESTAMP values are parsed in, Local by default
+	Location string
+
+	// Timeout - dial timeout
+	Timeout      time.Duration
+	ReadTimeout  time.Duration
+	WriteTimeout time.Duration
+
+	TLS *TLSConfig
+	// Params - extra driver params appended to the DSN, eg {"time_zone": "'+00:00'"}
+	Params map[string]string
+
+	MaxOpenConns    int
+	MaxIdleConns    int
+	ConnMaxLifetime time.Duration
+	ConnMaxIdleTime time.Duration
+
+	// StatementCacheSize - query shapes kept prepared per pool, DefaultStatementCacheSize if 0 and
+	// statements are not prepared ahead if negative
+	StatementCacheSize int
+
+	// Replicas - read replicas queries are routed to, the settings they leave empty are taken from
+	// the primary
+	Replicas []SqlConfig
+	// ReplicaPolicy - RoundRobin or LeastConnections, RoundRobin by default
+	ReplicaPolicy string
+	// MaxReplicaLag - replicas lagging behind the primary by more are evicted, 0 skips the check
+	MaxReplicaLag time.Duration
+	// HealthCheckInterval - how often replicas are checked, 5s by default
+	HealthCheckInterval time.Duration
+}
+
+type TLSConfig struct {
+	// CAFile - PEM encoded CA certificates to verify the server with instead of the system pool
+	CAFile string
+	// CertFile, KeyFile - PEM encoded client certificate and key
+	CertFile           string
+	KeyFile            string
+	ServerName         string
+	InsecureSkipVerify bool
+}
+
+func (c SqlConfig) statementCacheSize() int {
+	if c.StatementCacheSize == 0 {
+		return DefaultStatementCacheSize
+	}
+
+	return c.StatementCacheSize
+}
+
+// DriverConfig - Driver configuration for c, registers the TLS configuration with the driver
+func (c SqlConfig) DriverConfig() (*mysqldriver.Config, error) {
+	config := mysqldriver.NewConfig()
+	config.User = c.Username
+	config.Passwd = c.Password
+	config.DBName = c.Name
+	config.ParseTime = true
+	config.Timeout = c.Timeout
+	config.ReadTimeout = c.ReadTimeout
+	config.WriteTimeout = c.WriteTimeout
+
+	if c.Socket != "" {
+		config.Net = "unix"
+		config.Addr = c.Socket
+	} else {
+		config.Net = c.Protocol
+		if config.Net == "" {
+			config.Net = "tcp"
+		}
+		config.Addr = net.JoinHostPort(c.Host, strconv.Itoa(c.Port))
+	}
+
+	config.Collation = c.Collation
+	if config.Collation == "" {
+		config.Collation = "utf8mb4_unicode_ci"
+	}
+
+	config.Loc = time.Local
+	if c.Location != "" {
+		location, err := time.LoadLocation(c.Location)
+		if err != nil {
+			return nil, err
+		}
+		config.Loc = location
+	}
+
+	config.Params = map[string]string{"charset": "utf8mb4"}
+	if c.Charset != "" {
+		config.Params["charset"] = c.Charset
+	}
+	for key, value := range c.Params {
+		config.Params[key] = value
+	}
+
+	if c.TLS != nil {
+		name, err := c.TLS.register()
+		if err != nil {
+			return nil, err
+		}
+		config.TLSConfig = name
+	}
+
+	return config, nil
+}
+
+// GetConnectionPath - DSN for c, formatted by the driver so that special characters are escaped
+func (c SqlConfig) GetConnectionPath() (string, error) {
+	config, err := c.DriverConfig()
+	if err != nil {
+		return "", err
+	}
+
+	return config.FormatDSN(), nil
 }
 
-func (c SqlConfig) GetConnectionPath() string {
-	return fmt.Sprintf(ConnectionDSNFormat, c.Username, c.Password, c.Protocol, c.Host, c.Port, c.Name)
+// register - Register the TLS configuration with the driver under a name derived from its settings
+func (t TLSConfig) register() (string, error) {
+	config := &tls.Config{
+		ServerName:         t.ServerName,
+		InsecureSkipVerify: t.InsecureSkipVerify,
+	}
+
+	if t.CAFile != "" {
+		pem, err := ioutil.ReadFile(t.CAFile)
+		if err != nil {
+			return "", err
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(pem) {
+			return "", fmt.Errorf("no certificates in %s", t.CAFile)
+		}
+	}
+
+	if t.CertFile != "" || t.KeyFile != "" {
+		certificate, err := tls.LoadX509KeyPair(t.CertFile, t.KeyFile)
+		if err != nil {
+			return "", err
+		}
+		config.Certificates = []tls.Certificate{certificate}
+	}
+
+	name := "custom-" + digest(fmt.Sprintf("%+v", t))[:16]
+	return name, mysqldriver.RegisterTLSConfig(name, config)
 }
 
 type Result struct {
@@ -70,16 +333,58 @@ func (r Result) MarshalJSON() ([]byte, error) {
 	return serialized, err
 }
 
+// TableSchema - column name to column type as described by the server, eg tinyint(1) or
+// decimal(10,2) unsigned
 type TableSchema map[string]string
 
 var dataTypeRegex = regexp.MustCompile(`^\w+`)
 
-func (m *MySql) GetTableSchema(table string) (TableSchema, error) {
-	rows, err := m.Db.Query("DESC " + table)
+// baseType - Data type of column type without length and attributes, eg decimal for decimal(10,2)
+func baseType(columnType string) string {
+	return dataTypeRegex.FindString(columnType)
+}
+
+// isBoolColumn - Whether the column type is conventionally used for booleans
+func isBoolColumn(columnType string) bool {
+	return strings.HasPrefix(columnType, "tinyint(1)") || strings.HasPrefix(columnType, "bit(1)")
+}
+
+func isUnsignedColumn(columnType string) bool {
+	return strings.Contains(columnType, "unsigned")
+}
+
+func (m *MySql) GetTableSchema(ctx context.Context, table string) (TableSchema, error) {
+	_, schema, err := m.describe(ctx, table)
 	if err != nil {
 		return nil, err
 	}
 
+	m.reloadSchema(table, schema)
+
+	return schema, nil
+}
+
+// GetColumnNames - Columns of table in the order of their position, rows of binlog events hold
+// their values in this order
+func (m *MySql) GetColumnNames(ctx context.Context, table string) ([]string, error) {
+	columns, schema, err := m.describe(ctx, table)
+	if err != nil {
+		return nil, err
+	}
+
+	m.reloadSchema(table, schema)
+
+	return columns, nil
+}
+
+func (m *MySql) describe(ctx context.Context, table string) ([]string, TableSchema, error) {
+	rows, err := m.Db.QueryContext(ctx, "DESC "+table)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	var columns []string
 	schema := TableSchema{}
 	for rows.Next() {
 		var (
@@ -89,64 +394,218 @@ func (m *MySql) GetTableSchema(table string) (TableSchema, error) {
 		)
 
 		if err := rows.Scan(&fieldName, &fieldType, &ignore, &ignore, &ignore, &ignore); err != nil {
-			return nil, err
+			return nil, nil, err
 		}
-		t := dataTypeRegex.FindAllString(fieldType, 1)
-		schema[fieldName] = t[0]
+		columns = append(columns, fieldName)
+		schema[fieldName] = strings.ToLower(fieldType)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, nil, err
 	}
 
-	return schema, nil
+	return columns, schema, nil
 }
 
-func (m *MySql) Fetch(query string, limit int) (*Result, error) {
-	sqlConn := m.Instance().(*sql.DB)
+// BinlogPosition - Current end of the binlog of the primary
+func (m *MySql) BinlogPosition(ctx context.Context) (BinlogPosition, error) {
+	rows, err := m.Db.QueryContext(ctx, "SHOW MASTER STATUS")
+	if err != nil {
+		return BinlogPosition{}, err
+	}
+	defer rows.Close()
 
-	rows, err := sqlConn.Query(query)
+	cols, err := rows.Columns()
+	if err != nil {
+		return BinlogPosition{}, err
+	}
+
+	if !rows.Next() {
+		if err := rows.Err(); err != nil {
+			return BinlogPosition{}, err
+		}
+		return BinlogPosition{}, errors.New("binary logging is not enabled")
+	}
+
+	values := make([]sql.RawBytes, len(cols))
+	valuePtr := make([]interface{}, len(cols))
+	for idx := range values {
+		valuePtr[idx] = &values[idx]
+	}
+	if err := rows.Scan(valuePtr...); err != nil {
+		return BinlogPosition{}, err
+	}
+
+	var position BinlogPosition
+	for idx, column := range cols {
+		switch column {
+		case "File":
+			position.Name = string(values[idx])
+		case "Position":
+			pos, err := strconv.ParseUint(string(values[idx]), 10, 32)
+			if err != nil {
+				return BinlogPosition{}, err
+			}
+			position.Pos = uint32(pos)
+		}
+	}
+
+	return position, nil
+}
+
+// Tables - Tables of the database in alphabetical order
+func (m *MySql) Tables(ctx context.Context) ([]string, error) {
+	rows, err := m.Db.QueryContext(ctx, "SHOW TABLES")
 	if err != nil {
 		return nil, err
 	}
+	defer rows.Close()
+
+	var tables []string
+	for rows.Next() {
+		var table string
+		if err := rows.Scan(&table); err != nil {
+			return nil, err
+		}
+		tables = append(tables, table)
+	}
+
+	return tables, rows.Err()
+}
+
+// Explain - Execution plan of statement as reported by EXPLAIN, its column names and rows with NULL
+// values as "NULL"
+func (m *MySql) Explain(ctx context.Context, statement *Statement) ([]string, [][]string, error) {
+	rows, err := m.Db.QueryContext(ctx, "EXPLAIN "+strings.TrimSuffix(statement.SQL, ";"), statement.Args...)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
 
 	cols, err := rows.Columns()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var plan [][]string
+	for rows.Next() {
+		values := make([]sql.NullString, len(cols))
+		valuePtr := make([]interface{}, len(cols))
+		for idx := range values {
+			valuePtr[idx] = &values[idx]
+		}
+		if err := rows.Scan(valuePtr...); err != nil {
+			return nil, nil, err
+		}
+
+		row := make([]string, len(cols))
+		for idx, value := range values {
+			row[idx] = "NULL"
+			if value.Valid {
+				row[idx] = value.String
+			}
+		}
+		plan = append(plan, row)
+	}
+
+	return cols, plan, rows.Err()
+}
+
+// reloadSchema - Remember schema of table, prepared statements are invalidated once it changed as
+// the columns of their results would be stale
+func (m *MySql) reloadSchema(table string, schema TableSchema) {
+	m.mu.Lock()
+	previous, known := m.schemas[table]
+	m.schemas[table] = schema
+	m.mu.Unlock()
+
+	if known && !reflect.DeepEqual(previous, schema) {
+		m.InvalidateStatements()
+	}
+}
+
+// GetPrimaryKey - Columns of the primary key of table in index order
+func (m *MySql) GetPrimaryKey(ctx context.Context, table string) ([]string, error) {
+	rows, err := m.Db.QueryContext(ctx, "SHOW KEYS FROM `"+table+"` WHERE Key_name = 'PRIMARY'")
 	if err != nil {
 		return nil, err
 	}
+	defer rows.Close()
 
-	rowCount := 0
-	var rowSet []map[string]interface{}
+	cols, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
 
+	var key []string
 	for rows.Next() {
-		columns := make([]interface{}, len(cols))
-		columnPtr := make([]interface{}, len(cols))
-		for i, _ := range columns {
-			columnPtr[i] = &columns[i]
+		values := make([]sql.RawBytes, len(cols))
+		valuePtr := make([]interface{}, len(cols))
+		for idx := range values {
+			valuePtr[idx] = &values[idx]
 		}
-
-		if err := rows.Scan(columnPtr...); err != nil {
+		if err := rows.Scan(valuePtr...); err != nil {
 			return nil, err
 		}
 
-		row := map[string]interface{}{}
-		for i, colName := range cols {
-			val := columnPtr[i].(*interface{})
-			row[colName] = *val
+		// Rows come in Seq_in_index order
+		for idx, column := range cols {
+			if column == "Column_name" {
+				key = append(key, string(values[idx]))
+			}
 		}
-		rowSet = append(rowSet, row)
-		rowCount += 1
 	}
 
-	return &Result{
-		Rows:  rowSet,
-		Count: rowCount,
-	}, err
+	return key, rows.Err()
 }
 
-func (m *MySql) FetchScan(query string, limit int) (*Result, error) {
-	sqlConn := m.Instance().(*sql.DB)
+// query - Run statement on the pool reads are routed to, prepared once per query shape unless
+// statement caching is disabled. Statements are killed on the server when ctx is done before they
+// are, see killConnector. The returned release closes rows.
+func (m *MySql) query(ctx context.Context, statement *Statement) (*sql.Rows, func(), error) {
+	db, done := m.reader(ctx)
+
+	var (
+		rows *sql.Rows
+		err  error
+	)
+	if cache := m.statements[db]; cache != nil {
+		var (
+			stmt    *sql.Stmt
+			release func()
+		)
+		stmt, release, err = cache.prepare(ctx, statement.SQL)
+		if err == nil {
+			rows, err = stmt.QueryContext(ctx, statement.Args...)
+			// The statement is not closed under the rows, see stmtCache
+			previous := done
+			done = func() {
+				release()
+				previous()
+			}
+		}
+		if err != nil && isConnectionError(err) {
+			cache.evict(statement.SQL)
+		}
+	} else {
+		rows, err = db.QueryContext(ctx, statement.SQL, statement.Args...)
+	}
+	if err != nil {
+		done()
+		return nil, nil, err
+	}
+
+	return rows, func() {
+		_ = rows.Close()
+		done()
+	}, nil
+}
 
-	rows, err := sqlConn.Query(query)
+func (m *MySql) Fetch(ctx context.Context, statement *Statement, limit int) (*Result, error) {
+	rows, release, err := m.query(ctx, statement)
 	if err != nil {
 		return nil, err
 	}
+	defer release()
 
 	cols, err := rows.Columns()
 	if err != nil {
@@ -155,21 +614,22 @@ func (m *MySql) FetchScan(query string, limit int) (*Result, error) {
 
 	rowCount := 0
 	var rowSet []map[string]interface{}
-	for rows.Next() {
+
+	for (limit <= 0 || rowCount < limit) && rows.Next() {
+		columns := make([]interface{}, len(cols))
 		columnPtr := make([]interface{}, len(cols))
-		for idx, _ := range cols {
-			columnPtr[idx] = new(ColValueScanner)
+		for i, _ := range columns {
+			columnPtr[i] = &columns[i]
 		}
 
-		err := rows.Scan(columnPtr...)
-		if err != nil {
+		if err := rows.Scan(columnPtr...); err != nil {
 			return nil, err
 		}
 
 		row := map[string]interface{}{}
-		for idx, column := range cols {
-			var scanner = columnPtr[idx].(*ColValueScanner)
-			row[column] = scanner.value
+		for i, colName := range cols {
+			val := columnPtr[i].(*interface{})
+			row[colName] = *val
 		}
 		rowSet = append(rowSet, row)
 		rowCount += 1
@@ -178,46 +638,35 @@ func (m *MySql) FetchScan(query string, limit int) (*Result, error) {
 	return &Result{
 		Rows:  rowSet,
 		Count: rowCount,
-	}, err
+	}, rows.Err()
 }
 
-type ColValueScanner struct {
-	value interface{}
+// Stream - Iterator over the rows of statement with values converted to precise Go types by the column
+// types of the result and the introspected schema of the table, schema may be nil. The iterator
+// stops after limit rows, no limit if 0, and must be closed to release the connection.
+func (m *MySql) Stream(ctx context.Context, statement *Statement, limit int, schema TableSchema) (RowIterator, error) {
+	rows, release, err := m.query(ctx, statement)
+	if err != nil {
+		return nil, err
+	}
+
+	return newSqlRowIterator(rows, release, limit, schema)
 }
 
-func (scanner *ColValueScanner) getBytes(src interface{}) []byte {
-	if a, ok := src.([]uint8); ok {
-		return a
+// FetchScan - Fetch at most limit rows of statement into memory, see Stream
+func (m *MySql) FetchScan(ctx context.Context, statement *Statement, limit int, schema TableSchema) (*Result, error) {
+	iterator, err := m.Stream(ctx, statement, limit, schema)
+	if err != nil {
+		return nil, err
 	}
-	return nil
-}
 
-func (scanner *ColValueScanner) Scan(src interface{}) error {
-	switch src.(type) {
-	case int64:
-		if value, ok := src.(int64); ok {
-			scanner.value = value
-		}
-	case float64:
-		if value, ok := src.(float64); ok {
-			scanner.value = value
-		}
-	case bool:
-		if value, ok := src.(bool); ok {
-			scanner.value = value
-		}
-	case string:
-		value := scanner.getBytes(src)
-		scanner.value = string(value)
-	case []byte:
-		value := string(scanner.getBytes(src))
-		scanner.value = value
-	case time.Time:
-		if value, ok := src.(time.Time); ok {
-			scanner.value = value
-		}
-	case nil:
-		scanner.value = nil
+	rows, err := CollectRows(iterator)
+	if err != nil {
+		return nil, err
 	}
-	return nil
+
+	return &Result{
+		Rows:  rows,
+		Count: len(rows),
+	}, nil
 }
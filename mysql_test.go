@@ -1,9 +1,12 @@
 package main
 
 import (
+	"context"
 	"fmt"
+	mysqldriver "github.com/go-sql-driver/mysql"
 	"github.com/stretchr/testify/assert"
 	"testing"
+	"time"
 )
 
 var defaultConf = SqlConfig{
@@ -19,8 +22,39 @@ func TestMySql_GetTableSchema(t *testing.T) {
 	mysql, err := NewMySql(defaultConf)
 	assert.Nil(t, err)
 
-	schema, err := mysql.GetTableSchema("payments")
+	schema, err := mysql.GetTableSchema(context.Background(), "payments")
 	assert.Nil(t, err)
 
 	fmt.Printf("Schema %+v\n", schema)
 }
+
+func TestSqlConfig_GetConnectionPath(t *testing.T) {
+	dsn, err := SqlConfig{
+		Host:         "db.local",
+		Port:         3306,
+		Username:     "user",
+		Password:     "p@ss/w:rd?",
+		Name:         "payments",
+		Location:     "UTC",
+		ReadTimeout:  5 * time.Second,
+		Params:       map[string]string{"time_zone": "'+00:00'"},
+		MaxOpenConns: 10,
+	}.GetConnectionPath()
+	assert.Nil(t, err)
+
+	config, err := mysqldriver.ParseDSN(dsn)
+	assert.Nil(t, err)
+	assert.Equal(t, "p@ss/w:rd?", config.Passwd)
+	assert.Equal(t, "db.local:3306", config.Addr)
+	assert.Equal(t, "tcp", config.Net)
+	assert.Equal(t, "utf8mb4_unicode_ci", config.Collation)
+	assert.Equal(t, time.UTC, config.Loc)
+	assert.Equal(t, 5*time.Second, config.ReadTimeout)
+	assert.Equal(t, "utf8mb4", config.Params["charset"])
+	assert.Equal(t, "'+00:00'", config.Params["time_zone"])
+	assert.True(t, config.ParseTime)
+
+	dsn, err = SqlConfig{Socket: "/var/run/mysqld/mysqld.sock", Username: "user", Name: "payments"}.GetConnectionPath()
+	assert.Nil(t, err)
+	assert.Contains(t, dsn, "unix(/var/run/mysqld/mysqld.sock)")
+}
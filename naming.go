@@ -0,0 +1,195 @@
+package main
+
+import (
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// NamingStrategy - how GraphQL names are derived from the names of tables and columns, the names
+// are kept as they are if empty
+type NamingStrategy string
+
+// Naming strategies
+const (
+	NamingNone   NamingStrategy = "none"
+	NamingSnake  NamingStrategy = "snake"
+	NamingCamel  NamingStrategy = "camel"
+	NamingPascal NamingStrategy = "pascal"
+)
+
+// NamingConfig - naming strategies of an entity, eg {Types: NamingPascal, Fields: NamingCamel}
+// turns the entity payment_refunds into the type PaymentRefunds queried by the field
+// paymentRefunds, with a createdAt field for its created_at column
+type NamingConfig struct {
+	// Types - strategy of the object and input type names, derived from the entity name
+	Types NamingStrategy `yaml:"types"`
+	// Fields - strategy of the query field and the column field names
+	Fields NamingStrategy `yaml:"fields"`
+}
+
+// Valid - Whether the strategy is one of the naming strategies
+func (s NamingStrategy) Valid() bool {
+	switch s {
+	case "", NamingNone, NamingSnake, NamingCamel, NamingPascal:
+		return true
+	}
+
+	return false
+}
+
+// Apply - name in the case of the strategy, eg payment_refunds is paymentRefunds in camel case
+func (s NamingStrategy) Apply(name string) string {
+	switch s {
+	case NamingSnake:
+		return strings.Join(nameWords(name), "_")
+	case NamingCamel, NamingPascal:
+		var builder strings.Builder
+		for idx, word := range nameWords(name) {
+			if idx == 0 && s == NamingCamel {
+				builder.WriteString(word)
+				continue
+			}
+			runes := []rune(word)
+			builder.WriteString(string(unicode.ToUpper(runes[0])) + string(runes[1:]))
+		}
+		return builder.String()
+	}
+
+	return name
+}
+
+// nameWords - Lower case words of name, which are separated by anything but letters and digits
+// or start with an upper case letter, eg HTTPStatus_code is http, status and code
+func nameWords(name string) []string {
+	var (
+		words []string
+		word  []rune
+	)
+	runes := []rune(name)
+	for idx, r := range runes {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			if len(word) > 0 {
+				words, word = append(words, string(word)), nil
+			}
+			continue
+		}
+
+		// An upper case letter starts a word unless it continues an acronym
+		if unicode.IsUpper(r) && len(word) > 0 {
+			previous := runes[idx-1]
+			nextLower := idx+1 < len(runes) && unicode.IsLower(runes[idx+1])
+			if !unicode.IsUpper(previous) || nextLower {
+				words, word = append(words, string(word)), nil
+			}
+		}
+		word = append(word, unicode.ToLower(r))
+	}
+	if len(word) > 0 {
+		words = append(words, string(word))
+	}
+
+	return words
+}
+
+// GetTypeName - Name of the object type of entity, the type name it is configured with or the
+// entity name in the case of its type naming strategy
+func (e EntityConfigs) GetTypeName(entity string) string {
+	if name := e[entity].TypeName; name != "" {
+		return name
+	}
+
+	return e[entity].Naming.Types.Apply(entity)
+}
+
+// GetInputTypeName - Name of the input type of entity for the argument named argument, eg
+// payments_where, or PaymentsOrderBy for pascal case types, which is unique across entities as
+// their type names are
+func (e EntityConfigs) GetInputTypeName(entity, argument string) string {
+	return e[entity].Naming.Types.Apply(e.GetTypeName(entity) + "_" + argument)
+}
+
+// GetFieldName - Name of the field of column on the object type of entity, the name it is
+// configured with or the column name in the case of the field naming strategy of entity
+func (e EntityConfigs) GetFieldName(entity, column string) string {
+	if name, ok := e[entity].ColumnNames[column]; ok {
+		return name
+	}
+
+	return e[entity].Naming.Fields.Apply(column)
+}
+
+// fieldColumns - Columns of tableSchema by the name of their field on entity. Of columns which
+// get the same name the one named like the field, or else the first in alphabetical order, has it,
+// see CheckEntities for the others.
+func fieldColumns(entity string, tableSchema TableSchema) map[string]string {
+	columns := make([]string, 0, len(tableSchema))
+	for column := range tableSchema {
+		columns = append(columns, column)
+	}
+	sort.Strings(columns)
+
+	fields := make(map[string]string, len(columns))
+	for _, column := range columns {
+		if Entities.GetFieldName(entity, column) == column {
+			fields[column] = column
+		}
+	}
+	for _, column := range columns {
+		name := Entities.GetFieldName(entity, column)
+		if _, ok := fields[name]; !ok {
+			fields[name] = column
+		}
+	}
+
+	return fields
+}
+
+// columnFieldNames - Names of the fields of the columns of entity, columns without a field are left out
+func columnFieldNames(entity string, columns []string, tableSchema TableSchema) []string {
+	names := map[string]string{}
+	for name, column := range fieldColumns(entity, tableSchema) {
+		names[column] = name
+	}
+
+	var fields []string
+	for _, column := range columns {
+		if name, ok := names[column]; ok {
+			fields = append(fields, name)
+		}
+	}
+
+	return fields
+}
+
+// columnArguments - arguments named by the fields of entity with the names of the columns instead,
+// the Querier only knows columns. Relations keep their names.
+func columnArguments(entity string, tableSchema TableSchema, arguments QueryArguments) QueryArguments {
+	columns := fieldColumns(entity, tableSchema)
+	column := func(name string) string {
+		if column, ok := columns[name]; ok {
+			return column
+		}
+		return name
+	}
+
+	translated := QueryArguments{}
+	if arguments.Filter != nil {
+		translated.Filter = make(map[string]interface{}, len(arguments.Filter))
+		for name, condition := range arguments.Filter {
+			translated.Filter[column(name)] = condition
+		}
+	}
+	for _, criteria := range arguments.OrderBy {
+		order := make(map[string]interface{}, len(criteria))
+		for name, direction := range criteria {
+			order[column(name)] = direction
+		}
+		translated.OrderBy = append(translated.OrderBy, order)
+	}
+	for _, name := range arguments.Fields {
+		translated.Fields = append(translated.Fields, column(name))
+	}
+
+	return translated
+}
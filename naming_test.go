@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNamingStrategy_Apply(t *testing.T) {
+	tests := []struct {
+		strategy NamingStrategy
+		name     string
+		expected string
+	}{
+		{"", "payment_refunds", "payment_refunds"},
+		{NamingNone, "createdAt", "createdAt"},
+		{NamingCamel, "payment_refunds", "paymentRefunds"},
+		{NamingCamel, "PaymentRefund", "paymentRefund"},
+		{NamingCamel, "merchant_id", "merchantId"},
+		{NamingPascal, "payment_refunds", "PaymentRefunds"},
+		{NamingPascal, "Payment_where", "PaymentWhere"},
+		{NamingPascal, "address_line2", "AddressLine2"},
+		{NamingSnake, "createdAt", "created_at"},
+		{NamingSnake, "HTTPStatus-code", "http_status_code"},
+		{NamingSnake, "userID", "user_id"},
+	}
+	for _, test := range tests {
+		assert.Equal(t, test.expected, test.strategy.Apply(test.name), "%s %s", test.strategy, test.name)
+	}
+}
+
+func TestEngine_QueryNaming(t *testing.T) {
+	Entities["payment_refunds"] = EntityConfig{
+		Naming:      NamingConfig{Types: NamingPascal, Fields: NamingCamel},
+		TypeName:    "PaymentRefund",
+		ColumnNames: map[string]string{"card_number": "card"},
+		Relations: map[string]Relation{
+			"payment": {Entity: "naming_payments", Column: "payment_id", RelatedColumn: "id"},
+		},
+	}
+	Entities["naming_payments"] = EntityConfig{Naming: NamingConfig{Types: NamingPascal, Fields: NamingCamel}}
+	defer delete(Entities, "payment_refunds")
+	defer delete(Entities, "naming_payments")
+
+	dataSource := &fakeDataSource{
+		schema: TableSchema{"id": "int", "payment_id": "int", "created_at": "int", "card_number": "varchar"},
+		rows:   []map[string]interface{}{{"id": int64(1), "payment_id": int64(1), "created_at": int64(5), "card_number": "4111"}},
+	}
+	engine := NewEngine(map[string]DataSource{DefaultDataSource: dataSource})
+
+	result := engine.Query(context.Background(), `{
+		paymentRefunds(where: {createdAt: {_gt: 1}}, order_by: [{createdAt: "desc"}]) { __typename id createdAt card payment { paymentId } }
+	}`)
+	assert.Empty(t, result.Errors)
+	assert.Equal(t, map[string]interface{}{"paymentRefunds": []interface{}{map[string]interface{}{
+		"__typename": "PaymentRefund",
+		"id":         1,
+		"createdAt":  5,
+		"card":       "4111",
+		"payment":    map[string]interface{}{"paymentId": 1},
+	}}}, result.Data)
+	// The columns are named as in the database in SQL
+	assert.Contains(t, dataSource.queries[0].SQL, "`id`, `created_at`, `card_number`, `payment_id` FROM `payment_refunds` WHERE `created_at` > ? ORDER BY `created_at` desc")
+
+	// Columns are only known by the names of their fields
+	result = engine.Query(context.Background(), `{ paymentRefunds(where: {created_at: {_gt: 1}}) { id } }`)
+	if assert.Len(t, result.Errors, 1) {
+		assert.Equal(t, FilterNotAllowed, result.Errors[0].Extensions["code"])
+	}
+	result = engine.Query(context.Background(), `{ paymentRefunds { card_number } }`)
+	assert.NotEmpty(t, result.Errors)
+
+	// The input types of the entities do not collide
+	schema, err := engine.APISchema(context.Background())
+	assert.Nil(t, err)
+	for _, name := range []string{"PaymentRefund", "PaymentRefundWhere", "PaymentRefundOrderBy", "NamingPayments", "NamingPaymentsWhere"} {
+		assert.NotNil(t, schema.Type(name), name)
+	}
+	assert.NotNil(t, schema.QueryType().Fields()["namingPayments"])
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+)
+
+var ErrAccessDenied = errors.New("access denied")
+
+// RowFilter - Row level filter for entity with session variables of the caller substituted in
+func RowFilter(entity string, session *Session) (map[string]interface{}, error) {
+	filter, ok := Entities.GetRowFilter(entity, session.Role)
+	if !ok {
+		return nil, fmt.Errorf("%w: role %q can not access %q", ErrAccessDenied, session.Role, entity)
+	}
+
+	resolved, err := resolveSessionVariables(filter, session)
+	if err != nil {
+		return nil, err
+	}
+
+	return resolved.(map[string]interface{}), nil
+}
+
+// resolveSessionVariables - Replace session variable references in the filter expression by their
+// value, a reference to a variable missing in the session denies access instead of matching nothing
+func resolveSessionVariables(expression interface{}, session *Session) (interface{}, error) {
+	switch value := expression.(type) {
+	case map[string]interface{}:
+		resolved := map[string]interface{}{}
+		for key, nested := range value {
+			nestedValue, err := resolveSessionVariables(nested, session)
+			if err != nil {
+				return nil, err
+			}
+			resolved[key] = nestedValue
+		}
+		return resolved, nil
+	case []interface{}:
+		resolved := make([]interface{}, len(value))
+		for idx, nested := range value {
+			nestedValue, err := resolveSessionVariables(nested, session)
+			if err != nil {
+				return nil, err
+			}
+			resolved[idx] = nestedValue
+		}
+		return resolved, nil
+	case string:
+		if !isSessionVariable(value) {
+			return value, nil
+		}
+		if variable, ok := session.Get(value); ok {
+			return variable, nil
+		}
+		return nil, fmt.Errorf("%w: session variable %q is missing", ErrAccessDenied, value)
+	}
+
+	return expression, nil
+}
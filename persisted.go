@@ -0,0 +1,225 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// PersistedQueryTTL - time an automatic persisted query is kept after it was registered
+const PersistedQueryTTL = 24 * time.Hour
+
+// Persisted query error messages, clients of the Automatic Persisted Queries protocol match on them
+const (
+	PersistedQueryNotFound     = "PersistedQueryNotFound"
+	PersistedQueryNotSupported = "PersistedQueryNotSupported"
+)
+
+// Persisted query error codes reported in the extensions of the GraphQL error
+const (
+	PersistedQueryNotFoundCode     = "PERSISTED_QUERY_NOT_FOUND"
+	PersistedQueryNotSupportedCode = "PERSISTED_QUERY_NOT_SUPPORTED"
+	PersistedQueryHashMismatch     = "PERSISTED_QUERY_HASH_MISMATCH"
+	QueryNotAllowed                = "QUERY_NOT_ALLOWED"
+)
+
+// PersistedQuery - persistedQuery extension of a request, the query is identified by the hex
+// encoded sha256 of its text
+type PersistedQuery struct {
+	Version    int    `json:"version"`
+	Sha256Hash string `json:"sha256Hash"`
+}
+
+type PersistedQueryError struct {
+	Status  int
+	Code    string
+	Message string
+}
+
+func (e *PersistedQueryError) Error() string {
+	return e.Message
+}
+
+func (e *PersistedQueryError) Extensions() map[string]interface{} {
+	return map[string]interface{}{"code": e.Code}
+}
+
+// queryHash - Hex encoded sha256 of query identifying it as persisted query
+func queryHash(query string) string {
+	sum := sha256.Sum256([]byte(query))
+	return hex.EncodeToString(sum[:])
+}
+
+// Allowlist - Queries allowed to run by their hash, loaded from a file which is reloaded when it
+// changes. The file is a JSON object of hashes to queries or a JSON array of queries.
+type Allowlist struct {
+	path string
+
+	mu      sync.RWMutex
+	queries map[string]string
+	modTime time.Time
+}
+
+// LoadAllowlist - Allowlist of the queries in the file at path
+func LoadAllowlist(path string) (*Allowlist, error) {
+	allowlist := &Allowlist{path: path}
+	if err := allowlist.Reload(); err != nil {
+		return nil, err
+	}
+
+	return allowlist, nil
+}
+
+// Query - Allowed query with hash
+func (a *Allowlist) Query(hash string) (string, bool) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	query, ok := a.queries[hash]
+	return query, ok
+}
+
+// Reload - Read the file again, the queries loaded before are kept if it is invalid
+func (a *Allowlist) Reload() error {
+	info, err := os.Stat(a.path)
+	if err != nil {
+		return err
+	}
+
+	content, err := ioutil.ReadFile(a.path)
+	if err != nil {
+		return err
+	}
+
+	queries, err := parseAllowlist(content)
+	if err != nil {
+		return fmt.Errorf("invalid allowlist %s: %w", a.path, err)
+	}
+
+	a.mu.Lock()
+	a.queries = queries
+	a.modTime = info.ModTime()
+	a.mu.Unlock()
+
+	return nil
+}
+
+// Watch - Reload the file whenever its modification time changed, checked every interval until
+// stop is called
+func (a *Allowlist) Watch(interval time.Duration) (stop func()) {
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				info, err := os.Stat(a.path)
+				if err != nil {
+					log.Printf("failed to check allowlist %s, error: %v", a.path, err)
+					continue
+				}
+
+				a.mu.RLock()
+				changed := !info.ModTime().Equal(a.modTime)
+				a.mu.RUnlock()
+				if !changed {
+					continue
+				}
+
+				if err := a.Reload(); err != nil {
+					log.Printf("failed to reload allowlist, error: %v", err)
+				}
+			}
+		}
+	}()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() { close(done) })
+	}
+}
+
+func parseAllowlist(content []byte) (map[string]string, error) {
+	queries := map[string]string{}
+
+	var list []string
+	if err := json.Unmarshal(content, &list); err == nil {
+		for _, query := range list {
+			queries[queryHash(query)] = query
+		}
+		return queries, nil
+	}
+
+	var manifest map[string]string
+	if err := json.Unmarshal(content, &manifest); err != nil {
+		return nil, err
+	}
+	for hash, query := range manifest {
+		if queryHash(query) != hash {
+			return nil, fmt.Errorf("hash %s does not match its query", hash)
+		}
+		queries[hash] = query
+	}
+
+	return queries, nil
+}
+
+// persistedQuery - Text of the query to run for request. With an allowlist only its queries are
+// returned. Otherwise a request with a persisted query extension and without query text looks it
+// up in store, with query text the query is registered in store.
+func persistedQuery(request *GraphQLRequest, store ResultCache, allowlist *Allowlist) (string, error) {
+	var hash string
+	if extension := request.Extensions.PersistedQuery; extension != nil {
+		if extension.Version != 1 {
+			return "", &PersistedQueryError{http.StatusBadRequest, PersistedQueryNotSupportedCode, PersistedQueryNotSupported}
+		}
+
+		hash = extension.Sha256Hash
+		if request.Query != "" && queryHash(request.Query) != hash {
+			return "", &PersistedQueryError{http.StatusBadRequest, PersistedQueryHashMismatch, "provided sha does not match query"}
+		}
+	}
+
+	if allowlist != nil {
+		if hash == "" {
+			hash = queryHash(request.Query)
+		}
+
+		query, ok := allowlist.Query(hash)
+		if !ok {
+			return "", &PersistedQueryError{http.StatusForbidden, QueryNotAllowed, "query is not in the allowlist"}
+		}
+		return query, nil
+	}
+
+	if hash == "" {
+		return request.Query, nil
+	}
+
+	if store == nil {
+		return "", &PersistedQueryError{http.StatusBadRequest, PersistedQueryNotSupportedCode, PersistedQueryNotSupported}
+	}
+
+	if request.Query == "" {
+		query, ok := store.Get(hash)
+		if !ok {
+			// Clients answer with the full query, so this is no failure of the request
+			return "", &PersistedQueryError{http.StatusOK, PersistedQueryNotFoundCode, PersistedQueryNotFound}
+		}
+		return string(query), nil
+	}
+
+	store.Set(hash, []byte(request.Query), PersistedQueryTTL, nil)
+	return request.Query, nil
+}
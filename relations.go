@@ -0,0 +1,366 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/language/ast"
+)
+
+// Relation - Entity related to the rows of an entity, rows relate when Column of the row equals
+// RelatedColumn of the related row
+type Relation struct {
+	Entity        string `yaml:"entity"`
+	Column        string `yaml:"column"`
+	RelatedColumn string `yaml:"related_column"`
+	// Many - a row relates to a list of rows instead of at most one
+	Many bool `yaml:"many"`
+}
+
+// relationKeyPrefix - prefix of the row entries keeping the value a relation joins on before the
+// row is masked, they are never selectable as no field has such a name
+const relationKeyPrefix = "__relation_key_"
+
+// objectTypes - Object type of every entity in schemas as seen by role, with fields for its columns
+// and its relations to the other entities in schemas
+func (e *Engine) objectTypes(schemas map[string]TableSchema, role string) map[string]*graphql.Object {
+	types := map[string]*graphql.Object{}
+
+	for entity, schema := range schemas {
+		entity, schema := entity, schema
+		types[entity] = graphql.NewObject(graphql.ObjectConfig{
+			Name: Entities.GetTypeName(entity),
+			// Fields are resolved lazily as relations may refer to each other
+			Fields: graphql.FieldsThunk(func() graphql.Fields {
+				fields := graphql.Fields{}
+				for name, column := range fieldColumns(entity, schema) {
+					fieldType := columnDatatype(schema[column])
+					// Masked values are strings, as a number or a date they would be coerced to null
+					if IsMasked(entity, column, role) {
+						fieldType = graphql.String
+					}
+					fields[name] = &graphql.Field{
+						Type:    fieldType,
+						Resolve: columnResolver(column),
+					}
+				}
+				for name, computed := range Entities.GetComputedFields(entity) {
+					if _, ok := fields[name]; ok {
+						continue
+					}
+					fields[name] = &graphql.Field{
+						Type:    columnDatatype(computed.ColumnType()),
+						Resolve: computedResolver(entity, name),
+					}
+				}
+
+				for name, relation := range Entities.GetRelations(entity) {
+					related, ok := types[relation.Entity]
+					if !ok {
+						continue
+					}
+					if _, ok := fields[name]; ok {
+						continue
+					}
+					if _, ok := schema[relation.Column]; !ok {
+						continue
+					}
+					if _, ok := schemas[relation.Entity][relation.RelatedColumn]; !ok {
+						continue
+					}
+
+					field := &graphql.Field{
+						Type:    related,
+						Resolve: e.relationResolver(entity, name, relation, schemas[relation.Entity]),
+					}
+					if relation.Many {
+						field.Type = graphql.NewList(related)
+						field.Args = graphql.FieldConfigArgument{"limit": relationLimit}
+					}
+					fields[name] = field
+				}
+
+				return fields
+			}),
+		})
+	}
+
+	return types
+}
+
+// relationLimit - limit argument of the relations to lists of rows, the rows fetched per row
+var relationLimit = &graphql.ArgumentConfig{
+	Type:         graphql.Int,
+	DefaultValue: DefaultLimit,
+	Description:  "Limit no of rows returned per row by some value",
+}
+
+// columnResolver - Resolve the field of column from the row it was fetched in, rows keep the names
+// of the columns whatever the names of their fields
+func columnResolver(column string) graphql.FieldResolveFn {
+	return func(params graphql.ResolveParams) (interface{}, error) {
+		row, _ := params.Source.(map[string]interface{})
+		return row[column], nil
+	}
+}
+
+// relatedSchemas - Add the table schemas of the entities reached through the relations selected
+// on field to schemas
+func (e *Engine) relatedSchemas(ctx context.Context, entity string, field *ast.Field, schemas map[string]TableSchema) error {
+	if field == nil || field.SelectionSet == nil {
+		return nil
+	}
+
+	relations := Entities.GetRelations(entity)
+	for _, selection := range field.SelectionSet.Selections {
+		child, ok := selection.(*ast.Field)
+		if !ok {
+			continue
+		}
+		relation, ok := relations[child.Name.Value]
+		if !ok {
+			continue
+		}
+
+		if _, ok := schemas[relation.Entity]; !ok {
+			schema, err := e.entitySchema(ctx, relation.Entity)
+			if err != nil {
+				return err
+			}
+			schemas[relation.Entity] = schema
+		}
+
+		if err := e.relatedSchemas(ctx, relation.Entity, child, schemas); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// relatedEntities - Entities reached through the relations selected on field of entity
+func relatedEntities(entity string, field *ast.Field) []string {
+	if field == nil || field.SelectionSet == nil {
+		return nil
+	}
+
+	var entities []string
+	relations := Entities.GetRelations(entity)
+	for _, selection := range field.SelectionSet.Selections {
+		child, ok := selection.(*ast.Field)
+		if !ok {
+			continue
+		}
+		if relation, ok := relations[child.Name.Value]; ok {
+			entities = append(entities, relation.Entity)
+			entities = append(entities, relatedEntities(relation.Entity, child)...)
+		}
+	}
+
+	return entities
+}
+
+// relationResolver - Resolve the relation name of entity through a loader, so that the related
+// rows of all rows of a level are fetched with a single query
+func (e *Engine) relationResolver(entity, name string, relation Relation, relatedSchema TableSchema) graphql.FieldResolveFn {
+	return func(params graphql.ResolveParams) (interface{}, error) {
+		parent, _ := params.Source.(map[string]interface{})
+		key, ok := parent[relationKeyPrefix+name]
+		if !ok {
+			key = parent[relation.Column]
+		}
+		if key == nil {
+			return nil, nil
+		}
+
+		// Rows per key, charged by the cost analyzer as such and capped as the rows of the entity are
+		limit := 1
+		if relation.Many {
+			limit = DefaultLimit
+			if value, ok := params.Args["limit"].(int); ok {
+				limit = value
+			}
+			if err := CheckPagination(limit, 0); err != nil {
+				return nil, err
+			}
+			limit = EntityLimit(relation.Entity, limit)
+			if limit == 0 {
+				return []map[string]interface{}{}, nil
+			}
+		}
+
+		session := SessionFromContext(params.Context)
+		arguments := QueryArguments{Fields: GetProjection(params)}
+		if err := ValidateQuery(relation.Entity, session.Role, relatedSchema, arguments); err != nil {
+			return nil, err
+		}
+
+		arguments = columnArguments(relation.Entity, relatedSchema, arguments)
+		projection := columnProjection(relation.Entity, arguments.Fields, relatedSchema)
+		if !contains(projection, relation.RelatedColumn) {
+			projection = append(projection, relation.RelatedColumn)
+		}
+
+		loaderName := fmt.Sprintf("%s.%s(%s)[%d]", entity, name, strings.Join(projection, ","), limit)
+		loader := LoadersFromContext(params.Context).Get(loaderName, e.relationBatch(relation, projection, relatedSchema, session, limit))
+		thunk := loader.Load(params.Context, key)
+
+		return func() (interface{}, error) {
+			rows, err := thunk()
+			if err != nil {
+				return nil, err
+			}
+			if relation.Many {
+				return rows, nil
+			}
+			if len(rows) == 0 {
+				return nil, nil
+			}
+
+			return rows[0], nil
+		}, nil
+	}
+}
+
+// relationBatch - Fetch at most limit rows related to each of keys with one WHERE IN query per
+// chunk of keys, the query itself returns no more than limit rows per key
+func (e *Engine) relationBatch(relation Relation, projection []string, schema TableSchema, session *Session, limit int) BatchFunc {
+	return func(ctx context.Context, keys []interface{}) (map[string][]map[string]interface{}, error) {
+		dataSource, err := e.DataSource(relation.Entity)
+		if err != nil {
+			return nil, err
+		}
+
+		rowFilter, err := RowFilter(relation.Entity, session)
+		if err != nil {
+			return nil, err
+		}
+
+		timeout := Entities.GetStatementTimeout(relation.Entity)
+		grouped := map[string][]map[string]interface{}{}
+		for _, chunk := range chunkKeys(keys, e.maxAllowedPacket()) {
+			query, err := NewSelectDefinition(Entities.GetTableName(relation.Entity)).
+				WithExpressions(Entities.GetExpressions(relation.Entity)).
+				WithKeys(relation.RelatedColumn, chunk).
+				WithKeyLimit(limit).
+				WithPredicate(rowFilter).
+				WithProjections(projection).
+				WithTimeout(timeout).Build()
+			if err != nil {
+				return nil, err
+			}
+
+			rows, err := e.fetchRelated(ctx, dataSource, query.(*Statement), timeout, computedSchema(relation.Entity, schema), relation.RelatedColumn, chunk, limit)
+			if err != nil {
+				return nil, err
+			}
+
+			keepRelationKeys(relation.Entity, rows)
+			for _, row := range rows {
+				key := loaderKey(row[relation.RelatedColumn])
+				grouped[key] = append(grouped[key], row)
+			}
+			MaskRows(relation.Entity, session.Role, rows)
+		}
+
+		return grouped, nil
+	}
+}
+
+// fetchRelated - Rows of statement related to keys by their column, at most limit rows per key.
+// Rows are streamed so that the ones over the limit of their key are never held, and the statement
+// is closed as soon as every key has its rows.
+func (e *Engine) fetchRelated(ctx context.Context, dataSource DataSource, statement *Statement, timeout time.Duration, schema TableSchema, column string, keys []interface{}, limit int) ([]map[string]interface{}, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	iterator, err := dataSource.Stream(ctx, statement, 0, schema)
+	if err != nil {
+		return nil, err
+	}
+	defer iterator.Close()
+
+	pending := map[string]int{}
+	for _, key := range keys {
+		pending[loaderKey(key)] = limit
+	}
+
+	var rows []map[string]interface{}
+	for len(pending) > 0 && iterator.Next() {
+		row := iterator.Row()
+		key := loaderKey(row[column])
+		left, ok := pending[key]
+		if !ok {
+			continue
+		}
+
+		rows = append(rows, row)
+		if left == 1 {
+			delete(pending, key)
+		} else {
+			pending[key] = left - 1
+		}
+	}
+
+	return rows, iterator.Err()
+}
+
+func (e *Engine) fetch(ctx context.Context, dataSource DataSource, statement *Statement, timeout time.Duration, schema TableSchema) (*Result, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	return dataSource.FetchScan(ctx, statement, 0, schema)
+}
+
+// keepRelationKeys - Keep the values the relations of entity join on with rows, so that relations
+// can still be resolved once the columns are masked
+func keepRelationKeys(entity string, rows []map[string]interface{}) {
+	relations := Entities.GetRelations(entity)
+	if len(relations) == 0 {
+		return
+	}
+
+	for _, row := range rows {
+		for name, relation := range relations {
+			if value, ok := row[relation.Column]; ok {
+				row[relationKeyPrefix+name] = value
+			}
+		}
+	}
+}
+
+// columnProjection - Columns selected for the fields selected on entity, a relation is replaced by
+// the column it joins on, a field computed in Go by the columns it depends on, and fields which are
+// no columns, eg __typename, are left out. Expression fields are selected by their name.
+func columnProjection(entity string, fields []string, schema TableSchema) []string {
+	relations := Entities.GetRelations(entity)
+	computed := Entities.GetComputedFields(entity)
+
+	var projection []string
+	for _, field := range fields {
+		columns := []string{field}
+		if _, ok := schema[field]; !ok {
+			relation, isRelation := relations[field]
+			computedField, isComputed := computed[field]
+			switch {
+			case isRelation:
+				columns = []string{relation.Column}
+			case isComputed && computedField.Resolver != "":
+				columns = computedField.Columns()
+			case !isComputed:
+				continue
+			}
+		}
+
+		for _, column := range columns {
+			if !contains(projection, column) {
+				projection = append(projection, column)
+			}
+		}
+	}
+
+	return projection
+}
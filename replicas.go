@@ -0,0 +1,312 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Replica routing policies
+const (
+	RoundRobin       = "round_robin"
+	LeastConnections = "least_connections"
+)
+
+// PrimaryHeader - requests setting it to true read from the primary, for read-your-writes consistency
+const PrimaryHeader = "X-Read-Primary"
+
+// PrimaryDirective - name of the @primary directive reading a query from the primary
+const PrimaryDirective = "primary"
+
+const defaultHealthCheckInterval = 5 * time.Second
+
+type primaryReadContextKey struct{}
+
+// WithPrimaryRead - Route reads made with the returned context to the primary
+func WithPrimaryRead(ctx context.Context) context.Context {
+	return context.WithValue(ctx, primaryReadContextKey{}, true)
+}
+
+func isPrimaryRead(ctx context.Context) bool {
+	primary, _ := ctx.Value(primaryReadContextKey{}).(bool)
+	return primary
+}
+
+type replica struct {
+	addr     string
+	db       *sql.DB
+	healthy  int32
+	inFlight int64
+}
+
+func (r *replica) done() {
+	atomic.AddInt64(&r.inFlight, -1)
+}
+
+type replicaSet struct {
+	replicas []*replica
+	policy   string
+	maxLag   time.Duration
+	next     uint64
+	stop     chan struct{}
+	wg       sync.WaitGroup
+}
+
+func newReplicaSet(c SqlConfig) (*replicaSet, error) {
+	set := &replicaSet{
+		policy: c.ReplicaPolicy,
+		maxLag: c.MaxReplicaLag,
+		stop:   make(chan struct{}),
+	}
+
+	switch set.policy {
+	case "":
+		set.policy = RoundRobin
+	case RoundRobin, LeastConnections:
+	default:
+		return nil, fmt.Errorf("unknown replica policy %q", c.ReplicaPolicy)
+	}
+
+	for _, config := range c.Replicas {
+		config = inheritPrimary(config, c)
+		db, err := openPool(config)
+		if err != nil {
+			set.close()
+			return nil, err
+		}
+
+		addr := config.Socket
+		if addr == "" {
+			addr = fmt.Sprintf("%s:%d", config.Host, config.Port)
+		}
+		// Replicas take traffic until the first health check says otherwise
+		set.replicas = append(set.replicas, &replica{addr: addr, db: db, healthy: 1})
+	}
+
+	interval := c.HealthCheckInterval
+	if interval <= 0 {
+		interval = defaultHealthCheckInterval
+	}
+
+	set.wg.Add(1)
+	go set.healthCheck(interval)
+
+	return set, nil
+}
+
+// inheritPrimary - Config of replica, the one of the primary with the settings of replica which are
+// set. The address is taken as a whole, a socket of the primary is not used with the host of replica.
+func inheritPrimary(replica, primary SqlConfig) SqlConfig {
+	config := primary
+	config.Replicas = nil
+
+	if replica.Host != "" || replica.Socket != "" {
+		config.Host, config.Socket, config.Protocol = replica.Host, replica.Socket, replica.Protocol
+	}
+	if replica.Port != 0 {
+		config.Port = replica.Port
+	}
+	if replica.Username != "" {
+		config.Username, config.Password = replica.Username, replica.Password
+	}
+	if replica.Name != "" {
+		config.Name = replica.Name
+	}
+	if replica.Charset != "" {
+		config.Charset = replica.Charset
+	}
+	if replica.Collation != "" {
+		config.Collation = replica.Collation
+	}
+	if replica.Location != "" {
+		config.Location = replica.Location
+	}
+	if replica.Timeout != 0 {
+		config.Timeout = replica.Timeout
+	}
+	if replica.ReadTimeout != 0 {
+		config.ReadTimeout = replica.ReadTimeout
+	}
+	if replica.WriteTimeout != 0 {
+		config.WriteTimeout = replica.WriteTimeout
+	}
+	if replica.TLS != nil {
+		config.TLS = replica.TLS
+	}
+	if replica.Params != nil {
+		config.Params = replica.Params
+	}
+	if replica.MaxOpenConns != 0 {
+		config.MaxOpenConns = replica.MaxOpenConns
+	}
+	if replica.MaxIdleConns != 0 {
+		config.MaxIdleConns = replica.MaxIdleConns
+	}
+	if replica.ConnMaxLifetime != 0 {
+		config.ConnMaxLifetime = replica.ConnMaxLifetime
+	}
+	if replica.ConnMaxIdleTime != 0 {
+		config.ConnMaxIdleTime = replica.ConnMaxIdleTime
+	}
+	if replica.StatementCacheSize != 0 {
+		config.StatementCacheSize = replica.StatementCacheSize
+	}
+
+	return config
+}
+
+// pick - Healthy replica chosen by the policy, nil if none is healthy. The caller must call done on
+// the replica once the read finished.
+func (s *replicaSet) pick() *replica {
+	var picked *replica
+
+	switch s.policy {
+	case LeastConnections:
+		for _, replica := range s.replicas {
+			if atomic.LoadInt32(&replica.healthy) == 0 {
+				continue
+			}
+			if picked == nil || atomic.LoadInt64(&replica.inFlight) < atomic.LoadInt64(&picked.inFlight) {
+				picked = replica
+			}
+		}
+	default:
+		start := atomic.AddUint64(&s.next, 1)
+		for i := range s.replicas {
+			replica := s.replicas[(start+uint64(i))%uint64(len(s.replicas))]
+			if atomic.LoadInt32(&replica.healthy) == 1 {
+				picked = replica
+				break
+			}
+		}
+	}
+
+	if picked != nil {
+		atomic.AddInt64(&picked.inFlight, 1)
+	}
+
+	return picked
+}
+
+func (s *replicaSet) healthCheck(interval time.Duration) {
+	defer s.wg.Done()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		for _, replica := range s.replicas {
+			ctx, cancel := context.WithTimeout(context.Background(), interval)
+			err := s.check(ctx, replica)
+			cancel()
+
+			healthy := int32(1)
+			if err != nil {
+				healthy = 0
+			}
+			if previous := atomic.SwapInt32(&replica.healthy, healthy); previous != healthy {
+				if err != nil {
+					log.Printf("evicting replica %s, error: %v", replica.addr, err)
+				} else {
+					log.Printf("replica %s is healthy again", replica.addr)
+				}
+			}
+		}
+
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// check - Ping replica and check its lag behind the primary is within the maximum
+func (s *replicaSet) check(ctx context.Context, replica *replica) error {
+	if err := replica.db.PingContext(ctx); err != nil {
+		return err
+	}
+	if s.maxLag <= 0 {
+		return nil
+	}
+
+	lag, err := replicaLag(ctx, replica.db)
+	if err != nil {
+		return err
+	}
+	if lag > s.maxLag {
+		return fmt.Errorf("replica lag %s exceeds %s", lag, s.maxLag)
+	}
+
+	return nil
+}
+
+// replicaLag - Lag of the replica behind its source from SHOW REPLICA STATUS, falling back to SHOW
+// SLAVE STATUS on servers before MySQL 8.0.22
+func replicaLag(ctx context.Context, db *sql.DB) (time.Duration, error) {
+	rows, err := db.QueryContext(ctx, "SHOW REPLICA STATUS")
+	if err != nil {
+		rows, err = db.QueryContext(ctx, "SHOW SLAVE STATUS")
+		if err != nil {
+			return 0, err
+		}
+	}
+	defer rows.Close()
+
+	cols, err := rows.Columns()
+	if err != nil {
+		return 0, err
+	}
+
+	if !rows.Next() {
+		if err := rows.Err(); err != nil {
+			return 0, err
+		}
+		return 0, errors.New("server is not a replica")
+	}
+
+	values := make([]sql.RawBytes, len(cols))
+	valuePtr := make([]interface{}, len(cols))
+	for idx := range values {
+		valuePtr[idx] = &values[idx]
+	}
+	if err := rows.Scan(valuePtr...); err != nil {
+		return 0, err
+	}
+
+	for idx, column := range cols {
+		if column != "Seconds_Behind_Source" && column != "Seconds_Behind_Master" {
+			continue
+		}
+		// NULL when replication is not running
+		if values[idx] == nil {
+			return 0, errors.New("replication is not running")
+		}
+		seconds, err := strconv.Atoi(string(values[idx]))
+		if err != nil {
+			return 0, err
+		}
+		return time.Duration(seconds) * time.Second, nil
+	}
+
+	return 0, errors.New("no replication lag in replica status")
+}
+
+func (s *replicaSet) close() {
+	select {
+	case <-s.stop:
+	default:
+		close(s.stop)
+	}
+	s.wg.Wait()
+
+	for _, replica := range s.replicas {
+		_ = replica.db.Close()
+	}
+}
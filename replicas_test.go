@@ -0,0 +1,77 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/graphql-go/graphql/language/parser"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReplicaSet_Pick(t *testing.T) {
+	a, b := &replica{addr: "a", healthy: 1}, &replica{addr: "b", healthy: 1}
+	set := &replicaSet{replicas: []*replica{a, b}, policy: RoundRobin}
+
+	first, second := set.pick(), set.pick()
+	assert.NotEqual(t, first.addr, second.addr)
+
+	b.healthy = 0
+	assert.Equal(t, "a", set.pick().addr)
+	assert.Equal(t, "a", set.pick().addr)
+
+	a.healthy = 0
+	assert.Nil(t, set.pick())
+
+	a.healthy, b.healthy = 1, 1
+	a.inFlight, b.inFlight = 3, 1
+	set.policy = LeastConnections
+	picked := set.pick()
+	assert.Equal(t, "b", picked.addr)
+	assert.Equal(t, int64(2), b.inFlight)
+	picked.done()
+	assert.Equal(t, int64(1), b.inFlight)
+}
+
+func TestHasPrimaryDirective(t *testing.T) {
+	for query, primary := range map[string]bool{
+		`{ payments { id } }`:                false,
+		`query @primary { payments { id } }`: true,
+		`{ payments @primary { id } }`:       true,
+	} {
+		document, err := parser.Parse(parser.ParseParams{Source: query})
+		assert.Nil(t, err)
+		assert.Equal(t, primary, hasPrimaryDirective(document), query)
+	}
+}
+
+func TestInheritPrimary(t *testing.T) {
+	tls := &TLSConfig{CAFile: "ca.pem"}
+	primary := SqlConfig{
+		Socket:      "/var/run/mysqld.sock",
+		Port:        3306,
+		Username:    "app",
+		Password:    "secret",
+		Name:        "payments",
+		Charset:     "utf8mb4",
+		ReadTimeout: time.Second,
+		TLS:         tls,
+		Replicas:    []SqlConfig{{Host: "replica-1"}},
+	}
+
+	assert.Equal(t, SqlConfig{
+		Host:        "replica-1",
+		Port:        3307,
+		Username:    "app",
+		Password:    "secret",
+		Name:        "payments",
+		Charset:     "utf8mb4",
+		ReadTimeout: time.Second,
+		TLS:         tls,
+	}, inheritPrimary(SqlConfig{Host: "replica-1", Port: 3307}, primary))
+
+	replica := inheritPrimary(SqlConfig{Host: "replica-2", Username: "reader", TLS: &TLSConfig{ServerName: "replica-2"}}, primary)
+	assert.Equal(t, "reader", replica.Username)
+	assert.Empty(t, replica.Password)
+	assert.Equal(t, "replica-2", replica.TLS.ServerName)
+	assert.Equal(t, 3306, replica.Port)
+}
@@ -2,14 +2,22 @@ package main
 
 import (
 	"fmt"
+	"sort"
 	"strings"
+	"time"
 )
 
 type Querier interface {
 	WithFilters(map[string]interface{}) Querier
+	WithPredicate(map[string]interface{}) Querier
 	WithProjections([]string) Querier
 	WithSortCriteria([]map[string]interface{}) Querier
 	WithPagination(offset, limit int) Querier
+	WithTimeout(time.Duration) Querier
+	WithSeek(columns []string, after []interface{}) Querier
+	WithKeys(column string, keys []interface{}) Querier
+	WithKeyLimit(limit int) Querier
+	WithExpressions(expressions map[string]string) Querier
 	Build() (interface{}, error)
 }
 
@@ -33,17 +41,39 @@ var sqlOperator = map[string]string{
 	Equal:            "=",
 }
 
+// SelectDefinition - Querier of a SELECT statement. Projections, filters and sort criteria are
+// translated to sql once the statement is built, when every computed field is known.
 type SelectDefinition struct {
 	database         string
 	table            string
-	fieldsFragment   string
-	whereFragment    string
-	sortOrder        string
-	limitFragment    string
-	offsetFragment   string
+	hint             string
+	projection       []string
+	filters          map[string]interface{}
+	predicateFilters map[string]interface{}
+	sortCriteria     []map[string]interface{}
+	expressions      map[string]string
+	seekFragment     fragment
+	keysFragment     fragment
+	keysColumn       string
+	keyLimit         int
+	limitFragment    fragment
+	offsetFragment   fragment
 	generatedSqlStmt string
 }
 
+// Statement - Parameterized sql, Args are bound to the placeholders of SQL in order. Values never
+// become part of SQL so that queries of the same shape share their text.
+type Statement struct {
+	SQL  string
+	Args []interface{}
+}
+
+// fragment - Part of a statement with the values of its placeholders
+type fragment struct {
+	sql  string
+	args []interface{}
+}
+
 func NewSelectDefinition(table string) Querier {
 	return &SelectDefinition{
 		table: table,
@@ -52,108 +82,264 @@ func NewSelectDefinition(table string) Querier {
 
 // WithFilters - Translate all filter criteria specified as filter to a sql where clause
 func (s *SelectDefinition) WithFilters(filters map[string]interface{}) Querier {
-	for field, condition := range filters {
+	s.filters = filters
+
+	return s
+}
+
+// WithPredicate - Translate a row level filter to a sql condition which is AND-ed with the filters,
+// it is kept apart from the where clause so that client filters can never widen or replace it. It
+// names columns, never computed fields.
+func (s *SelectDefinition) WithPredicate(filters map[string]interface{}) Querier {
+	s.predicateFilters = filters
+
+	return s
+}
+
+// WithExpressions - Computed fields by name with the sql expression computing them, projections,
+// filters and sort criteria on these names use the expression instead of a column. The predicate,
+// keys and seek name columns only.
+func (s *SelectDefinition) WithExpressions(expressions map[string]string) Querier {
+	s.expressions = expressions
+
+	return s
+}
+
+// reference - Sql of field, the quoted column or the expression of a computed field
+func (s *SelectDefinition) reference(field string) string {
+	if expression, ok := s.expressions[field]; ok {
+		return "(" + expression + ")"
+	}
+
+	return column(field)
+}
+
+// column - Sql of the quoted column
+func column(name string) string {
+	return fmt.Sprintf("`%s`", name)
+}
+
+// conditions - Conditions of filters AND-ed together, fields and operators are sorted so that the
+// same filters always give the same sql. reference gives the sql of the fields.
+func conditions(filters map[string]interface{}, reference func(string) string) fragment {
+	var (
+		parts []string
+		args  []interface{}
+	)
+
+	for _, field := range sortedKeys(filters) {
+		condition := filters[field]
 		// if filter has condition with some operator eg { "amount": {"$gte": 1000000 }}
 		// in that case condition will be a map of operator and condition value
 		// by default we consider condition without operator as equivalence
 		conditionMap, isMap := condition.(map[string]interface{})
 		if isMap {
-			for operator, value := range conditionMap {
-				// Check if it's really an operator or some junk data
-				// TODO: This should be checked by query validator
+			for _, operator := range sortedKeys(conditionMap) {
+				// Operators are checked by ValidateQuery, anything else is skipped
 				if strings.HasPrefix(operator, "_") {
-
-					if strValue, isString := value.(string); isString {
-						value = fmt.Sprintf("'%s'", strValue)
-					}
-					s.whereFragment += fmt.Sprintf("`%s` %s %v AND ", field, sqlOperator[operator], value)
+					part, values := applyOperator(operator, reference(field), conditionMap[operator])
+					parts = append(parts, part)
+					args = append(args, values...)
 				}
 			}
 		} else {
-			if strValue, isString := condition.(string); isString {
-				condition = fmt.Sprintf("'%s'", strValue)
-			}
-			s.whereFragment += fmt.Sprintf("`%s` %s %v AND ", field, "=", condition)
+			parts = append(parts, fmt.Sprintf("%s = ?", reference(field)))
+			args = append(args, condition)
 		}
 	}
-	s.whereFragment = strings.TrimSuffix(s.whereFragment, " AND ")
 
-	return s
+	return fragment{sql: strings.Join(parts, " AND "), args: args}
 }
 
-// WithProjections - Translate all projection to sql select columns
-func (s *SelectDefinition) WithProjections(projection []string) Querier {
-	for _, field := range projection {
-		s.fieldsFragment += fmt.Sprintf("`%s`, ", field)
+// applyOperator - Condition comparing the sql reference of a field to value with op, the values of
+// an IN list get a placeholder each
+func applyOperator(op string, reference string, value interface{}) (string, []interface{}) {
+	if op == In {
+		values, _ := value.([]interface{})
+		if len(values) == 0 {
+			// IN () is a syntax error, an empty list matches nothing
+			return "FALSE", nil
+		}
+
+		placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(values)), ", ")
+		return fmt.Sprintf("%s %s (%s)", reference, sqlOperator[op], placeholders), values
 	}
-	s.fieldsFragment = strings.TrimSuffix(s.fieldsFragment, ", ")
+
+	return fmt.Sprintf("%s %s ?", reference, sqlOperator[op]), []interface{}{value}
+}
+
+func sortedKeys(values map[string]interface{}) []string {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
+
+// WithProjections - Translate all projection to sql select columns, computed fields are selected
+// by their expression under their name
+func (s *SelectDefinition) WithProjections(projection []string) Querier {
+	s.projection = append(s.projection, projection...)
 
 	return s
 }
 
 // WithSortCriteria - Translate all sort criteria to sql sort order
 func (s *SelectDefinition) WithSortCriteria(sortOrder []map[string]interface{}) Querier {
-	for _, criteria := range sortOrder {
-		for field, order := range criteria {
-			s.sortOrder += fmt.Sprintf("`%s` %s, ", field, order)
-		}
-	}
-	s.sortOrder = strings.TrimSuffix(s.sortOrder, ", ")
+	s.sortCriteria = append(s.sortCriteria, sortOrder...)
 
 	return s
 }
 
 // WithPagination - Translate limit and offset in pagination to sql limit and offset
 func (s *SelectDefinition) WithPagination(offset, limit int) Querier {
-	s.limitFragment = fmt.Sprintf("%d", limit)
-	s.offsetFragment = fmt.Sprintf("%d", offset)
+	s.limitFragment = fragment{sql: "?", args: []interface{}{limit}}
+	s.offsetFragment = fragment{sql: "?", args: []interface{}{offset}}
+
+	return s
+}
+
+// WithTimeout - Let the server abort the statement after timeout with the MAX_EXECUTION_TIME hint
+func (s *SelectDefinition) WithTimeout(timeout time.Duration) Querier {
+	if ms := timeout.Milliseconds(); ms > 0 {
+		s.hint = fmt.Sprintf("/*+ MAX_EXECUTION_TIME(%d) */ ", ms)
+	}
 
 	return s
 }
 
+// WithSeek - Keyset pagination, only rows with columns ordered after the values of the last row
+// seen are selected. Sort criteria must order by the same columns ascending.
+func (s *SelectDefinition) WithSeek(columns []string, after []interface{}) Querier {
+	if len(columns) == 0 || len(columns) != len(after) {
+		return s
+	}
+
+	quoted := make([]string, len(columns))
+	for idx, name := range columns {
+		quoted[idx] = column(name)
+	}
+
+	if len(columns) == 1 {
+		s.seekFragment = fragment{sql: fmt.Sprintf("%s > ?", quoted[0]), args: after}
+	} else {
+		placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(after)), ", ")
+		s.seekFragment = fragment{
+			sql:  fmt.Sprintf("(%s) > (%s)", strings.Join(quoted, ", "), placeholders),
+			args: after,
+		}
+	}
+
+	return s
+}
+
+// WithKeys - Only rows whose column is one of keys, the rows of a batch of relations
+func (s *SelectDefinition) WithKeys(name string, keys []interface{}) Querier {
+	sql, args := applyOperator(In, column(name), keys)
+	s.keysFragment = fragment{sql: sql, args: args}
+	s.keysColumn = name
+
+	return s
+}
+
+// keyRowColumn - column numbering the rows of each key when the rows per key are limited
+const keyRowColumn = "__key_row"
+
+// WithKeyLimit - At most limit rows for each of the keys, in the order of the sort criteria. MySQL
+// has no limit per group, the rows of each key are numbered with ROW_NUMBER in a derived table and
+// the ones over the limit filtered out around it. Not limited if not positive.
+func (s *SelectDefinition) WithKeyLimit(limit int) Querier {
+	s.keyLimit = limit
+
+	return s
+}
+
+// Build - Build the *Statement selecting the rows
 func (s *SelectDefinition) Build() (interface{}, error) {
-	if len(s.fieldsFragment) == 0 {
-		s.fieldsFragment = "*"
+	fields := make([]string, 0, len(s.projection))
+	for _, field := range s.projection {
+		if _, ok := s.expressions[field]; ok {
+			fields = append(fields, fmt.Sprintf("%s AS `%s`", s.reference(field), field))
+			continue
+		}
+		fields = append(fields, s.reference(field))
+	}
+	fieldsFragment := strings.Join(fields, ", ")
+	if len(fieldsFragment) == 0 {
+		fieldsFragment = "*"
 	}
 
-	s.generatedSqlStmt = fmt.Sprintf("SELECT %s FROM `%s`", s.fieldsFragment, s.table)
+	var (
+		where       []string
+		whereClause string
+		args        []interface{}
+	)
+	for _, condition := range []fragment{conditions(s.predicateFilters, column), conditions(s.filters, s.reference), s.keysFragment, s.seekFragment} {
+		if len(condition.sql) > 0 {
+			where = append(where, condition.sql)
+			args = append(args, condition.args...)
+		}
+	}
+	if len(where) == 1 {
+		whereClause = fmt.Sprintf(" WHERE %s", where[0])
+	} else if len(where) > 1 {
+		whereClause = fmt.Sprintf(" WHERE (%s)", strings.Join(where, ") AND ("))
+	}
+
+	sortOrder := s.sortOrder(s.reference)
+	if s.keyLimit > 0 && s.keysColumn != "" {
+		window := fmt.Sprintf("PARTITION BY %s", column(s.keysColumn))
+		if len(sortOrder) > 0 {
+			window += fmt.Sprintf(" ORDER BY %s", strings.Join(sortOrder, ", "))
+		}
+		keyed := fmt.Sprintf("SELECT %s, ROW_NUMBER() OVER (%s) AS `%s` FROM `%s`%s", fieldsFragment, window, keyRowColumn, s.table, whereClause)
 
-	if len(s.whereFragment) > 0 {
-		s.generatedSqlStmt += fmt.Sprintf(" WHERE %s", s.whereFragment)
+		// Around the derived table the fields are columns of it, expressions included
+		outerFields := "*"
+		if len(s.projection) > 0 {
+			quoted := make([]string, len(s.projection))
+			for idx, field := range s.projection {
+				quoted[idx] = column(field)
+			}
+			outerFields = strings.Join(quoted, ", ")
+		}
+		s.generatedSqlStmt = fmt.Sprintf("SELECT %s%s FROM (%s) AS `keyed` WHERE `%s` <= ?", s.hint, outerFields, keyed, keyRowColumn)
+		args = append(args, s.keyLimit)
+		sortOrder = s.sortOrder(column)
+	} else {
+		s.generatedSqlStmt = fmt.Sprintf("SELECT %s%s FROM `%s`%s", s.hint, fieldsFragment, s.table, whereClause)
 	}
 
-	if len(s.sortOrder) > 0 {
-		s.generatedSqlStmt += fmt.Sprintf(" ORDER BY %s", s.sortOrder)
+	if len(sortOrder) > 0 {
+		s.generatedSqlStmt += fmt.Sprintf(" ORDER BY %s", strings.Join(sortOrder, ", "))
 	}
 
-	if len(s.limitFragment) > 0 {
-		s.generatedSqlStmt += fmt.Sprintf(" LIMIT %s", s.limitFragment)
+	if len(s.limitFragment.sql) > 0 {
+		s.generatedSqlStmt += fmt.Sprintf(" LIMIT %s", s.limitFragment.sql)
+		args = append(args, s.limitFragment.args...)
 	}
 
-	if len(s.offsetFragment) > 0 {
-		s.generatedSqlStmt += fmt.Sprintf(" OFFSET %s", s.offsetFragment)
+	if len(s.offsetFragment.sql) > 0 {
+		s.generatedSqlStmt += fmt.Sprintf(" OFFSET %s", s.offsetFragment.sql)
+		args = append(args, s.offsetFragment.args...)
 	}
 
 	s.generatedSqlStmt = strings.TrimRight(s.generatedSqlStmt, " ")
 	s.generatedSqlStmt += ";"
 
-	return s.generatedSqlStmt, nil
+	return &Statement{SQL: s.generatedSqlStmt, Args: args}, nil
 }
 
-// TODO: Implement this (IN query)
-func (s *SelectDefinition) applyOperator(op string, field string, args ...interface{}) string {
-	switch op {
-	case NotEqual:
-	case LessThanEqual:
-	case LessThan:
-	case GreaterThan:
-	case GreaterThanEqual:
-		return fmt.Sprintf("`%s` %s %v AND ", field, sqlOperator[op], args[0])
-	case In:
-		values := make([]string, len(args))
-		inValues := strings.Join(values, ", ")
-		return fmt.Sprintf("`%s` %s %v AND ", field, sqlOperator[op], inValues)
+// sortOrder - Sql of the sort criteria, reference gives the sql of the fields
+func (s *SelectDefinition) sortOrder(reference func(string) string) []string {
+	var sortOrder []string
+	for _, criteria := range s.sortCriteria {
+		for field, order := range criteria {
+			sortOrder = append(sortOrder, fmt.Sprintf("%s %s", reference(field), order))
+		}
 	}
 
-	return ""
+	return sortOrder
 }
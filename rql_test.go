@@ -0,0 +1,127 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSelectDefinition_WithPredicate(t *testing.T) {
+	query, err := NewSelectDefinition("payments").
+		WithFilters(map[string]interface{}{"merchant_id": "other"}).
+		WithPredicate(map[string]interface{}{"merchant_id": map[string]interface{}{"_eq": "m1"}}).
+		WithPagination(0, 10).
+		Build()
+	assert.Nil(t, err)
+	assert.Equal(t, &Statement{
+		SQL:  "SELECT * FROM `payments` WHERE (`merchant_id` = ?) AND (`merchant_id` = ?) LIMIT ? OFFSET ?;",
+		Args: []interface{}{"m1", "other", 10, 0},
+	}, query)
+
+	query, err = NewSelectDefinition("payments").
+		WithPredicate(map[string]interface{}{"merchant_id": "it's"}).
+		Build()
+	assert.Nil(t, err)
+	assert.Equal(t, &Statement{SQL: "SELECT * FROM `payments` WHERE `merchant_id` = ?;", Args: []interface{}{"it's"}}, query)
+}
+
+func TestRowFilter(t *testing.T) {
+	Entities["row_filter_test"] = EntityConfig{
+		TableName: "payments",
+		RowFilters: map[string]map[string]interface{}{
+			"merchant": {"merchant_id": map[string]interface{}{"_eq": "X-Merchant-Id"}},
+			"admin":    nil,
+		},
+	}
+	defer delete(Entities, "row_filter_test")
+
+	filter, err := RowFilter("row_filter_test", NewSession("merchant", map[string]string{"X-Merchant-Id": "m1"}))
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]interface{}{"merchant_id": map[string]interface{}{"_eq": "m1"}}, filter)
+
+	filter, err = RowFilter("row_filter_test", NewSession("admin", nil))
+	assert.Nil(t, err)
+	assert.Empty(t, filter)
+
+	_, err = RowFilter("row_filter_test", NewSession("merchant", nil))
+	assert.True(t, errors.Is(err, ErrAccessDenied))
+
+	_, err = RowFilter("row_filter_test", NewSession("", nil))
+	assert.True(t, errors.Is(err, ErrAccessDenied))
+}
+
+func TestSelectDefinition_WithTimeout(t *testing.T) {
+	query, err := NewSelectDefinition("payments").
+		WithProjections([]string{"id"}).
+		WithTimeout(1500 * time.Millisecond).
+		Build()
+	assert.Nil(t, err)
+	assert.Equal(t, "SELECT /*+ MAX_EXECUTION_TIME(1500) */ `id` FROM `payments`;", query.(*Statement).SQL)
+}
+
+func TestSelectDefinition_WithFilters(t *testing.T) {
+	query, err := NewSelectDefinition("payments").
+		WithFilters(map[string]interface{}{
+			"status": map[string]interface{}{"_in": []interface{}{"paid", "refunded"}},
+			"amount": map[string]interface{}{"_lt": 100, "_gte": 10},
+		}).
+		WithSeek([]string{"created_at", "id"}, []interface{}{"2020-01-01", 7}).
+		Build()
+	assert.Nil(t, err)
+	assert.Equal(t, &Statement{
+		SQL:  "SELECT * FROM `payments` WHERE (`amount` >= ? AND `amount` < ? AND `status` IN (?, ?)) AND ((`created_at`, `id`) > (?, ?));",
+		Args: []interface{}{10, 100, "paid", "refunded", "2020-01-01", 7},
+	}, query)
+
+	query, err = NewSelectDefinition("payments").
+		WithFilters(map[string]interface{}{"status": map[string]interface{}{"_in": []interface{}{}}}).
+		Build()
+	assert.Nil(t, err)
+	assert.Equal(t, "SELECT * FROM `payments` WHERE FALSE;", query.(*Statement).SQL)
+}
+
+func TestSelectDefinition_WithExpressions(t *testing.T) {
+	query, err := NewSelectDefinition("payments").
+		WithExpressions(map[string]string{"amount_in_dollars": "`amount` / 100"}).
+		WithProjections([]string{"id", "amount_in_dollars"}).
+		WithFilters(map[string]interface{}{"amount_in_dollars": map[string]interface{}{"_gt": 10}}).
+		WithSortCriteria([]map[string]interface{}{{"amount_in_dollars": "desc"}}).
+		Build()
+	assert.Nil(t, err)
+	assert.Equal(t, &Statement{
+		SQL:  "SELECT `id`, (`amount` / 100) AS `amount_in_dollars` FROM `payments` WHERE (`amount` / 100) > ? ORDER BY (`amount` / 100) desc;",
+		Args: []interface{}{10},
+	}, query)
+}
+
+func TestSelectDefinition_WithKeys(t *testing.T) {
+	// The predicate and the keys name columns even where a computed field has the same name
+	query, err := NewSelectDefinition("refunds").
+		WithExpressions(map[string]string{"merchant_id": "'m1'", "payment_id": "0"}).
+		WithPredicate(map[string]interface{}{"merchant_id": "m2"}).
+		WithKeys("payment_id", []interface{}{1, 2}).
+		Build()
+	assert.Nil(t, err)
+	assert.Equal(t, &Statement{
+		SQL:  "SELECT * FROM `refunds` WHERE (`merchant_id` = ?) AND (`payment_id` IN (?, ?));",
+		Args: []interface{}{"m2", 1, 2},
+	}, query)
+
+	// Rows are numbered per key inside, where expressions are still known
+	query, err = NewSelectDefinition("refunds").
+		WithExpressions(map[string]string{"total": "`amount` + `fee`"}).
+		WithKeys("payment_id", []interface{}{1, 2}).
+		WithKeyLimit(3).
+		WithProjections([]string{"id", "total", "payment_id"}).
+		WithSortCriteria([]map[string]interface{}{{"total": "desc"}}).
+		Build()
+	assert.Nil(t, err)
+	assert.Equal(t, &Statement{
+		SQL: "SELECT `id`, `total`, `payment_id` FROM (SELECT `id`, (`amount` + `fee`) AS `total`, `payment_id`, " +
+			"ROW_NUMBER() OVER (PARTITION BY `payment_id` ORDER BY (`amount` + `fee`) desc) AS `__key_row` FROM `refunds` WHERE `payment_id` IN (?, ?)) AS `keyed` " +
+			"WHERE `__key_row` <= ? ORDER BY `total` desc;",
+		Args: []interface{}{1, 2, 3},
+	}, query)
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"encoding/json"
+	"strconv"
+
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/language/ast"
+)
+
+// BigInt - 64 bit signed and unsigned integers, serialized as JSON number without losing precision
+var BigInt = graphql.NewScalar(graphql.ScalarConfig{
+	Name:        "BigInt",
+	Description: "64 bit signed or unsigned integer",
+	Serialize: func(value interface{}) interface{} {
+		switch value := value.(type) {
+		case int64:
+			return json.Number(strconv.FormatInt(value, 10))
+		case uint64:
+			return json.Number(strconv.FormatUint(value, 10))
+		case int:
+			return json.Number(strconv.Itoa(value))
+		}
+		return nil
+	},
+	ParseValue: parseBigInt,
+	ParseLiteral: func(valueAST ast.Value) interface{} {
+		switch valueAST := valueAST.(type) {
+		case *ast.IntValue:
+			return parseBigInt(valueAST.Value)
+		case *ast.StringValue:
+			return parseBigInt(valueAST.Value)
+		}
+		return nil
+	},
+})
+
+func parseBigInt(value interface{}) interface{} {
+	switch value := value.(type) {
+	case string:
+		if v, err := strconv.ParseInt(value, 10, 64); err == nil {
+			return v
+		}
+		if v, err := strconv.ParseUint(value, 10, 64); err == nil {
+			return v
+		}
+	case float64:
+		return int64(value)
+	case json.Number:
+		return parseBigInt(string(value))
+	}
+	return nil
+}
+
+// Decimal - exact DECIMAL values, serialized as JSON number with all digits as stored
+var Decimal = graphql.NewScalar(graphql.ScalarConfig{
+	Name:        "Decimal",
+	Description: "Exact decimal number",
+	Serialize: func(value interface{}) interface{} {
+		if value, ok := value.(json.Number); ok {
+			return value
+		}
+		return nil
+	},
+	ParseValue: parseDecimal,
+	ParseLiteral: func(valueAST ast.Value) interface{} {
+		switch valueAST := valueAST.(type) {
+		case *ast.IntValue:
+			return parseDecimal(valueAST.Value)
+		case *ast.FloatValue:
+			return parseDecimal(valueAST.Value)
+		case *ast.StringValue:
+			return parseDecimal(valueAST.Value)
+		}
+		return nil
+	},
+})
+
+func parseDecimal(value interface{}) interface{} {
+	switch value := value.(type) {
+	case string:
+		if _, err := strconv.ParseFloat(value, 64); err == nil {
+			return json.Number(value)
+		}
+	case float64:
+		return json.Number(strconv.FormatFloat(value, 'f', -1, 64))
+	case json.Number:
+		return value
+	}
+	return nil
+}
+
+// JSON - value of a JSON column, serialized as is
+var JSON = graphql.NewScalar(graphql.ScalarConfig{
+	Name:        "JSON",
+	Description: "Arbitrary JSON value",
+	Serialize: func(value interface{}) interface{} {
+		return value
+	},
+	ParseValue: func(value interface{}) interface{} {
+		return value
+	},
+	ParseLiteral: func(valueAST ast.Value) interface{} {
+		return valueAST.GetValue()
+	},
+})
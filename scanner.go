@@ -0,0 +1,225 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"time"
+)
+
+// ColumnDecoder - Convert the value of a column as returned by the driver to its Go value
+type ColumnDecoder func(src interface{}) (interface{}, error)
+
+// ColValueScanner - sql.Scanner decoding the value of a column with the decoder for its type
+type ColValueScanner struct {
+	column string
+	decode ColumnDecoder
+	value  interface{}
+}
+
+func (scanner *ColValueScanner) Scan(src interface{}) error {
+	if src == nil {
+		scanner.value = nil
+		return nil
+	}
+
+	value, err := scanner.decode(src)
+	if err != nil {
+		return fmt.Errorf("column %q: %w", scanner.column, err)
+	}
+	scanner.value = value
+
+	return nil
+}
+
+// NewColumnDecoder - Decoder for a column of the result, schemaType is the column type from the
+// table schema which tells tinyint(1) booleans apart, it is empty for expressions
+func NewColumnDecoder(columnType *sql.ColumnType, schemaType string) ColumnDecoder {
+	unsigned := isUnsignedColumn(schemaType)
+	if scanType := columnType.ScanType(); scanType != nil {
+		switch scanType.Kind() {
+		case reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			unsigned = true
+		}
+	}
+
+	return columnDecoder(columnType.DatabaseTypeName(), unsigned, schemaType)
+}
+
+func columnDecoder(databaseType string, unsigned bool, schemaType string) ColumnDecoder {
+	if isBoolColumn(schemaType) {
+		return decodeBool
+	}
+
+	switch databaseType {
+	case "TINYINT", "SMALLINT", "MEDIUMINT", "INT", "BIGINT", "YEAR":
+		if unsigned {
+			return decodeUint
+		}
+		return decodeInt
+	case "BIT":
+		return decodeBit
+	case "DECIMAL":
+		return decodeDecimal
+	case "FLOAT", "DOUBLE":
+		return decodeFloat
+	case "JSON":
+		return decodeJSON
+	case "DATE", "DATETIME", "TIMESTAMP":
+		return decodeTime
+	case "BINARY", "VARBINARY", "BLOB", "TINYBLOB", "MEDIUMBLOB", "LONGBLOB", "GEOMETRY":
+		return decodeBytes
+	}
+
+	return decodeString
+}
+
+func decodeInt(src interface{}) (interface{}, error) {
+	switch value := src.(type) {
+	case int64:
+		return value, nil
+	case []byte:
+		return strconv.ParseInt(string(value), 10, 64)
+	}
+	return nil, unsupported(src)
+}
+
+func decodeUint(src interface{}) (interface{}, error) {
+	switch value := src.(type) {
+	case int64:
+		return uint64(value), nil
+	case uint64:
+		return value, nil
+	case []byte:
+		return strconv.ParseUint(string(value), 10, 64)
+	}
+	return nil, unsupported(src)
+}
+
+// decodeBool - Booleans stored as tinyint(1) or bit(1)
+func decodeBool(src interface{}) (interface{}, error) {
+	switch value := src.(type) {
+	case int64:
+		return value != 0, nil
+	case bool:
+		return value, nil
+	case []byte:
+		// bit(1) comes as a single raw byte, tinyint(1) as text
+		if len(value) == 1 && value[0] <= 1 {
+			return value[0] == 1, nil
+		}
+		v, err := strconv.ParseInt(string(value), 10, 64)
+		return v != 0, err
+	}
+	return nil, unsupported(src)
+}
+
+// decodeBit - BIT(n) value, big endian bytes of up to 64 bits
+func decodeBit(src interface{}) (interface{}, error) {
+	switch value := src.(type) {
+	case int64:
+		return uint64(value), nil
+	case []byte:
+		if len(value) > 8 {
+			return nil, fmt.Errorf("bit value of %d bytes", len(value))
+		}
+		padded := make([]byte, 8)
+		copy(padded[8-len(value):], value)
+		return binary.BigEndian.Uint64(padded), nil
+	}
+	return nil, unsupported(src)
+}
+
+// decodeDecimal - Exact decimal as json.Number, converting to float64 would lose digits
+func decodeDecimal(src interface{}) (interface{}, error) {
+	switch value := src.(type) {
+	case []byte:
+		return json.Number(value), nil
+	case string:
+		return json.Number(value), nil
+	case int64:
+		return json.Number(strconv.FormatInt(value, 10)), nil
+	case float64:
+		return json.Number(strconv.FormatFloat(value, 'f', -1, 64)), nil
+	}
+	return nil, unsupported(src)
+}
+
+func decodeFloat(src interface{}) (interface{}, error) {
+	switch value := src.(type) {
+	case float64:
+		return value, nil
+	case float32:
+		return float64(value), nil
+	case int64:
+		return float64(value), nil
+	case []byte:
+		return strconv.ParseFloat(string(value), 64)
+	}
+	return nil, unsupported(src)
+}
+
+// decodeJSON - Parsed JSON document, numbers are kept as json.Number
+func decodeJSON(src interface{}) (interface{}, error) {
+	var data []byte
+	switch value := src.(type) {
+	case []byte:
+		data = value
+	case string:
+		data = []byte(value)
+	default:
+		return nil, unsupported(src)
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil {
+		return nil, err
+	}
+	return value, nil
+}
+
+// decodeTime - time.Time, zero dates which parseTime can not represent come as text
+func decodeTime(src interface{}) (interface{}, error) {
+	switch value := src.(type) {
+	case time.Time:
+		return value, nil
+	case []byte:
+		return string(value), nil
+	}
+	return nil, unsupported(src)
+}
+
+func decodeBytes(src interface{}) (interface{}, error) {
+	if value, ok := src.([]byte); ok {
+		// The driver reuses its buffer for the next row
+		return append([]byte(nil), value...), nil
+	}
+	return nil, unsupported(src)
+}
+
+func decodeString(src interface{}) (interface{}, error) {
+	switch value := src.(type) {
+	case []byte:
+		return string(value), nil
+	case string:
+		return value, nil
+	case int64:
+		return strconv.FormatInt(value, 10), nil
+	case float64:
+		return strconv.FormatFloat(value, 'f', -1, 64), nil
+	case time.Time:
+		return value, nil
+	}
+	return nil, unsupported(src)
+}
+
+func unsupported(src interface{}) error {
+	return fmt.Errorf("unsupported source type %T", src)
+}
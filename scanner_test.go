@@ -0,0 +1,56 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/graphql-go/graphql"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestColumnDecoder(t *testing.T) {
+	now := time.Now()
+	for _, c := range []struct {
+		databaseType string
+		unsigned     bool
+		schemaType   string
+		src          interface{}
+		expected     interface{}
+	}{
+		{"BIGINT", false, "bigint", []byte("-9223372036854775808"), int64(-9223372036854775808)},
+		{"BIGINT", true, "bigint unsigned", []byte("18446744073709551615"), uint64(18446744073709551615)},
+		{"TINYINT", false, "tinyint(1)", []byte("1"), true},
+		{"TINYINT", false, "tinyint(4)", []byte("7"), int64(7)},
+		{"BIT", false, "bit(1)", []byte{0}, false},
+		{"BIT", false, "bit(16)", []byte{1, 2}, uint64(258)},
+		{"DECIMAL", false, "decimal(30,10)", []byte("12345678901234567890.0123456789"), json.Number("12345678901234567890.0123456789")},
+		{"FLOAT", false, "float", float32(1.5), float64(1.5)},
+		{"JSON", false, "json", []byte(`{"a":[1,2.5]}`), map[string]interface{}{"a": []interface{}{json.Number("1"), json.Number("2.5")}}},
+		{"DATETIME", false, "datetime", now, now},
+		{"VARCHAR", false, "varchar(10)", []byte("abc"), "abc"},
+	} {
+		scanner := &ColValueScanner{column: "c", decode: columnDecoder(c.databaseType, c.unsigned, c.schemaType)}
+		assert.Nil(t, scanner.Scan(c.src), c.schemaType)
+		assert.Equal(t, c.expected, scanner.value, c.schemaType)
+	}
+
+	scanner := &ColValueScanner{column: "c", decode: columnDecoder("INT", false, "int")}
+	assert.NotNil(t, scanner.Scan(struct{}{}))
+	assert.Nil(t, scanner.Scan(nil))
+	assert.Nil(t, scanner.value)
+}
+
+func TestColumnDatatype(t *testing.T) {
+	assert.Equal(t, graphql.Boolean, columnDatatype("tinyint(1)"))
+	assert.Equal(t, graphql.Int, columnDatatype("tinyint(4)"))
+	assert.Equal(t, BigInt, columnDatatype("bigint(20) unsigned"))
+	assert.Equal(t, graphql.Int, columnDatatype("int(11)"))
+	assert.Equal(t, graphql.Int, columnDatatype("mediumint(8) unsigned"))
+	// Unsigned ints above 2^31-1 would be null as Int
+	assert.Equal(t, BigInt, columnDatatype("int(10) unsigned"))
+	assert.Equal(t, json.Number("2147483648"), columnDatatype("int(10) unsigned").(*graphql.Scalar).Serialize(uint64(1<<31)))
+	assert.Equal(t, Decimal, columnDatatype("decimal(10,2)"))
+	assert.Equal(t, graphql.DateTime, columnDatatype("timestamp"))
+	assert.Equal(t, graphql.String, columnDatatype("varbinary(16)"))
+}
@@ -0,0 +1,353 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/language/ast"
+)
+
+// IntrospectionQuery - the introspection query of GraphiQL, answered by /schema.json
+const IntrospectionQuery = `query IntrospectionQuery {
+  __schema {
+    queryType { name }
+    mutationType { name }
+    subscriptionType { name }
+    types { ...FullType }
+    directives { name description locations args { ...InputValue } }
+  }
+}
+
+fragment FullType on __Type {
+  kind
+  name
+  description
+  fields(includeDeprecated: true) {
+    name
+    description
+    args { ...InputValue }
+    type { ...TypeRef }
+    isDeprecated
+    deprecationReason
+  }
+  inputFields { ...InputValue }
+  interfaces { ...TypeRef }
+  enumValues(includeDeprecated: true) { name description isDeprecated deprecationReason }
+  possibleTypes { ...TypeRef }
+}
+
+fragment InputValue on __InputValue {
+  name
+  description
+  type { ...TypeRef }
+  defaultValue
+}
+
+fragment TypeRef on __Type {
+  kind
+  name
+  ofType { kind name ofType { kind name ofType { kind name ofType { kind name ofType { kind name ofType { kind name ofType { kind name } } } } } } }
+}`
+
+// builtinScalars - scalars of the GraphQL spec, SDL leaves them out
+var builtinScalars = map[string]bool{"String": true, "Int": true, "Float": true, "Boolean": true, "ID": true}
+
+// APISchema - Schema of every entity the role of the session can access, with the relations
+// between them, for introspection. The where and order_by arguments are described by input types
+// named after their entity as they collide across entities otherwise, and only the columns the role
+// can filter on are in where.
+func (e *Engine) APISchema(ctx context.Context) (*graphql.Schema, error) {
+	session := SessionFromContext(ctx)
+
+	schemas := map[string]TableSchema{}
+	for entity := range Entities {
+		if _, err := RowFilter(entity, session); err != nil {
+			continue
+		}
+
+		tableSchema, err := e.entitySchema(ctx, entity)
+		if err != nil {
+			return nil, err
+		}
+		schemas[entity] = tableSchema
+	}
+	if len(schemas) == 0 {
+		return nil, fmt.Errorf("%w: role %q can not access any entity", ErrAccessDenied, session.Role)
+	}
+
+	objectTypes := e.objectTypes(schemas, session.Role)
+	comparisons := map[string]*graphql.InputObject{}
+	fields := graphql.Fields{}
+	for entity, tableSchema := range schemas {
+		args := graphql.FieldConfigArgument{}
+		for name, arg := range DefaultArgs {
+			args[name] = arg
+		}
+
+		fieldTypes := argumentTypes(entity, tableSchema)
+		whereFields := graphql.InputObjectConfigFieldMap{}
+		for _, name := range filterableFields(entity, session.Role, tableSchema) {
+			columnType := fieldTypes[name]
+			whereFields[name] = &graphql.InputObjectFieldConfig{Type: comparisonType(columnDatatype(columnType), comparisons)}
+		}
+		if len(whereFields) > 0 {
+			args["where"] = &graphql.ArgumentConfig{
+				Type: graphql.NewInputObject(graphql.InputObjectConfig{
+					Name:        Entities.GetInputTypeName(entity, "where"),
+					Description: "where condition",
+					Fields:      whereFields,
+				}),
+			}
+		}
+
+		orderFields := graphql.InputObjectConfigFieldMap{}
+		for name := range fieldTypes {
+			orderFields[name] = &graphql.InputObjectFieldConfig{Type: graphql.String, Description: "asc or desc"}
+		}
+		args["order_by"] = &graphql.ArgumentConfig{
+			Type: graphql.NewList(graphql.NewInputObject(graphql.InputObjectConfig{
+				Name:   Entities.GetInputTypeName(entity, "order_by"),
+				Fields: orderFields,
+			})),
+		}
+
+		fields[Entities.GetGraphQLName(entity)] = &graphql.Field{
+			Type:    graphql.NewList(objectTypes[entity]),
+			Args:    args,
+			Resolve: e.Resolver(entity, tableSchema),
+		}
+	}
+
+	config := graphql.SchemaConfig{
+		Query:      graphql.NewObject(graphql.ObjectConfig{Name: "Query", Fields: fields}),
+		Directives: append(graphql.SpecifiedDirectives, primaryDirective),
+	}
+	if e.ChangeFeed != nil {
+		config.Subscription = graphql.NewObject(graphql.ObjectConfig{Name: "Subscription", Fields: fields})
+	}
+
+	schema, err := graphql.NewSchema(config)
+	if err != nil {
+		return nil, err
+	}
+
+	return &schema, nil
+}
+
+// comparisonType - Input type of the comparison operators on a column of type datatype, one per
+// scalar
+func comparisonType(datatype graphql.Output, comparisons map[string]*graphql.InputObject) *graphql.InputObject {
+	name := datatype.Name() + "Comparison"
+	if comparison, ok := comparisons[name]; ok {
+		return comparison
+	}
+
+	input := datatype.(graphql.Input)
+	fields := graphql.InputObjectConfigFieldMap{}
+	for _, op := range supportedComparisonOps {
+		if op == In {
+			fields[op] = &graphql.InputObjectFieldConfig{Type: graphql.NewList(input)}
+			continue
+		}
+		fields[op] = &graphql.InputObjectFieldConfig{Type: input}
+	}
+
+	comparisons[name] = graphql.NewInputObject(graphql.InputObjectConfig{Name: name, Fields: fields})
+	return comparisons[name]
+}
+
+// isIntrospection - Whether the operation of document only selects introspection fields, like
+// __schema, which are answered from the APISchema
+func isIntrospection(document *ast.Document) bool {
+	operation, ok := document.Definitions[0].(*ast.OperationDefinition)
+	if !ok || operation.SelectionSet == nil || len(operation.SelectionSet.Selections) == 0 {
+		return false
+	}
+
+	for _, selection := range operation.SelectionSet.Selections {
+		field, ok := selection.(*ast.Field)
+		if !ok || !strings.HasPrefix(field.Name.Value, "__") {
+			return false
+		}
+	}
+
+	return true
+}
+
+// SDLHandler - Serve the APISchema of the role of the caller in the schema definition language
+func SDLHandler(engine *Engine) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		schema, err := engine.APISchema(r.Context())
+		if err != nil {
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+			w.WriteHeader(schemaErrorStatus(err))
+			_, _ = w.Write([]byte(err.Error()))
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		_, _ = w.Write([]byte(PrintSchema(schema)))
+	})
+}
+
+// IntrospectionHandler - Serve the result of the IntrospectionQuery on the APISchema of the role of
+// the caller
+func IntrospectionHandler(engine *Engine) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		schema, err := engine.APISchema(r.Context())
+		if err != nil {
+			w.WriteHeader(schemaErrorStatus(err))
+			_ = json.NewEncoder(w).Encode(errorResult(err))
+			return
+		}
+
+		_ = json.NewEncoder(w).Encode(graphql.Do(graphql.Params{
+			Schema:        *schema,
+			RequestString: IntrospectionQuery,
+			Context:       r.Context(),
+		}))
+	})
+}
+
+func schemaErrorStatus(err error) int {
+	if errors.Is(err, ErrAccessDenied) {
+		return http.StatusForbidden
+	}
+
+	return http.StatusInternalServerError
+}
+
+// PrintSchema - schema in the schema definition language, types in alphabetical order
+func PrintSchema(schema *graphql.Schema) string {
+	var blocks []string
+
+	for _, directive := range schema.Directives() {
+		if isSpecifiedDirective(directive) {
+			continue
+		}
+		block := printDescription(directive.Description, "") + "directive @" + directive.Name + printArgs(directive.Args)
+		blocks = append(blocks, block+" on "+strings.Join(directive.Locations, " | "))
+	}
+
+	typeMap := schema.TypeMap()
+	names := make([]string, 0, len(typeMap))
+	for name := range typeMap {
+		if !strings.HasPrefix(name, "__") && !builtinScalars[name] {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if block := printType(typeMap[name]); block != "" {
+			blocks = append(blocks, block)
+		}
+	}
+
+	return strings.Join(blocks, "\n\n") + "\n"
+}
+
+func isSpecifiedDirective(directive *graphql.Directive) bool {
+	for _, specified := range graphql.SpecifiedDirectives {
+		if specified.Name == directive.Name {
+			return true
+		}
+	}
+
+	return false
+}
+
+func printType(t graphql.Type) string {
+	description := printDescription(t.Description(), "")
+
+	switch t := t.(type) {
+	case *graphql.Scalar:
+		return description + "scalar " + t.Name()
+	case *graphql.Object:
+		fields := t.Fields()
+		lines := make([]string, 0, len(fields))
+		for _, name := range fieldNames(fields) {
+			field := fields[name]
+			lines = append(lines, printDescription(field.Description, "  ")+"  "+name+printArgs(field.Args)+": "+field.Type.String())
+		}
+		return description + "type " + t.Name() + " {\n" + strings.Join(lines, "\n") + "\n}"
+	case *graphql.InputObject:
+		fields := t.Fields()
+		lines := make([]string, 0, len(fields))
+		for _, name := range fieldNames(fields) {
+			field := fields[name]
+			lines = append(lines, printDescription(field.Description(), "  ")+"  "+name+": "+field.Type.String()+printDefault(field.DefaultValue))
+		}
+		return description + "input " + t.Name() + " {\n" + strings.Join(lines, "\n") + "\n}"
+	case *graphql.Enum:
+		lines := make([]string, 0, len(t.Values()))
+		for _, value := range t.Values() {
+			lines = append(lines, printDescription(value.Description, "  ")+"  "+value.Name)
+		}
+		return description + "enum " + t.Name() + " {\n" + strings.Join(lines, "\n") + "\n}"
+	}
+
+	return ""
+}
+
+func printArgs(args []*graphql.Argument) string {
+	if len(args) == 0 {
+		return ""
+	}
+
+	sorted := append([]*graphql.Argument{}, args...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Name() < sorted[j].Name() })
+
+	printed := make([]string, 0, len(sorted))
+	for _, arg := range sorted {
+		printed = append(printed, arg.Name()+": "+arg.Type.String()+printDefault(arg.DefaultValue))
+	}
+
+	return "(" + strings.Join(printed, ", ") + ")"
+}
+
+func printDefault(value interface{}) string {
+	switch value := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return " = " + strconv.Quote(value)
+	}
+
+	return fmt.Sprintf(" = %v", value)
+}
+
+func printDescription(description, indent string) string {
+	if description == "" {
+		return ""
+	}
+
+	return indent + strconv.Quote(description) + "\n"
+}
+
+// fieldNames - Names of the fields of an object or input object in alphabetical order
+func fieldNames(fields interface{}) []string {
+	var keys []string
+	switch fields := fields.(type) {
+	case graphql.FieldDefinitionMap:
+		for key := range fields {
+			keys = append(keys, key)
+		}
+	case graphql.InputObjectFieldMap:
+		for key := range fields {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	return keys
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEngine_APISchema(t *testing.T) {
+	entities := Entities
+	Entities = EntityConfigs{
+		"schema_payments": {
+			TableName:      "schema_payments",
+			AllowedFilters: []string{"id", "card_number"},
+			ColumnMasks: map[string]map[string]MaskRule{
+				"card_number": {"merchant": {Strategy: MaskRedact}},
+			},
+			Relations: map[string]Relation{
+				"merchant": {Entity: "schema_merchants", Column: "merchant_id", RelatedColumn: "id"},
+			},
+		},
+		"schema_merchants": {
+			TableName: "schema_merchants",
+			RowFilters: map[string]map[string]interface{}{
+				"admin": nil,
+			},
+		},
+	}
+	defer func() { Entities = entities }()
+
+	dataSource := &fakeDataSource{schema: TableSchema{"id": "int", "merchant_id": "int", "card_number": "varchar(16)"}}
+	engine := NewEngine(map[string]DataSource{DefaultDataSource: dataSource})
+
+	admin := WithSession(context.Background(), NewSession("admin", nil))
+	schema, err := engine.APISchema(admin)
+	assert.Nil(t, err)
+	sdl := PrintSchema(schema)
+	assert.Contains(t, sdl, "directive @primary on QUERY | FIELD")
+	assert.Contains(t, sdl, "type Query {\n  schema_merchants(")
+	assert.Contains(t, sdl, "  schema_payments(limit: Int = 100, offset: Int = 0, order_by: [schema_payments_order_by], where: schema_payments_where): [schema_payments]")
+	assert.Contains(t, sdl, "type schema_payments {\n  card_number: String\n  id: Int\n  merchant: schema_merchants\n  merchant_id: Int\n}")
+	assert.Contains(t, sdl, "input schema_payments_where {\n  card_number: StringComparison\n  id: IntComparison\n}")
+	assert.Contains(t, sdl, "input IntComparison {\n  _eq: Int\n  _gt: Int\n  _gte: Int\n  _in: [Int]\n  _lt: Int\n  _lte: Int\n}")
+	assert.NotContains(t, sdl, "type Subscription")
+
+	// Merchants can not filter on masked columns nor see entities they can not access
+	schema, err = engine.APISchema(WithSession(context.Background(), NewSession("merchant", nil)))
+	assert.Nil(t, err)
+	sdl = PrintSchema(schema)
+	assert.Contains(t, sdl, "input schema_payments_where {\n  id: IntComparison\n}")
+	assert.NotContains(t, sdl, "schema_merchants")
+
+	// Introspection queries are answered from the schema of the role
+	result := engine.Query(admin, `{ __type(name: "schema_payments") { fields { name } } }`)
+	assert.Empty(t, result.Errors)
+	assert.Len(t, result.Data.(map[string]interface{})["__type"].(map[string]interface{})["fields"], 4)
+
+	result = engine.Query(admin, IntrospectionQuery)
+	assert.Empty(t, result.Errors)
+	assert.Empty(t, dataSource.queries)
+}
+
+func TestSchemaHandlers(t *testing.T) {
+	entities := Entities
+	Entities = EntityConfigs{"schema_payments": {
+		TableName:  "schema_payments",
+		RowFilters: map[string]map[string]interface{}{"admin": nil},
+	}}
+	defer func() { Entities = entities }()
+
+	engine := NewEngine(map[string]DataSource{DefaultDataSource: &fakeDataSource{schema: TableSchema{"id": "int"}}})
+	authenticator := NewAPIKeyAuthenticator("", map[string]APIKey{
+		"secret": {Role: "admin"},
+		"viewer": {Role: "viewer"},
+	})
+
+	get := func(handler http.Handler, path string, headers map[string]string) (*http.Response, string) {
+		server := httptest.NewServer(handler)
+		defer server.Close()
+
+		response, err := http.DefaultClient.Do(newRequest(t, http.MethodGet, server.URL+path, "", headers))
+		assert.Nil(t, err)
+		defer response.Body.Close()
+		body, _ := ioutil.ReadAll(response.Body)
+
+		return response, string(body)
+	}
+
+	admin := map[string]string{"X-Api-Key": "secret"}
+	response, body := get(AuthMiddleware(authenticator, SDLHandler(engine)), "/schema.graphql", admin)
+	assert.Equal(t, http.StatusOK, response.StatusCode)
+	assert.Contains(t, body, "type schema_payments {\n  id: Int\n}")
+
+	response, _ = get(AuthMiddleware(authenticator, SDLHandler(engine)), "/schema.graphql", map[string]string{"X-Api-Key": "viewer"})
+	assert.Equal(t, http.StatusForbidden, response.StatusCode)
+
+	response, body = get(AuthMiddleware(authenticator, IntrospectionHandler(engine)), "/schema.json", admin)
+	assert.Equal(t, http.StatusOK, response.StatusCode)
+	var introspection struct {
+		Data struct {
+			Schema struct {
+				QueryType struct{ Name string }
+			} `json:"__schema"`
+		}
+	}
+	assert.Nil(t, json.Unmarshal([]byte(body), &introspection))
+	assert.Equal(t, "Query", introspection.Data.Schema.QueryType.Name)
+
+	graphiql := GraphiQLHandler("/graphiql", "/graphql")
+	response, body = get(graphiql, "/graphiql", nil)
+	assert.Equal(t, "text/html; charset=utf-8", response.Header.Get("Content-Type"))
+	assert.Contains(t, body, `data-endpoint="/graphql"`)
+	assert.Contains(t, body, `src="/graphiql/graphiql.js"`)
+	assert.Contains(t, body, `src="/graphiql/graphiql.min.js"`)
+	// Every asset is served from the binary
+	assert.NotContains(t, body, "://")
+
+	response, body = get(graphiql, "/graphiql/graphiql.js", nil)
+	assert.Equal(t, http.StatusOK, response.StatusCode)
+	assert.True(t, strings.Contains(body, "React.createElement(GraphiQL"))
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"strings"
+)
+
+// SessionVariablePrefix - string values in permission expressions starting with this prefix are
+// treated as references to session variables, eg {"merchant_id": {"_eq": "X-Merchant-Id"}}
+const SessionVariablePrefix = "x-"
+
+// DefaultRole - role assumed when the request carries no role
+const DefaultRole = "anonymous"
+
+// Session - identity of the caller, a role and the session variables attached to the request
+type Session struct {
+	Role      string
+	Variables map[string]string
+}
+
+type sessionContextKey struct{}
+
+func NewSession(role string, variables map[string]string) *Session {
+	if role == "" {
+		role = DefaultRole
+	}
+
+	session := &Session{
+		Role:      role,
+		Variables: map[string]string{},
+	}
+	for name, value := range variables {
+		session.Variables[strings.ToLower(name)] = value
+	}
+
+	return session
+}
+
+// WithSession - Attach session to the context so that resolvers can read it
+func WithSession(ctx context.Context, session *Session) context.Context {
+	return context.WithValue(ctx, sessionContextKey{}, session)
+}
+
+// SessionFromContext - Session attached to the context, anonymous session if there is none
+func SessionFromContext(ctx context.Context) *Session {
+	if ctx != nil {
+		if session, ok := ctx.Value(sessionContextKey{}).(*Session); ok && session != nil {
+			return session
+		}
+	}
+
+	return NewSession(DefaultRole, nil)
+}
+
+// Get - Session variable value, names are case insensitive
+func (s *Session) Get(name string) (string, bool) {
+	value, ok := s.Variables[strings.ToLower(name)]
+	return value, ok
+}
+
+func isSessionVariable(value string) bool {
+	return strings.HasPrefix(strings.ToLower(value), SessionVariablePrefix)
+}
@@ -0,0 +1,463 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/graphql-go/graphql"
+)
+
+const (
+	// DefaultHeartbeatInterval - time between two heartbeats of an event stream, below the idle
+	// timeout of common proxies
+	DefaultHeartbeatInterval = 12 * time.Second
+	// DefaultReconnectTimeout - time a single connection event stream may be reconnected in
+	DefaultReconnectTimeout = 30 * time.Second
+)
+
+// EventStreamTokenHeader - header carrying the token of a single connection event stream
+const EventStreamTokenHeader = "X-GraphQL-Event-Stream-Token"
+
+// sseReplayBuffer - events of a single connection event stream kept for clients reconnecting
+const sseReplayBuffer = 256
+
+// graphql-sse event names
+const (
+	sseNext     = "next"
+	sseComplete = "complete"
+)
+
+type sseEvent struct {
+	seq  int
+	id   string
+	name string
+	data []byte
+}
+
+// SSEHandler - Serve subscriptions, and queries, as server-sent events with the graphql-sse
+// protocol. In distinct connections mode every operation is a request answered with its event
+// stream. In single connection mode a PUT reserves a stream, its token opens the stream with a GET,
+// starts operations with a POST and stops them with a DELETE.
+//
+// Streams send a heartbeat comment every HeartbeatInterval. Clients reconnecting with the
+// Last-Event-ID header get the events of a single connection stream they missed, a distinct
+// connection stream skips its first result if the client has it already.
+func SSEHandler(engine *Engine, config HandlerConfig) http.Handler {
+	streams := &sseStreams{
+		engine:       engine,
+		config:       config,
+		reservations: map[string]*sseReservation{},
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := r.Header.Get(EventStreamTokenHeader)
+		if token == "" {
+			token = r.URL.Query().Get("token")
+		}
+
+		switch {
+		case r.Method == http.MethodPut:
+			streams.reserve(w, r)
+		case token != "":
+			reservation, ok := streams.reservation(token, SessionFromContext(r.Context()))
+			if !ok {
+				writeErrors(w, http.StatusNotFound, errorResult(errors.New("stream not found")))
+				return
+			}
+
+			switch r.Method {
+			case http.MethodGet:
+				streams.stream(w, r, reservation)
+			case http.MethodPost:
+				streams.start(w, r, reservation)
+			case http.MethodDelete:
+				reservation.stop(r.URL.Query().Get("operationId"))
+				w.WriteHeader(http.StatusOK)
+			default:
+				w.WriteHeader(http.StatusMethodNotAllowed)
+			}
+		default:
+			streams.distinct(w, r)
+		}
+	})
+}
+
+type sseStreams struct {
+	engine *Engine
+	config HandlerConfig
+
+	mu           sync.Mutex
+	reservations map[string]*sseReservation
+}
+
+// sseReservation - single connection event stream, its operations outlive the requests that
+// started them until the stream is gone for longer than the reconnect timeout
+type sseReservation struct {
+	session *Session
+	ctx     context.Context
+	cancel  context.CancelFunc
+
+	mu         sync.Mutex
+	events     []sseEvent
+	seq        int
+	connected  bool
+	signal     chan struct{}
+	operations map[string]context.CancelFunc
+	expiry     *time.Timer
+}
+
+// distinct - Run the operation of r and answer with its event stream
+func (s *sseStreams) distinct(w http.ResponseWriter, r *http.Request) {
+	requests, batch, err := decodeGraphQLRequests(r)
+	if err == nil && batch {
+		err = errors.New("event streams run a single operation")
+	}
+	if err != nil {
+		writeErrors(w, http.StatusBadRequest, errorResult(err))
+		return
+	}
+
+	results, status, err := s.subscribe(r.Context(), r, requests[0])
+	if err != nil {
+		writeErrors(w, status, &graphql.Result{Errors: subscriptionErrors(err)})
+		return
+	}
+
+	flusher, ok := startEventStream(w)
+	if !ok {
+		return
+	}
+
+	heartbeat := time.NewTicker(s.config.heartbeatInterval())
+	defer heartbeat.Stop()
+
+	lastEventID := r.Header.Get("Last-Event-ID")
+	first := true
+	for {
+		select {
+		case result, ok := <-results:
+			if !ok {
+				writeEvent(w, sseEvent{name: sseComplete})
+				flusher.Flush()
+				return
+			}
+
+			data, err := json.Marshal(result)
+			if err != nil {
+				data, _ = json.Marshal(errorResult(err))
+			}
+			// The id identifies the result, a client reconnecting has the current one already
+			// unless it changed while it was away
+			id := eventID(data)
+			if !first || id != lastEventID {
+				writeEvent(w, sseEvent{id: id, name: sseNext, data: data})
+				flusher.Flush()
+			}
+			first = false
+		case <-heartbeat.C:
+			writeHeartbeat(w)
+			flusher.Flush()
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// subscribe - Results of request for the caller of r, with the status to reject it with
+func (s *sseStreams) subscribe(ctx context.Context, r *http.Request, request *GraphQLRequest) (<-chan *graphql.Result, int, error) {
+	resolved, err := resolveGraphQLRequest(request, s.config)
+	if err != nil {
+		status := http.StatusBadRequest
+		if persistedErr, ok := err.(*PersistedQueryError); ok {
+			status = persistedErr.Status
+		}
+		return nil, status, err
+	}
+
+	if r.Header.Get(PrimaryHeader) == "true" {
+		ctx = WithPrimaryRead(ctx)
+	}
+
+	results, err := s.engine.Subscribe(ctx, resolved)
+	if err != nil {
+		return nil, http.StatusBadRequest, err
+	}
+
+	return results, http.StatusOK, nil
+}
+
+// reserve - Reserve a single connection stream for the session of r, answered with its token
+func (s *sseStreams) reserve(w http.ResponseWriter, r *http.Request) {
+	token, err := streamToken()
+	if err != nil {
+		writeErrors(w, http.StatusInternalServerError, errorResult(err))
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	reservation := &sseReservation{
+		session:    SessionFromContext(r.Context()),
+		ctx:        ctx,
+		cancel:     cancel,
+		signal:     make(chan struct{}, 1),
+		operations: map[string]context.CancelFunc{},
+	}
+	// Reservations whose stream is never opened expire like dropped streams
+	reservation.expiry = time.AfterFunc(s.config.reconnectTimeout(), func() { s.drop(token) })
+
+	s.mu.Lock()
+	s.reservations[token] = reservation
+	s.mu.Unlock()
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(http.StatusCreated)
+	_, _ = w.Write([]byte(token))
+}
+
+// reservation - Stream of token if it was reserved by session, the token alone grants no access
+func (s *sseStreams) reservation(token string, session *Session) (*sseReservation, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	reservation, ok := s.reservations[token]
+	if !ok || reservation.session.Role != session.Role || !reflect.DeepEqual(reservation.session.Variables, session.Variables) {
+		return nil, false
+	}
+
+	return reservation, true
+}
+
+func (s *sseStreams) drop(token string) {
+	s.mu.Lock()
+	reservation, ok := s.reservations[token]
+	delete(s.reservations, token)
+	s.mu.Unlock()
+
+	if ok {
+		reservation.cancel()
+	}
+}
+
+// stream - Send the events of reservation until the client goes away, a client reconnecting gets
+// the events after its Last-Event-ID first
+func (s *sseStreams) stream(w http.ResponseWriter, r *http.Request, reservation *sseReservation) {
+	reservation.mu.Lock()
+	if reservation.connected {
+		reservation.mu.Unlock()
+		writeErrors(w, http.StatusConflict, errorResult(errors.New("stream is already open")))
+		return
+	}
+	reservation.connected = true
+	reservation.expiry.Stop()
+	reservation.mu.Unlock()
+
+	defer func() {
+		reservation.mu.Lock()
+		reservation.connected = false
+		reservation.expiry.Reset(s.config.reconnectTimeout())
+		reservation.mu.Unlock()
+	}()
+
+	flusher, ok := startEventStream(w)
+	if !ok {
+		return
+	}
+
+	heartbeat := time.NewTicker(s.config.heartbeatInterval())
+	defer heartbeat.Stop()
+
+	last, _ := strconv.Atoi(r.Header.Get("Last-Event-ID"))
+	for {
+		for _, event := range reservation.eventsAfter(last) {
+			writeEvent(w, event)
+			last = event.seq
+		}
+		flusher.Flush()
+
+		select {
+		case <-reservation.signal:
+		case <-heartbeat.C:
+			writeHeartbeat(w)
+		case <-r.Context().Done():
+			return
+		case <-reservation.ctx.Done():
+			return
+		}
+	}
+}
+
+// start - Start the operation of r on reservation, its events are sent on the stream
+func (s *sseStreams) start(w http.ResponseWriter, r *http.Request, reservation *sseReservation) {
+	var request GraphQLRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		writeErrors(w, http.StatusBadRequest, errorResult(err))
+		return
+	}
+
+	id := request.Extensions.OperationID
+	if id == "" {
+		writeErrors(w, http.StatusBadRequest, errorResult(errors.New("operationId extension is missing")))
+		return
+	}
+
+	ctx, cancel := context.WithCancel(WithSession(reservation.ctx, SessionFromContext(r.Context())))
+	if !reservation.register(id, cancel) {
+		cancel()
+		writeErrors(w, http.StatusConflict, errorResult(fmt.Errorf("operation %s already exists", id)))
+		return
+	}
+
+	results, status, err := s.subscribe(ctx, r, &request)
+	if err != nil {
+		reservation.stop(id)
+		writeErrors(w, status, &graphql.Result{Errors: subscriptionErrors(err)})
+		return
+	}
+
+	go func() {
+		defer cancel()
+
+		for result := range results {
+			reservation.push(sseNext, map[string]interface{}{"id": id, "payload": result})
+		}
+
+		// Operations stopped by the client are not completed again
+		if reservation.unregister(id) {
+			reservation.push(sseComplete, map[string]interface{}{"id": id})
+		}
+	}()
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func (r *sseReservation) register(id string, cancel context.CancelFunc) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.operations[id]; ok {
+		return false
+	}
+	r.operations[id] = cancel
+
+	return true
+}
+
+func (r *sseReservation) unregister(id string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	_, ok := r.operations[id]
+	delete(r.operations, id)
+
+	return ok
+}
+
+func (r *sseReservation) stop(id string) {
+	r.mu.Lock()
+	cancel, ok := r.operations[id]
+	delete(r.operations, id)
+	r.mu.Unlock()
+
+	if ok {
+		cancel()
+	}
+}
+
+// push - Queue an event for the stream, only the last sseReplayBuffer events are kept
+func (r *sseReservation) push(name string, payload interface{}) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return
+	}
+
+	r.mu.Lock()
+	r.seq++
+	r.events = append(r.events, sseEvent{seq: r.seq, id: strconv.Itoa(r.seq), name: name, data: data})
+	if len(r.events) > sseReplayBuffer {
+		r.events = r.events[len(r.events)-sseReplayBuffer:]
+	}
+	r.mu.Unlock()
+
+	select {
+	case r.signal <- struct{}{}:
+	default:
+	}
+}
+
+func (r *sseReservation) eventsAfter(seq int) []sseEvent {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var events []sseEvent
+	for _, event := range r.events {
+		if event.seq > seq {
+			events = append(events, event)
+		}
+	}
+
+	return events
+}
+
+func startEventStream(w http.ResponseWriter) (http.Flusher, bool) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeErrors(w, http.StatusInternalServerError, errorResult(errors.New("streaming is not supported")))
+		return nil, false
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	// Proxies must not buffer the stream
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	return flusher, true
+}
+
+func writeEvent(w http.ResponseWriter, event sseEvent) {
+	var buffer bytes.Buffer
+	if event.id != "" {
+		fmt.Fprintf(&buffer, "id: %s\n", event.id)
+	}
+	fmt.Fprintf(&buffer, "event: %s\ndata: %s\n\n", event.name, event.data)
+
+	_, _ = w.Write(buffer.Bytes())
+}
+
+func writeHeartbeat(w http.ResponseWriter) {
+	_, _ = w.Write([]byte(":\n\n"))
+}
+
+func writeErrors(w http.ResponseWriter, status int, result *graphql.Result) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(result)
+}
+
+// eventID - Id of the event with data, the same data always gets the same id
+func eventID(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:8])
+}
+
+func streamToken() (string, error) {
+	token := make([]byte, 16)
+	if _, err := rand.Read(token); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(token), nil
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type testEvent struct {
+	id   string
+	name string
+	data string
+}
+
+// openEventStream - Events of the stream answering request, heartbeats are sent as events without
+// a name
+func openEventStream(t *testing.T, request *http.Request) (*http.Response, <-chan testEvent) {
+	response, err := http.DefaultClient.Do(request)
+	assert.Nil(t, err)
+
+	events := make(chan testEvent, 100)
+	go func() {
+		defer close(events)
+
+		reader := bufio.NewReader(response.Body)
+		var event testEvent
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				return
+			}
+
+			line = strings.TrimSuffix(line, "\n")
+			switch {
+			case line == "":
+				events <- event
+				event = testEvent{}
+			case strings.HasPrefix(line, "id: "):
+				event.id = strings.TrimPrefix(line, "id: ")
+			case strings.HasPrefix(line, "event: "):
+				event.name = strings.TrimPrefix(line, "event: ")
+			case strings.HasPrefix(line, "data: "):
+				event.data = strings.TrimPrefix(line, "data: ")
+			}
+		}
+	}()
+
+	return response, events
+}
+
+func nextEvent(t *testing.T, events <-chan testEvent) testEvent {
+	for {
+		select {
+		case event, ok := <-events:
+			if !ok {
+				t.Fatal("stream closed")
+			}
+			if event.name != "" {
+				return event
+			}
+		case <-time.After(time.Second):
+			t.Fatal("no event sent")
+		}
+	}
+}
+
+func newRequest(t *testing.T, method, url, body string, headers map[string]string) *http.Request {
+	request, err := http.NewRequest(method, url, strings.NewReader(body))
+	assert.Nil(t, err)
+	for name, value := range headers {
+		request.Header.Set(name, value)
+	}
+
+	return request
+}
+
+func TestSSEHandler_DistinctConnections(t *testing.T) {
+	Entities["sse_test"] = EntityConfig{TableName: "sse_test"}
+	defer delete(Entities, "sse_test")
+
+	dataSource := &fakeDataSource{
+		schema: TableSchema{"id": "int", "status": "varchar(10)"},
+		rows:   []map[string]interface{}{{"id": int64(1), "status": "pending"}},
+	}
+	feed := &fakeFeed{}
+	engine := NewEngine(map[string]DataSource{DefaultDataSource: dataSource})
+	engine.ChangeFeed = feed
+
+	server := httptest.NewServer(SSEHandler(engine, HandlerConfig{HeartbeatInterval: 10 * time.Millisecond}))
+	defer server.Close()
+
+	subscription := `{"query": "subscription { sse_test { id status } }"}`
+	response, events := openEventStream(t, newRequest(t, http.MethodPost, server.URL, subscription, nil))
+	assert.Equal(t, "text/event-stream", response.Header.Get("Content-Type"))
+
+	first := nextEvent(t, events)
+	assert.Equal(t, "next", first.name)
+	assert.JSONEq(t, `{"data": {"sse_test": [{"id": 1, "status": "pending"}]}}`, first.data)
+
+	// Heartbeats keep the stream open while nothing changes
+	select {
+	case event := <-events:
+		assert.Equal(t, testEvent{}, event)
+	case <-time.After(time.Second):
+		t.Fatal("no heartbeat sent")
+	}
+
+	dataSource.setRows([]map[string]interface{}{{"id": int64(1), "status": "settled"}})
+	feed.change("sse_test")
+	event := nextEvent(t, events)
+	assert.JSONEq(t, `{"data": {"sse_test": [{"id": 1, "status": "settled"}]}}`, event.data)
+	response.Body.Close()
+	assert.Eventually(t, func() bool { return feed.watching("sse_test") == 0 }, time.Second, time.Millisecond)
+
+	// A client reconnecting with the current result does not get it again
+	response, events = openEventStream(t, newRequest(t, http.MethodPost, server.URL, subscription, map[string]string{
+		"Last-Event-ID": event.id,
+	}))
+	dataSource.setRows([]map[string]interface{}{{"id": int64(1), "status": "refunded"}})
+	assert.Eventually(t, func() bool { return feed.watching("sse_test") == 1 }, time.Second, time.Millisecond)
+	feed.change("sse_test")
+	assert.JSONEq(t, `{"data": {"sse_test": [{"id": 1, "status": "refunded"}]}}`, nextEvent(t, events).data)
+	response.Body.Close()
+
+	// Queries complete after their result
+	response, events = openEventStream(t, newRequest(t, http.MethodGet, server.URL+"?query={sse_test{id}}", "", nil))
+	assert.Equal(t, "next", nextEvent(t, events).name)
+	assert.Equal(t, "complete", nextEvent(t, events).name)
+	response.Body.Close()
+
+	response, err := http.Post(server.URL, "application/json", strings.NewReader(`{"query": "subscription { sse_test { unknown } }"}`))
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusBadRequest, response.StatusCode)
+	response.Body.Close()
+}
+
+func TestSSEHandler_SingleConnection(t *testing.T) {
+	Entities["sse_test"] = EntityConfig{TableName: "sse_test"}
+	defer delete(Entities, "sse_test")
+
+	dataSource := &fakeDataSource{
+		schema: TableSchema{"id": "int", "status": "varchar(10)"},
+		rows:   []map[string]interface{}{{"id": int64(1), "status": "pending"}},
+	}
+	feed := &fakeFeed{}
+	engine := NewEngine(map[string]DataSource{DefaultDataSource: dataSource})
+	engine.ChangeFeed = feed
+
+	server := httptest.NewServer(SSEHandler(engine, HandlerConfig{ReconnectTimeout: 100 * time.Millisecond}))
+	defer server.Close()
+
+	response, err := http.DefaultClient.Do(newRequest(t, http.MethodPut, server.URL, "", nil))
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusCreated, response.StatusCode)
+	body, _ := ioutil.ReadAll(response.Body)
+	response.Body.Close()
+	token := map[string]string{EventStreamTokenHeader: string(body)}
+
+	stream, events := openEventStream(t, newRequest(t, http.MethodGet, server.URL, "", token))
+	assert.Equal(t, http.StatusOK, stream.StatusCode)
+
+	// A token streams once at a time
+	response, err = http.DefaultClient.Do(newRequest(t, http.MethodGet, server.URL, "", token))
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusConflict, response.StatusCode)
+	response.Body.Close()
+
+	start := func(body string) int {
+		response, err := http.DefaultClient.Do(newRequest(t, http.MethodPost, server.URL, body, token))
+		assert.Nil(t, err)
+		response.Body.Close()
+		return response.StatusCode
+	}
+	assert.Equal(t, http.StatusBadRequest, start(`{"query": "subscription { sse_test { id } }"}`))
+	assert.Equal(t, http.StatusAccepted, start(`{"query": "subscription { sse_test { id status } }", "extensions": {"operationId": "1"}}`))
+	assert.Equal(t, http.StatusConflict, start(`{"query": "subscription { sse_test { id } }", "extensions": {"operationId": "1"}}`))
+
+	event := nextEvent(t, events)
+	assert.Equal(t, "next", event.name)
+	assert.JSONEq(t, `{"id": "1", "payload": {"data": {"sse_test": [{"id": 1, "status": "pending"}]}}}`, event.data)
+
+	// Events sent while the client is away are replayed on reconnect
+	stream.Body.Close()
+	assert.Equal(t, http.StatusAccepted, start(`{"query": "{ sse_test { id } }", "extensions": {"operationId": "2"}}`))
+	// The stream is taken until the server notices the client left
+	for deadline := time.Now().Add(time.Second); ; time.Sleep(10 * time.Millisecond) {
+		stream, events = openEventStream(t, newRequest(t, http.MethodGet, server.URL, "", map[string]string{
+			EventStreamTokenHeader: token[EventStreamTokenHeader],
+			"Last-Event-ID":        event.id,
+		}))
+		if stream.StatusCode == http.StatusOK || time.Now().After(deadline) {
+			break
+		}
+		stream.Body.Close()
+	}
+	assert.JSONEq(t, `{"id": "2", "payload": {"data": {"sse_test": [{"id": 1}]}}}`, nextEvent(t, events).data)
+	event = nextEvent(t, events)
+	assert.Equal(t, "complete", event.name)
+	assert.JSONEq(t, `{"id": "2"}`, event.data)
+
+	response, err = http.DefaultClient.Do(newRequest(t, http.MethodDelete, server.URL+"?operationId=1", "", token))
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, response.StatusCode)
+	response.Body.Close()
+	assert.Eventually(t, func() bool { return feed.watching("sse_test") == 0 }, time.Second, time.Millisecond)
+
+	// Streams gone longer than the reconnect timeout are dropped
+	stream.Body.Close()
+	assert.Eventually(t, func() bool {
+		response, err := http.DefaultClient.Do(newRequest(t, http.MethodDelete, server.URL+"?operationId=1", "", token))
+		assert.Nil(t, err)
+		response.Body.Close()
+		return response.StatusCode == http.StatusNotFound
+	}, time.Second, 10*time.Millisecond)
+
+	var result map[string]interface{}
+	response, err = http.DefaultClient.Do(newRequest(t, http.MethodGet, server.URL, "", map[string]string{EventStreamTokenHeader: "unknown"}))
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusNotFound, response.StatusCode)
+	assert.Nil(t, json.NewDecoder(response.Body).Decode(&result))
+	response.Body.Close()
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"container/list"
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"net"
+	"strings"
+	"sync"
+
+	mysqldriver "github.com/go-sql-driver/mysql"
+)
+
+// DefaultStatementCacheSize - prepared statements kept per pool when the size is not configured
+const DefaultStatementCacheSize = 256
+
+// Server errors meaning a prepared statement is gone or stale and has to be prepared again
+const (
+	errUnknownStmtHandler = 1243
+	errNeedReprepare      = 1615
+)
+
+// StatementCacheStats - Counters of prepared statement caches
+type StatementCacheStats struct {
+	Hits      uint64
+	Misses    uint64
+	Evictions uint64
+	// Size - statements currently cached
+	Size int
+}
+
+// HitRate - Share of lookups served by an already prepared statement, 0 without lookups
+func (s StatementCacheStats) HitRate() float64 {
+	if s.Hits+s.Misses == 0 {
+		return 0
+	}
+
+	return float64(s.Hits) / float64(s.Hits+s.Misses)
+}
+
+func (s StatementCacheStats) add(other StatementCacheStats) StatementCacheStats {
+	return StatementCacheStats{
+		Hits:      s.Hits + other.Hits,
+		Misses:    s.Misses + other.Misses,
+		Evictions: s.Evictions + other.Evictions,
+		Size:      s.Size + other.Size,
+	}
+}
+
+// stmtCache - LRU of the statements prepared on a pool keyed by normalized sql. database/sql
+// prepares a statement again on every connection of the pool it is run on, so capacity bounds the
+// shapes kept, not the server side statements. Statements are counted while in use, one dropped
+// from the cache is only closed once its last user released it.
+type stmtCache struct {
+	db       *sql.DB
+	capacity int
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	order   *list.List
+	stats   StatementCacheStats
+}
+
+type stmtCacheEntry struct {
+	key  string
+	stmt *sql.Stmt
+	// users - callers of prepare which did not release the statement yet
+	users int
+	// removed - dropped from the cache, closed by its last user
+	removed bool
+}
+
+func newStmtCache(db *sql.DB, capacity int) *stmtCache {
+	return &stmtCache{
+		db:       db,
+		capacity: capacity,
+		entries:  map[string]*list.Element{},
+		order:    list.New(),
+	}
+}
+
+// prepare - Statement for query, prepared on a miss. The statement stays open until release is
+// called, even when it is evicted meanwhile.
+func (c *stmtCache) prepare(ctx context.Context, query string) (*sql.Stmt, func(), error) {
+	key := normalizeSQL(query)
+
+	c.mu.Lock()
+	if element, ok := c.entries[key]; ok {
+		c.order.MoveToFront(element)
+		c.stats.Hits++
+		stmt, release := c.use(element.Value.(*stmtCacheEntry))
+		c.mu.Unlock()
+		return stmt, release, nil
+	}
+	c.stats.Misses++
+	c.mu.Unlock()
+
+	// Prepared without holding the lock, concurrent misses of the same query keep the first
+	stmt, err := c.db.PrepareContext(ctx, query)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if element, ok := c.entries[key]; ok {
+		_ = stmt.Close()
+		c.order.MoveToFront(element)
+		stmt, release := c.use(element.Value.(*stmtCacheEntry))
+		return stmt, release, nil
+	}
+
+	entry := &stmtCacheEntry{key: key, stmt: stmt}
+	c.entries[key] = c.order.PushFront(entry)
+	stmt, release := c.use(entry)
+	for c.order.Len() > c.capacity {
+		c.remove(c.order.Back())
+	}
+
+	return stmt, release, nil
+}
+
+// use - Count a user of the statement of entry until the returned release is called, c.mu is held
+func (c *stmtCache) use(entry *stmtCacheEntry) (*sql.Stmt, func()) {
+	entry.users++
+
+	var once sync.Once
+	return entry.stmt, func() {
+		once.Do(func() {
+			c.mu.Lock()
+			defer c.mu.Unlock()
+
+			entry.users--
+			if entry.removed && entry.users == 0 {
+				_ = entry.stmt.Close()
+			}
+		})
+	}
+}
+
+// evict - Drop the statement of query, it is closed once its users released it
+func (c *stmtCache) evict(query string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if element, ok := c.entries[normalizeSQL(query)]; ok {
+		c.remove(element)
+	}
+}
+
+// purge - Drop every statement, eg when the schema changed
+func (c *stmtCache) purge() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for c.order.Len() > 0 {
+		c.remove(c.order.Back())
+	}
+}
+
+// remove - Drop the entry of element, its statement is closed now if nobody uses it, c.mu is held
+func (c *stmtCache) remove(element *list.Element) {
+	entry := c.order.Remove(element).(*stmtCacheEntry)
+	delete(c.entries, entry.key)
+	entry.removed = true
+	if entry.users == 0 {
+		_ = entry.stmt.Close()
+	}
+	c.stats.Evictions++
+}
+
+func (c *stmtCache) snapshot() StatementCacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	stats := c.stats
+	stats.Size = c.order.Len()
+	return stats
+}
+
+// normalizeSQL - query with runs of whitespace outside of quotes collapsed to a single space
+func normalizeSQL(query string) string {
+	var (
+		normalized strings.Builder
+		quote      rune
+		escaped    bool
+		space      bool
+	)
+
+	for _, char := range strings.TrimSpace(query) {
+		switch {
+		case escaped:
+			escaped = false
+		case quote != 0 && char == '\\':
+			escaped = true
+		case quote != 0:
+			if char == quote {
+				quote = 0
+			}
+		case char == '\'' || char == '"' || char == '`':
+			quote = char
+		case char == ' ' || char == '\t' || char == '\n' || char == '\r':
+			space = true
+			continue
+		}
+
+		if space {
+			normalized.WriteByte(' ')
+			space = false
+		}
+		normalized.WriteRune(char)
+	}
+
+	return normalized.String()
+}
+
+// isConnectionError - Whether err means the connection or the prepared statement on it is unusable
+func isConnectionError(err error) bool {
+	if errors.Is(err, driver.ErrBadConn) || errors.Is(err, mysqldriver.ErrInvalidConn) {
+		return true
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return true
+	}
+
+	var mysqlErr *mysqldriver.MySQLError
+	if errors.As(err, &mysqlErr) {
+		return mysqlErr.Number == errUnknownStmtHandler || mysqlErr.Number == errNeedReprepare
+	}
+
+	return false
+}
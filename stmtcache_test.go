@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"testing"
+
+	mysqldriver "github.com/go-sql-driver/mysql"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNormalizeSQL(t *testing.T) {
+	assert.Equal(t, "SELECT `id` FROM `payments` WHERE `id` > ?;", normalizeSQL("  SELECT `id`\n\tFROM   `payments`\nWHERE `id` > ?; "))
+	assert.Equal(t, "SELECT 'a  b', `c  d`", normalizeSQL("SELECT  'a  b',   `c  d`"))
+	assert.Equal(t, "SELECT 'it\\'s  x' FROM t", normalizeSQL("SELECT 'it\\'s  x'   FROM t"))
+}
+
+func TestIsConnectionError(t *testing.T) {
+	assert.True(t, isConnectionError(mysqldriver.ErrInvalidConn))
+	assert.True(t, isConnectionError(&mysqldriver.MySQLError{Number: errUnknownStmtHandler}))
+	assert.False(t, isConnectionError(&mysqldriver.MySQLError{Number: 1064}))
+	assert.False(t, isConnectionError(errors.New("syntax")))
+}
+
+func TestStatementCacheStats(t *testing.T) {
+	stats := StatementCacheStats{Hits: 3, Misses: 1}.add(StatementCacheStats{Hits: 1, Misses: 3, Size: 2})
+	assert.Equal(t, StatementCacheStats{Hits: 4, Misses: 4, Size: 2}, stats)
+	assert.Equal(t, 0.5, stats.HitRate())
+	assert.Equal(t, float64(0), StatementCacheStats{}.HitRate())
+}
+
+// fakeDriver - driver whose statements run without a server and return no rows
+type fakeDriver struct{}
+
+func (fakeDriver) Open(name string) (driver.Conn, error) { return fakeConn{}, nil }
+
+type fakeConn struct{}
+
+func (fakeConn) Prepare(query string) (driver.Stmt, error) { return fakeStmt{}, nil }
+func (fakeConn) Close() error                              { return nil }
+func (fakeConn) Begin() (driver.Tx, error)                 { return nil, errors.New("no transactions") }
+
+type fakeStmt struct{}
+
+func (fakeStmt) Close() error                                    { return nil }
+func (fakeStmt) NumInput() int                                   { return -1 }
+func (fakeStmt) Exec(args []driver.Value) (driver.Result, error) { return driver.RowsAffected(0), nil }
+func (fakeStmt) Query(args []driver.Value) (driver.Rows, error)  { return fakeRows{}, nil }
+
+type fakeRows struct{}
+
+func (fakeRows) Columns() []string              { return []string{"id"} }
+func (fakeRows) Close() error                   { return nil }
+func (fakeRows) Next(dest []driver.Value) error { return io.EOF }
+
+func init() {
+	sql.Register("stmtcache_test", fakeDriver{})
+}
+
+func TestStmtCache_EvictInUse(t *testing.T) {
+	db, err := sql.Open("stmtcache_test", "")
+	assert.Nil(t, err)
+	defer db.Close()
+
+	ctx := context.Background()
+	cache := newStmtCache(db, 1)
+
+	stmt, release, err := cache.prepare(ctx, "SELECT `id` FROM `a` WHERE `id` IN (?)")
+	assert.Nil(t, err)
+	// Another shape evicts the statement while it is in use
+	_, releaseOther, err := cache.prepare(ctx, "SELECT `id` FROM `a` WHERE `id` IN (?, ?)")
+	assert.Nil(t, err)
+	releaseOther()
+	assert.Equal(t, StatementCacheStats{Misses: 2, Evictions: 1, Size: 1}, cache.snapshot())
+
+	rows, err := stmt.QueryContext(ctx, 1)
+	if assert.Nil(t, err) {
+		assert.Nil(t, rows.Close())
+	}
+
+	// The last user closes it
+	release()
+	release()
+	_, err = stmt.QueryContext(ctx, 1)
+	assert.EqualError(t, err, "sql: statement is closed")
+
+	// So does a purge while the statement is in use
+	stmt, release, err = cache.prepare(ctx, "SELECT `id` FROM `b`")
+	assert.Nil(t, err)
+	cache.purge()
+	_, err = stmt.ExecContext(ctx)
+	assert.Nil(t, err)
+	release()
+	_, err = stmt.ExecContext(ctx)
+	assert.EqualError(t, err, "sql: statement is closed")
+}
@@ -0,0 +1,318 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/gqlerrors"
+	"github.com/graphql-go/graphql/language/ast"
+)
+
+// DefaultPollInterval - time between two polls of the change column of an entity
+const DefaultPollInterval = time.Second
+
+// Change - rows of Entity changed, Rows holds the changed rows when the feed knows them. A nil
+// row is a row whose values are not known.
+type Change struct {
+	Entity string
+	Rows   []map[string]interface{}
+}
+
+// ChangeFeed - Source of the changes subscriptions are run again on. Implementations must be safe
+// for concurrent use.
+type ChangeFeed interface {
+	// Watch - Call notify whenever rows of entity changed until stop is called
+	Watch(entity string, notify func(Change)) (stop func(), err error)
+}
+
+// QueryError - request rejected before it ran, with the GraphQL errors explaining why
+type QueryError struct {
+	Errors []gqlerrors.FormattedError
+}
+
+func (e *QueryError) Error() string {
+	if len(e.Errors) == 0 {
+		return "invalid query"
+	}
+
+	return e.Errors[0].Message
+}
+
+// Subscribe - Results of the operation of request, a subscription sends its first result right
+// away and a new one whenever a change of its entities changed the result. Queries send their
+// single result. The channel is closed once ctx is done.
+func (e *Engine) Subscribe(ctx context.Context, request *GraphQLRequest) (<-chan *graphql.Result, error) {
+	ctx, op, err := e.parseOperation(ctx, request)
+	if err != nil {
+		return nil, err
+	}
+
+	if op.kind != ast.OperationTypeSubscription {
+		results := make(chan *graphql.Result, 1)
+		results <- e.Execute(ctx, request)
+		close(results)
+		return results, nil
+	}
+
+	if e.ChangeFeed == nil {
+		return nil, errors.New("subscriptions are not enabled")
+	}
+
+	graphqlSchema, err := e.operationSchema(ctx, op)
+	if err != nil {
+		return nil, err
+	}
+	if validation := graphql.ValidateDocument(graphqlSchema, op.document, nil); !validation.IsValid {
+		return nil, &QueryError{Errors: validation.Errors}
+	}
+
+	tableSchema, err := e.entitySchema(ctx, op.entity)
+	if err != nil {
+		return nil, err
+	}
+	matches, err := changeMatcher(op, SessionFromContext(ctx), tableSchema)
+	if err != nil {
+		return nil, err
+	}
+
+	// Changes arriving while the operation runs are coalesced into a single run after it
+	changed := make(chan struct{}, 1)
+	notify := func(change Change) {
+		if !matches(change) {
+			return
+		}
+		select {
+		case changed <- struct{}{}:
+		default:
+		}
+	}
+
+	var stops []func()
+	stopAll := func() {
+		for _, stop := range stops {
+			stop()
+		}
+	}
+	watched := map[string]bool{}
+	for _, entity := range op.entities {
+		if watched[entity] {
+			continue
+		}
+		watched[entity] = true
+
+		stop, err := e.ChangeFeed.Watch(entity, notify)
+		if err != nil {
+			stopAll()
+			return nil, err
+		}
+		stops = append(stops, stop)
+	}
+
+	results := make(chan *graphql.Result)
+	go func() {
+		defer close(results)
+		defer stopAll()
+
+		var last []byte
+		for {
+			result := e.run(ctx, graphqlSchema, op)
+			if ctx.Err() != nil {
+				return
+			}
+
+			// A change of rows the subscription does not see leaves its result as it was
+			encoded, err := json.Marshal(result)
+			if err != nil || !bytes.Equal(encoded, last) {
+				last = encoded
+				select {
+				case results <- result:
+				case <-ctx.Done():
+					return
+				}
+			}
+
+			select {
+			case <-changed:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return results, nil
+}
+
+// changeMatcher - Whether a change may affect the result of op for session. Changed rows of the
+// entity are matched against the where argument and the row filter of the session, a change
+// without rows or of a related entity always may. The where argument names fields, which are
+// translated to the columns of the changed rows with tableSchema.
+func changeMatcher(op *operation, session *Session, tableSchema TableSchema) (func(Change) bool, error) {
+	rowFilter, err := RowFilter(op.entity, session)
+	if err != nil {
+		return nil, err
+	}
+
+	where, _ := FieldArguments(op.field, op.variables)["where"].(map[string]interface{})
+	where = columnArguments(op.entity, tableSchema, QueryArguments{Filter: where}).Filter
+
+	return func(change Change) bool {
+		if change.Entity != op.entity || len(change.Rows) == 0 {
+			return true
+		}
+
+		for _, row := range change.Rows {
+			if row == nil || (MatchFilter(rowFilter, row) && MatchFilter(where, row)) {
+				return true
+			}
+		}
+
+		return false
+	}, nil
+}
+
+// PollingFeed - ChangeFeed polling the maximum of the change column of entities, an indexed
+// updated_at or version column. Entities are polled once however many subscriptions watch them.
+// Deleting rows other than the latest one is not noticed.
+type PollingFeed struct {
+	engine   *Engine
+	interval time.Duration
+
+	mu      sync.Mutex
+	pollers map[string]*poller
+	nextID  int
+}
+
+type poller struct {
+	version  string
+	watchers map[int]func(Change)
+	stop     chan struct{}
+}
+
+// NewPollingFeed - PollingFeed polling the entities of engine every interval,
+// DefaultPollInterval if interval is not positive
+func NewPollingFeed(engine *Engine, interval time.Duration) *PollingFeed {
+	if interval <= 0 {
+		interval = DefaultPollInterval
+	}
+
+	return &PollingFeed{
+		engine:   engine,
+		interval: interval,
+		pollers:  map[string]*poller{},
+	}
+}
+
+func (f *PollingFeed) Watch(entity string, notify func(Change)) (func(), error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	p, ok := f.pollers[entity]
+	if !ok {
+		// The first version is read before Watch returns so that no change after it is missed
+		version, err := f.version(entity)
+		if err != nil {
+			return nil, err
+		}
+
+		p = &poller{version: version, watchers: map[int]func(Change){}, stop: make(chan struct{})}
+		f.pollers[entity] = p
+		go f.poll(entity, p)
+	}
+
+	f.nextID++
+	id := f.nextID
+	p.watchers[id] = notify
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			f.mu.Lock()
+			defer f.mu.Unlock()
+
+			delete(p.watchers, id)
+			if len(p.watchers) == 0 {
+				close(p.stop)
+				delete(f.pollers, entity)
+			}
+		})
+	}, nil
+}
+
+func (f *PollingFeed) poll(entity string, p *poller) {
+	ticker := time.NewTicker(f.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-p.stop:
+			return
+		case <-ticker.C:
+		}
+
+		version, err := f.version(entity)
+		if err != nil {
+			log.Printf("failed to poll changes of %s, error: %v", entity, err)
+			continue
+		}
+		if version == p.version {
+			continue
+		}
+		p.version = version
+		// Watchers query the entity again, they must not be served the results cached before
+		f.engine.InvalidateEntity(entity)
+
+		f.mu.Lock()
+		watchers := make([]func(Change), 0, len(p.watchers))
+		for _, notify := range p.watchers {
+			watchers = append(watchers, notify)
+		}
+		f.mu.Unlock()
+
+		for _, notify := range watchers {
+			notify(Change{Entity: entity})
+		}
+	}
+}
+
+// version - Latest value of the change column of entity
+func (f *PollingFeed) version(entity string) (string, error) {
+	column := Entities.GetChangeColumn(entity)
+	if column == "" {
+		return "", fmt.Errorf("entity %q has no change column to poll", entity)
+	}
+
+	dataSource, err := f.engine.DataSource(entity)
+	if err != nil {
+		return "", err
+	}
+
+	ctx := context.Background()
+	tableSchema, err := dataSource.GetTableSchema(ctx, Entities.GetTableName(entity))
+	if err != nil {
+		return "", err
+	}
+	columnType, ok := tableSchema[column]
+	if !ok {
+		return "", fmt.Errorf("change column %q of entity %q does not exist", column, entity)
+	}
+
+	statement := &Statement{
+		SQL: fmt.Sprintf("SELECT MAX(`%s`) AS `version` FROM `%s`;", column, Entities.GetTableName(entity)),
+	}
+	result, err := f.engine.fetch(ctx, dataSource, statement, Entities.GetStatementTimeout(entity), TableSchema{"version": columnType})
+	if err != nil {
+		return "", err
+	}
+	if len(result.Rows) == 0 {
+		return "", nil
+	}
+
+	return fmt.Sprint(result.Rows[0]["version"]), nil
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/graphql-go/graphql"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeFeed - change feed reporting the changes the test announces
+type fakeFeed struct {
+	mu       sync.Mutex
+	watchers map[string]map[int]func(Change)
+	nextID   int
+}
+
+func (f *fakeFeed) Watch(entity string, notify func(Change)) (func(), error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.watchers == nil {
+		f.watchers = map[string]map[int]func(Change){}
+	}
+	if f.watchers[entity] == nil {
+		f.watchers[entity] = map[int]func(Change){}
+	}
+	f.nextID++
+	id := f.nextID
+	f.watchers[entity][id] = notify
+
+	return func() {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		delete(f.watchers[entity], id)
+	}, nil
+}
+
+func (f *fakeFeed) change(entity string, rows ...map[string]interface{}) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for _, notify := range f.watchers[entity] {
+		notify(Change{Entity: entity, Rows: rows})
+	}
+}
+
+func (f *fakeFeed) watching(entity string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return len(f.watchers[entity])
+}
+
+func (f *fakeDataSource) setRows(rows []map[string]interface{}) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.rows = rows
+}
+
+func receive(results <-chan *graphql.Result) (*graphql.Result, bool) {
+	select {
+	case result, ok := <-results:
+		return result, ok
+	case <-time.After(time.Second):
+		return nil, false
+	}
+}
+
+func TestEngine_Subscribe(t *testing.T) {
+	Entities["subscription_test"] = EntityConfig{TableName: "subscription_test"}
+	defer delete(Entities, "subscription_test")
+
+	dataSource := &fakeDataSource{
+		schema: TableSchema{"id": "int", "status": "varchar(10)"},
+		rows:   []map[string]interface{}{{"id": int64(1), "status": "pending"}},
+	}
+	engine := NewEngine(map[string]DataSource{DefaultDataSource: dataSource})
+
+	_, err := engine.Subscribe(context.Background(), &GraphQLRequest{Query: `subscription { subscription_test { id } }`})
+	assert.NotNil(t, err)
+
+	feed := &fakeFeed{}
+	engine.ChangeFeed = feed
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	results, err := engine.Subscribe(ctx, &GraphQLRequest{
+		Query:     `subscription Status($limit: Int) { subscription_test(limit: $limit) { id status } }`,
+		Variables: map[string]interface{}{"limit": 10},
+	})
+	assert.Nil(t, err)
+
+	result, ok := receive(results)
+	assert.True(t, ok)
+	assert.Equal(t, map[string]interface{}{"subscription_test": []interface{}{
+		map[string]interface{}{"id": 1, "status": "pending"},
+	}}, result.Data)
+
+	// Changes leaving the result as it was are not sent
+	feed.change("subscription_test")
+	assert.Eventually(t, func() bool {
+		dataSource.mu.Lock()
+		defer dataSource.mu.Unlock()
+		return len(dataSource.queries) == 2
+	}, time.Second, time.Millisecond)
+
+	dataSource.setRows([]map[string]interface{}{{"id": int64(1), "status": "settled"}})
+	feed.change("subscription_test")
+	result, ok = receive(results)
+	assert.True(t, ok)
+	assert.Equal(t, map[string]interface{}{"subscription_test": []interface{}{
+		map[string]interface{}{"id": 1, "status": "settled"},
+	}}, result.Data)
+
+	cancel()
+	_, ok = receive(results)
+	assert.False(t, ok)
+	assert.Eventually(t, func() bool { return feed.watching("subscription_test") == 0 }, time.Second, time.Millisecond)
+
+	// Queries send their result once
+	results, err = engine.Subscribe(context.Background(), &GraphQLRequest{Query: `{ subscription_test { id } }`})
+	assert.Nil(t, err)
+	result, ok = receive(results)
+	assert.True(t, ok)
+	assert.Empty(t, result.Errors)
+	_, ok = receive(results)
+	assert.False(t, ok)
+
+	_, err = engine.Subscribe(context.Background(), &GraphQLRequest{Query: `subscription { subscription_test { unknown } }`})
+	assert.IsType(t, &QueryError{}, err)
+
+	result = engine.Query(context.Background(), `subscription { subscription_test { id } }`)
+	assert.NotEmpty(t, result.Errors)
+}
+
+func TestEngine_SubscribeMatchingRows(t *testing.T) {
+	// Changed rows carry columns, the where argument the field name of merchant_id
+	Entities["subscription_test"] = EntityConfig{
+		TableName:   "subscription_test",
+		ColumnNames: map[string]string{"merchant_id": "merchant"},
+	}
+	defer delete(Entities, "subscription_test")
+
+	dataSource := &fakeDataSource{schema: TableSchema{"id": "int", "merchant_id": "varchar(10)"}}
+	feed := &fakeFeed{}
+	engine := NewEngine(map[string]DataSource{DefaultDataSource: dataSource})
+	engine.ChangeFeed = feed
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	results, err := engine.Subscribe(ctx, &GraphQLRequest{
+		Query:     `subscription Merchant($merchant: String) { subscription_test(where: {merchant: {_eq: $merchant}}) { id } }`,
+		Variables: map[string]interface{}{"merchant": "m1"},
+	})
+	assert.Nil(t, err)
+	_, ok := receive(results)
+	assert.True(t, ok)
+
+	queries := func() int {
+		dataSource.mu.Lock()
+		defer dataSource.mu.Unlock()
+		return len(dataSource.queries)
+	}
+
+	// Rows of other merchants do not wake the subscription
+	feed.change("subscription_test", map[string]interface{}{"id": int32(2), "merchant_id": "m2"})
+	time.Sleep(20 * time.Millisecond)
+	assert.Equal(t, 1, queries())
+
+	dataSource.setRows([]map[string]interface{}{{"id": int64(1)}})
+	feed.change("subscription_test", map[string]interface{}{"id": int32(1), "merchant_id": "m1"})
+	result, ok := receive(results)
+	assert.True(t, ok)
+	assert.Equal(t, map[string]interface{}{"subscription_test": []interface{}{map[string]interface{}{"id": 1}}}, result.Data)
+	assert.Equal(t, 2, queries())
+}
+
+func TestPollingFeed(t *testing.T) {
+	Entities["polling_test"] = EntityConfig{
+		TableName:    "polling_test",
+		ChangeColumn: "updated_at",
+	}
+	Entities["unpolled_test"] = EntityConfig{TableName: "unpolled_test"}
+	defer delete(Entities, "polling_test")
+	defer delete(Entities, "unpolled_test")
+
+	dataSource := &fakeDataSource{
+		schema: TableSchema{"id": "int", "updated_at": "datetime"},
+		pages: [][]map[string]interface{}{
+			{{"version": "2021-01-01 00:00:00"}},
+			{{"version": "2021-01-01 00:00:00"}},
+			{{"version": "2021-01-02 00:00:00"}},
+		},
+	}
+	engine := NewEngine(map[string]DataSource{DefaultDataSource: dataSource})
+	engine.Cache = NewLRUCache(10)
+	engine.Cache.Set("polling_test", []byte("{}"), time.Hour, []string{"polling_test"})
+	feed := NewPollingFeed(engine, time.Millisecond)
+
+	changes := make(chan Change, 10)
+	stop, err := feed.Watch("polling_test", func(change Change) { changes <- change })
+	assert.Nil(t, err)
+
+	select {
+	case change := <-changes:
+		assert.Equal(t, Change{Entity: "polling_test"}, change)
+	case <-time.After(time.Second):
+		t.Fatal("no change reported")
+	}
+	stop()
+	// Results cached before the change are dropped
+	_, ok := engine.Cache.Get("polling_test")
+	assert.False(t, ok)
+
+	dataSource.mu.Lock()
+	assert.GreaterOrEqual(t, len(dataSource.queries), 3)
+	assert.Equal(t, "SELECT MAX(`updated_at`) AS `version` FROM `polling_test`;", dataSource.queries[0].SQL)
+	dataSource.mu.Unlock()
+
+	_, err = feed.Watch("unpolled_test", func(Change) {})
+	assert.NotNil(t, err)
+}
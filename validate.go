@@ -0,0 +1,158 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Codes of validation errors, in the code extension of the GraphQL error
+const (
+	UnknownEntity        = "UNKNOWN_ENTITY"
+	FilterNotAllowed     = "FILTER_NOT_ALLOWED"
+	UnknownOperator      = "UNKNOWN_OPERATOR"
+	InvalidOperand       = "INVALID_OPERAND"
+	ColumnNotSortable    = "COLUMN_NOT_SORTABLE"
+	InvalidSortDirection = "INVALID_SORT_DIRECTION"
+	FieldNotProjectable  = "FIELD_NOT_PROJECTABLE"
+)
+
+// ValidationError - argument or field of a query rejected before any SQL is built for it, Field is
+// the entity, column or operator at fault
+type ValidationError struct {
+	Code    string
+	Field   string
+	Message string
+}
+
+func (e *ValidationError) Error() string {
+	return e.Message
+}
+
+func (e *ValidationError) Extensions() map[string]interface{} {
+	return map[string]interface{}{"code": e.Code, "field": e.Field}
+}
+
+// QueryArguments - arguments of a query on an entity, named by the fields of the entity as sent
+// by the client until columnArguments names them by the columns for the Querier
+type QueryArguments struct {
+	Filter  map[string]interface{}
+	OrderBy []map[string]interface{}
+	// Fields - names of the fields selected on the entity, columns and relations
+	Fields []string
+}
+
+// entityOf - Entity queried by the query field named name, an UNKNOWN_ENTITY error if there is none
+func entityOf(name string) (string, error) {
+	entity, ok := Entities.EntityOf(name)
+	if !ok {
+		return "", &ValidationError{UnknownEntity, name, fmt.Sprintf("unknown entity %q", name)}
+	}
+
+	return entity, nil
+}
+
+// ValidateEntity - Reject entities which are not configured, their name would end up in SQL
+func ValidateEntity(entity string) error {
+	if _, ok := Entities[entity]; !ok {
+		return &ValidationError{UnknownEntity, entity, fmt.Sprintf("unknown entity %q", entity)}
+	}
+
+	return nil
+}
+
+// ValidateQuery - Reject arguments of a query on entity the role may not use. The where and
+// order_by arguments are not validated against their types by graphql-go, and exports and
+// subscriptions build queries without going through its validation at all, so every column,
+// operator and direction is checked here before it reaches the Querier.
+func ValidateQuery(entity, role string, tableSchema TableSchema, arguments QueryArguments) error {
+	if err := ValidateEntity(entity); err != nil {
+		return err
+	}
+
+	columns := fieldColumns(entity, tableSchema)
+	computed := Entities.GetComputedFields(entity)
+	filterable := filterableFields(entity, role, tableSchema)
+	if err := CheckFilterable(arguments.Filter, filterable); err != nil {
+		return err
+	}
+	for _, column := range sortedKeys(arguments.Filter) {
+		if err := validateCondition(column, arguments.Filter[column]); err != nil {
+			return err
+		}
+	}
+
+	for _, criteria := range arguments.OrderBy {
+		for _, name := range sortedKeys(criteria) {
+			// Ordering by a masked column would give its values away
+			if !sortable(entity, role, columns, computed, name) {
+				return &ValidationError{ColumnNotSortable, name, fmt.Sprintf("can not order by %q", name)}
+			}
+			if direction, _ := criteria[name].(string); !strings.EqualFold(direction, "asc") && !strings.EqualFold(direction, "desc") {
+				return &ValidationError{InvalidSortDirection, name, fmt.Sprintf("order of %q must be asc or desc", name)}
+			}
+		}
+	}
+
+	relations := Entities.GetRelations(entity)
+	for _, field := range arguments.Fields {
+		if strings.HasPrefix(field, "__") {
+			continue
+		}
+		if _, ok := columns[field]; ok {
+			continue
+		}
+		if _, ok := relations[field]; ok {
+			continue
+		}
+		if computedField, ok := computed[field]; ok && computedVisible(entity, computedField, role) {
+			continue
+		}
+		return &ValidationError{FieldNotProjectable, field, fmt.Sprintf("can not select %q", field)}
+	}
+
+	return nil
+}
+
+// sortable - Whether role can order entity by the field name, a column which is not masked for the
+// role or an expression field reading none
+func sortable(entity, role string, columns map[string]string, computed map[string]ComputedField, name string) bool {
+	if column, ok := columns[name]; ok {
+		return !IsMasked(entity, column, role)
+	}
+	field, ok := computed[name]
+
+	return ok && field.Expression != "" && computedVisible(entity, field, role)
+}
+
+// validateCondition - Reject conditions on column with operators the Querier does not know, or
+// with operands of the wrong shape
+func validateCondition(column string, condition interface{}) error {
+	operators, ok := condition.(map[string]interface{})
+	if !ok {
+		if _, ok := condition.([]interface{}); ok {
+			return &ValidationError{InvalidOperand, column, fmt.Sprintf("%q can not equal a list", column)}
+		}
+		return nil
+	}
+
+	for _, operator := range sortedKeys(operators) {
+		if _, ok := sqlOperator[operator]; !ok {
+			return &ValidationError{UnknownOperator, operator, fmt.Sprintf("unknown operator %q on %q", operator, column)}
+		}
+
+		switch operators[operator].(type) {
+		case []interface{}:
+			if operator != In {
+				return &ValidationError{InvalidOperand, operator, fmt.Sprintf("%s on %q takes a single value", operator, column)}
+			}
+		case map[string]interface{}:
+			return &ValidationError{InvalidOperand, operator, fmt.Sprintf("%s on %q takes a value", operator, column)}
+		default:
+			if operator == In {
+				return &ValidationError{InvalidOperand, operator, fmt.Sprintf("%s on %q takes a list", operator, column)}
+			}
+		}
+	}
+
+	return nil
+}
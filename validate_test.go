@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateQuery(t *testing.T) {
+	Entities["validate_test"] = EntityConfig{
+		AllowedFilters: []string{"id", "status", "card_number"},
+		ColumnMasks: map[string]map[string]MaskRule{
+			"card_number": {AnyRole: {Strategy: MaskRedact}},
+		},
+		Relations: map[string]Relation{
+			"merchant": {Entity: "validate_merchants", Column: "merchant_id", RelatedColumn: "id"},
+		},
+	}
+	defer delete(Entities, "validate_test")
+
+	schema := TableSchema{"id": "int", "status": "varchar", "amount": "int", "card_number": "varchar", "merchant_id": "int"}
+
+	valid := QueryArguments{
+		Filter: map[string]interface{}{
+			"id":     map[string]interface{}{"_in": []interface{}{int64(1), int64(2)}, "_ne": int64(3)},
+			"status": "paid",
+		},
+		OrderBy: []map[string]interface{}{{"amount": "DESC"}, {"id": "asc"}},
+		Fields:  []string{"id", "merchant", "__typename"},
+	}
+	assert.Nil(t, ValidateQuery("validate_test", DefaultRole, schema, valid))
+
+	tests := []struct {
+		entity    string
+		arguments QueryArguments
+		code      string
+		field     string
+	}{
+		{"payments_v2", QueryArguments{}, UnknownEntity, "payments_v2"},
+		{"validate_test", QueryArguments{Filter: map[string]interface{}{"amount": int64(1)}}, FilterNotAllowed, "amount"},
+		{"validate_test", QueryArguments{Filter: map[string]interface{}{"card_number": "4111"}}, FilterNotAllowed, "card_number"},
+		{"validate_test", QueryArguments{Filter: map[string]interface{}{"id": map[string]interface{}{"_like": "1%"}}}, UnknownOperator, "_like"},
+		{"validate_test", QueryArguments{Filter: map[string]interface{}{"id": map[string]interface{}{"_in": int64(1)}}}, InvalidOperand, "_in"},
+		{"validate_test", QueryArguments{Filter: map[string]interface{}{"id": map[string]interface{}{"_eq": []interface{}{int64(1)}}}}, InvalidOperand, "_eq"},
+		{"validate_test", QueryArguments{Filter: map[string]interface{}{"id": []interface{}{int64(1)}}}, InvalidOperand, "id"},
+		{"validate_test", QueryArguments{OrderBy: []map[string]interface{}{{"created_at": "asc"}}}, ColumnNotSortable, "created_at"},
+		{"validate_test", QueryArguments{OrderBy: []map[string]interface{}{{"card_number": "asc"}}}, ColumnNotSortable, "card_number"},
+		{"validate_test", QueryArguments{OrderBy: []map[string]interface{}{{"id": "asc; DROP TABLE payments"}}}, InvalidSortDirection, "id"},
+		{"validate_test", QueryArguments{Fields: []string{"id", "password"}}, FieldNotProjectable, "password"},
+	}
+	for _, test := range tests {
+		err := ValidateQuery(test.entity, DefaultRole, schema, test.arguments)
+		if assert.IsType(t, &ValidationError{}, err) {
+			assert.Equal(t, map[string]interface{}{"code": test.code, "field": test.field}, err.(*ValidationError).Extensions())
+		}
+	}
+}
+
+func TestEngine_QueryValidation(t *testing.T) {
+	Entities["validation_test"] = EntityConfig{AllowedFilters: []string{"id"}}
+	defer delete(Entities, "validation_test")
+
+	dataSource := &fakeDataSource{schema: TableSchema{"id": "int", "status": "varchar"}}
+	engine := NewEngine(map[string]DataSource{DefaultDataSource: dataSource})
+
+	tests := map[string]string{
+		`{ validation_other { id } }`:                                    UnknownEntity,
+		`{ validation_test(where: {status: {_eq: "paid"}}) { id } }`:     FilterNotAllowed,
+		`{ validation_test(where: {id: {_regexp: ".*"}}) { id } }`:       UnknownOperator,
+		`{ validation_test(order_by: [{id: "asc, sleep(10)"}]) { id } }`: InvalidSortDirection,
+	}
+	for query, code := range tests {
+		result := engine.Query(context.Background(), query)
+		if assert.Len(t, result.Errors, 1, query) {
+			assert.Equal(t, code, result.Errors[0].Extensions["code"], query)
+		}
+	}
+	assert.Empty(t, dataSource.queries)
+}
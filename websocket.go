@@ -0,0 +1,311 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/gqlerrors"
+)
+
+// GraphQLTransportWS - WebSocket subprotocol of the graphql-ws library
+const GraphQLTransportWS = "graphql-transport-ws"
+
+// DefaultConnectionInitTimeout - time a client has to send connection_init after connecting
+const DefaultConnectionInitTimeout = 10 * time.Second
+
+// wsReadLimit - bytes a client message may have
+const wsReadLimit = 1 << 20
+
+// graphql-transport-ws message types
+const (
+	wsConnectionInit = "connection_init"
+	wsConnectionAck  = "connection_ack"
+	wsPing           = "ping"
+	wsPong           = "pong"
+	wsSubscribe      = "subscribe"
+	wsNext           = "next"
+	wsError          = "error"
+	wsComplete       = "complete"
+)
+
+// graphql-transport-ws close codes
+const (
+	wsBadRequest               = 4400
+	wsUnauthorized             = 4401
+	wsForbidden                = 4403
+	wsSubprotocolNotAcceptable = 4406
+	wsInitTimeout              = 4408
+	wsSubscriberExists         = 4409
+	wsTooManyInitRequests      = 4429
+)
+
+type wsMessage struct {
+	ID      string          `json:"id,omitempty"`
+	Type    string          `json:"type"`
+	Payload json.RawMessage `json:"payload,omitempty"`
+}
+
+// SubscriptionHandler - Serve subscriptions, and queries, over WebSocket with the
+// graphql-transport-ws protocol. With an authenticator the session is resolved from the upgrade
+// request with the string values of the connection_init payload as additional headers, as
+// browsers cannot set headers on WebSockets. Without one the session of the request context is
+// used.
+func SubscriptionHandler(engine *Engine, authenticator Authenticator, config HandlerConfig) http.Handler {
+	upgrader := websocket.Upgrader{Subprotocols: []string{GraphQLTransportWS}}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			// The upgrader replied with the error already
+			return
+		}
+		defer conn.Close()
+
+		if conn.Subprotocol() != GraphQLTransportWS {
+			closeWebSocket(conn, wsSubprotocolNotAcceptable, "Subprotocol not acceptable")
+			return
+		}
+
+		connection := &wsConnection{
+			engine:        engine,
+			authenticator: authenticator,
+			config:        config,
+			conn:          conn,
+			subscriptions: map[string]*wsSubscription{},
+		}
+		connection.serve(r)
+	})
+}
+
+type wsConnection struct {
+	engine        *Engine
+	authenticator Authenticator
+	config        HandlerConfig
+	conn          *websocket.Conn
+
+	writeMu sync.Mutex
+
+	mu            sync.Mutex
+	ctx           context.Context
+	subscriptions map[string]*wsSubscription
+	wg            sync.WaitGroup
+}
+
+type wsSubscription struct {
+	cancel context.CancelFunc
+}
+
+func (c *wsConnection) serve(r *http.Request) {
+	ctx, cancel := context.WithCancel(r.Context())
+	defer func() {
+		cancel()
+		c.wg.Wait()
+	}()
+
+	if r.Header.Get(PrimaryHeader) == "true" {
+		ctx = WithPrimaryRead(ctx)
+	}
+
+	timer := time.AfterFunc(c.config.connectionInitTimeout(), func() {
+		if c.session() == nil {
+			closeWebSocket(c.conn, wsInitTimeout, "Connection initialisation timeout")
+		}
+	})
+	defer timer.Stop()
+
+	c.conn.SetReadLimit(wsReadLimit)
+	for {
+		_, data, err := c.conn.ReadMessage()
+		if err != nil {
+			return
+		}
+
+		var message wsMessage
+		if err := json.Unmarshal(data, &message); err != nil {
+			closeWebSocket(c.conn, wsBadRequest, "Invalid message received")
+			return
+		}
+
+		switch message.Type {
+		case wsConnectionInit:
+			if c.session() != nil {
+				closeWebSocket(c.conn, wsTooManyInitRequests, "Too many initialisation requests")
+				return
+			}
+
+			sessionCtx, err := c.authenticate(ctx, r, message.Payload)
+			if err != nil {
+				closeWebSocket(c.conn, wsForbidden, "Forbidden")
+				return
+			}
+
+			c.mu.Lock()
+			c.ctx = sessionCtx
+			c.mu.Unlock()
+			c.write(&wsMessage{Type: wsConnectionAck})
+		case wsPing:
+			c.write(&wsMessage{Type: wsPong, Payload: message.Payload})
+		case wsPong:
+		case wsSubscribe:
+			sessionCtx := c.session()
+			if sessionCtx == nil {
+				closeWebSocket(c.conn, wsUnauthorized, "Unauthorized")
+				return
+			}
+
+			var request GraphQLRequest
+			if message.ID == "" || json.Unmarshal(message.Payload, &request) != nil {
+				closeWebSocket(c.conn, wsBadRequest, "Invalid message received")
+				return
+			}
+
+			if !c.subscribe(sessionCtx, message.ID, &request) {
+				closeWebSocket(c.conn, wsSubscriberExists, fmt.Sprintf("Subscriber for %s already exists", message.ID))
+				return
+			}
+		case wsComplete:
+			c.mu.Lock()
+			if subscription, ok := c.subscriptions[message.ID]; ok {
+				subscription.cancel()
+				delete(c.subscriptions, message.ID)
+			}
+			c.mu.Unlock()
+		default:
+			closeWebSocket(c.conn, wsBadRequest, "Invalid message received")
+			return
+		}
+	}
+}
+
+// session - Context with the session of the connection, nil until the connection is initialised
+func (c *wsConnection) session() context.Context {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.ctx
+}
+
+// authenticate - ctx with the session of the client, the payload of connection_init may carry the
+// credentials as headers
+func (c *wsConnection) authenticate(ctx context.Context, r *http.Request, payload json.RawMessage) (context.Context, error) {
+	if c.authenticator == nil {
+		return ctx, nil
+	}
+
+	authRequest := r.Clone(ctx)
+	var headers map[string]interface{}
+	if len(payload) > 0 && json.Unmarshal(payload, &headers) == nil {
+		for name, value := range headers {
+			if value, ok := value.(string); ok {
+				authRequest.Header.Set(name, value)
+			}
+		}
+	}
+
+	session, err := c.authenticator.Authenticate(authRequest)
+	if err != nil {
+		return nil, err
+	}
+
+	return WithSession(ctx, session), nil
+}
+
+// subscribe - Start the subscription id, false if there is one with the same id already
+func (c *wsConnection) subscribe(ctx context.Context, id string, request *GraphQLRequest) bool {
+	ctx, cancel := context.WithCancel(ctx)
+	subscription := &wsSubscription{cancel: cancel}
+
+	c.mu.Lock()
+	if _, ok := c.subscriptions[id]; ok {
+		c.mu.Unlock()
+		cancel()
+		return false
+	}
+	c.subscriptions[id] = subscription
+	c.mu.Unlock()
+
+	c.wg.Add(1)
+	go func() {
+		defer c.wg.Done()
+		defer cancel()
+
+		results, err := c.results(ctx, request)
+		if err != nil {
+			if c.finish(id, subscription) {
+				c.writePayload(id, wsError, subscriptionErrors(err))
+			}
+			return
+		}
+
+		for result := range results {
+			c.writePayload(id, wsNext, result)
+		}
+
+		// Subscriptions completed by the client are not completed again
+		if c.finish(id, subscription) {
+			c.write(&wsMessage{ID: id, Type: wsComplete})
+		}
+	}()
+
+	return true
+}
+
+func (c *wsConnection) results(ctx context.Context, request *GraphQLRequest) (<-chan *graphql.Result, error) {
+	resolved, err := resolveGraphQLRequest(request, c.config)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.engine.Subscribe(ctx, resolved)
+}
+
+// finish - Forget subscription, false if it was completed by the client or the connection closed
+func (c *wsConnection) finish(id string, subscription *wsSubscription) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.subscriptions[id] != subscription {
+		return false
+	}
+	delete(c.subscriptions, id)
+
+	return true
+}
+
+func (c *wsConnection) writePayload(id, messageType string, payload interface{}) {
+	encoded, err := json.Marshal(payload)
+	if err != nil {
+		encoded, _ = json.Marshal(subscriptionErrors(err))
+		messageType = wsError
+	}
+
+	c.write(&wsMessage{ID: id, Type: messageType, Payload: encoded})
+}
+
+func (c *wsConnection) write(message *wsMessage) {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	_ = c.conn.WriteJSON(message)
+}
+
+// subscriptionErrors - GraphQL errors of a subscription rejected before it ran
+func subscriptionErrors(err error) []gqlerrors.FormattedError {
+	if queryErr, ok := err.(*QueryError); ok {
+		return queryErr.Errors
+	}
+
+	return errorResult(err).Errors
+}
+
+func closeWebSocket(conn *websocket.Conn, code int, reason string) {
+	message := websocket.FormatCloseMessage(code, reason)
+	_ = conn.WriteControl(websocket.CloseMessage, message, time.Now().Add(time.Second))
+	_ = conn.Close()
+}
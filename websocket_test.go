@@ -0,0 +1,138 @@
+package main
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+)
+
+func dialWebSocket(t *testing.T, server *httptest.Server, subprotocols ...string) *websocket.Conn {
+	dialer := websocket.Dialer{Subprotocols: subprotocols}
+	conn, _, err := dialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	assert.Nil(t, err)
+
+	return conn
+}
+
+func sendWebSocket(t *testing.T, conn *websocket.Conn, message string) {
+	assert.Nil(t, conn.WriteMessage(websocket.TextMessage, []byte(message)))
+}
+
+func readWebSocket(t *testing.T, conn *websocket.Conn) map[string]interface{} {
+	_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+
+	var message map[string]interface{}
+	assert.Nil(t, conn.ReadJSON(&message))
+	return message
+}
+
+func closeCode(conn *websocket.Conn) int {
+	_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+	for {
+		if _, _, err := conn.ReadMessage(); err != nil {
+			if closeErr, ok := err.(*websocket.CloseError); ok {
+				return closeErr.Code
+			}
+			return 0
+		}
+	}
+}
+
+func TestSubscriptionHandler(t *testing.T) {
+	Entities["websocket_test"] = EntityConfig{
+		TableName: "websocket_test",
+		RowFilters: map[string]map[string]interface{}{
+			"merchant": {"merchant_id": map[string]interface{}{"_eq": "X-Merchant-Id"}},
+		},
+	}
+	defer delete(Entities, "websocket_test")
+
+	dataSource := &fakeDataSource{
+		schema: TableSchema{"id": "int", "merchant_id": "varchar(10)", "status": "varchar(10)"},
+		rows:   []map[string]interface{}{{"id": int64(1), "status": "pending"}},
+	}
+	feed := &fakeFeed{}
+	engine := NewEngine(map[string]DataSource{DefaultDataSource: dataSource})
+	engine.ChangeFeed = feed
+
+	authenticator := NewAPIKeyAuthenticator("", map[string]APIKey{
+		"secret": {Role: "merchant", Variables: map[string]string{"X-Merchant-Id": "m1"}},
+	})
+	server := httptest.NewServer(SubscriptionHandler(engine, authenticator, HandlerConfig{
+		ConnectionInitTimeout: 100 * time.Millisecond,
+	}))
+	defer server.Close()
+
+	conn := dialWebSocket(t, server, GraphQLTransportWS)
+	sendWebSocket(t, conn, `{"type": "connection_init", "payload": {"X-Api-Key": "secret"}}`)
+	assert.Equal(t, map[string]interface{}{"type": "connection_ack"}, readWebSocket(t, conn))
+
+	sendWebSocket(t, conn, `{"id": "1", "type": "subscribe", "payload": {"query": "subscription { websocket_test { id status } }"}}`)
+	assert.Equal(t, map[string]interface{}{"id": "1", "type": "next", "payload": map[string]interface{}{
+		"data": map[string]interface{}{"websocket_test": []interface{}{
+			map[string]interface{}{"id": float64(1), "status": "pending"},
+		}},
+	}}, readWebSocket(t, conn))
+
+	// Subscriptions see the rows of the session like queries do
+	dataSource.mu.Lock()
+	assert.Contains(t, dataSource.queries[0].SQL, "WHERE `merchant_id` = ?")
+	assert.Equal(t, "m1", dataSource.queries[0].Args[0])
+	dataSource.mu.Unlock()
+
+	sendWebSocket(t, conn, `{"type": "ping"}`)
+	assert.Equal(t, map[string]interface{}{"type": "pong"}, readWebSocket(t, conn))
+
+	dataSource.setRows([]map[string]interface{}{{"id": int64(1), "status": "settled"}})
+	feed.change("websocket_test")
+	message := readWebSocket(t, conn)
+	assert.Equal(t, "next", message["type"])
+	assert.Equal(t, "settled", message["payload"].(map[string]interface{})["data"].(map[string]interface{})["websocket_test"].([]interface{})[0].(map[string]interface{})["status"])
+
+	// Completed by the client, the subscription is not completed by the server
+	sendWebSocket(t, conn, `{"id": "1", "type": "complete"}`)
+	assert.Eventually(t, func() bool { return feed.watching("websocket_test") == 0 }, time.Second, time.Millisecond)
+
+	sendWebSocket(t, conn, `{"id": "2", "type": "subscribe", "payload": {"query": "{ websocket_test { id } }"}}`)
+	assert.Equal(t, "next", readWebSocket(t, conn)["type"])
+	assert.Equal(t, map[string]interface{}{"id": "2", "type": "complete"}, readWebSocket(t, conn))
+
+	sendWebSocket(t, conn, `{"id": "3", "type": "subscribe", "payload": {"query": "subscription { websocket_test { unknown } }"}}`)
+	message = readWebSocket(t, conn)
+	assert.Equal(t, "error", message["type"])
+	assert.Len(t, message["payload"], 1)
+
+	sendWebSocket(t, conn, `{"id": "4", "type": "subscribe", "payload": {"query": "subscription { websocket_test { id } }"}}`)
+	assert.Equal(t, "next", readWebSocket(t, conn)["type"])
+	sendWebSocket(t, conn, `{"id": "4", "type": "subscribe", "payload": {"query": "subscription { websocket_test { id } }"}}`)
+	assert.Equal(t, wsSubscriberExists, closeCode(conn))
+	assert.Eventually(t, func() bool { return feed.watching("websocket_test") == 0 }, time.Second, time.Millisecond)
+
+	conn = dialWebSocket(t, server, GraphQLTransportWS)
+	sendWebSocket(t, conn, `{"id": "1", "type": "subscribe", "payload": {"query": "subscription { websocket_test { id } }"}}`)
+	assert.Equal(t, wsUnauthorized, closeCode(conn))
+
+	conn = dialWebSocket(t, server, GraphQLTransportWS)
+	sendWebSocket(t, conn, `{"type": "connection_init", "payload": {"X-Api-Key": "wrong"}}`)
+	assert.Equal(t, wsForbidden, closeCode(conn))
+
+	conn = dialWebSocket(t, server, GraphQLTransportWS)
+	sendWebSocket(t, conn, `{"type": "connection_init", "payload": {"X-Api-Key": "secret"}}`)
+	readWebSocket(t, conn)
+	sendWebSocket(t, conn, `{"type": "connection_init"}`)
+	assert.Equal(t, wsTooManyInitRequests, closeCode(conn))
+
+	conn = dialWebSocket(t, server, GraphQLTransportWS)
+	assert.Equal(t, wsInitTimeout, closeCode(conn))
+
+	conn = dialWebSocket(t, server)
+	assert.Equal(t, wsSubprotocolNotAcceptable, closeCode(conn))
+
+	conn = dialWebSocket(t, server, GraphQLTransportWS)
+	sendWebSocket(t, conn, `{"type": "unknown"}`)
+	assert.Equal(t, wsBadRequest, closeCode(conn))
+}